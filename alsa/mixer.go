@@ -0,0 +1,254 @@
+package alsa
+
+/*
+#cgo LDFLAGS: -lasound
+#include <alsa/asoundlib.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/audio"
+)
+
+const mixerCard = "default"
+
+var errMixerClosed = errors.New("mixer is closed")
+
+//mixer implements audio.Mixer on top of the ALSA simple mixer API
+type mixer struct {
+	mutex sync.Mutex
+	h     *C.snd_mixer_t
+	elem  *C.snd_mixer_elem_t
+	name  string
+}
+
+//NewMixer opens the mixer of the default card and looks up the element called 'element'.
+//The element may carry an index after a comma (e.g. "PCM,1") just like in amixer.
+func NewMixer(element string) (audio.Mixer, error) {
+	name, index, err := parseElement(element)
+	if err != nil {
+		return nil, err
+	}
+	m := &mixer{name: element}
+	var ret C.int
+	if ret = C.snd_mixer_open(&m.h, 0); ret < 0 {
+		return nil, mixerError("could not open mixer", ret)
+	}
+	card := C.CString(mixerCard)
+	defer C.free(unsafe.Pointer(card))
+	if ret = C.snd_mixer_attach(m.h, card); ret < 0 {
+		m.Close()
+		return nil, mixerError("could not attach mixer to "+mixerCard, ret)
+	}
+	if ret = C.snd_mixer_selem_register(m.h, nil, nil); ret < 0 {
+		m.Close()
+		return nil, mixerError("could not register simple mixer", ret)
+	}
+	if ret = C.snd_mixer_load(m.h); ret < 0 {
+		m.Close()
+		return nil, mixerError("could not load mixer elements", ret)
+	}
+
+	var sid *C.snd_mixer_selem_id_t
+	if ret = C.snd_mixer_selem_id_malloc(&sid); ret < 0 {
+		m.Close()
+		return nil, mixerError("could not allocate mixer element id", ret)
+	}
+	defer C.snd_mixer_selem_id_free(sid)
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	C.snd_mixer_selem_id_set_name(sid, cname)
+	C.snd_mixer_selem_id_set_index(sid, C.uint(index))
+	if m.elem = C.snd_mixer_find_selem(m.h, sid); m.elem == nil {
+		m.Close()
+		return nil, fmt.Errorf("mixer element %s not found on card %s", element, mixerCard)
+	}
+	if log.GetLevel() >= log.DebugLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.alsa", "method": "NewMixer", "element": element}).
+			Debug("Mixer element opened")
+	}
+	return m, nil
+}
+
+func mixerError(msg string, code C.int) error {
+	return fmt.Errorf("%s: %s", msg, C.GoString(C.snd_strerror(code)))
+}
+
+func (m *mixer) Elements() ([]audio.MixerElement, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.h == nil {
+		return nil, errMixerClosed
+	}
+	elements := []audio.MixerElement{}
+	for e := C.snd_mixer_first_elem(m.h); e != nil; e = C.snd_mixer_elem_next(e) {
+		if C.snd_mixer_selem_is_active(e) == 0 {
+			continue
+		}
+		elements = append(elements, audio.MixerElement{
+			Name:      C.GoString(C.snd_mixer_selem_get_name(e)),
+			Index:     int(C.snd_mixer_selem_get_index(e)),
+			HasVolume: C.snd_mixer_selem_has_playback_volume(e) != 0,
+			HasSwitch: C.snd_mixer_selem_has_playback_switch(e) != 0,
+		})
+	}
+	return elements, nil
+}
+
+//refresh processes pending mixer events so that values changed by other clients (e.g. amixer) are visible
+//and checks that the element has the required control
+func (m *mixer) refresh(volume bool) error {
+	if m.h == nil {
+		return errMixerClosed
+	}
+	if volume && C.snd_mixer_selem_has_playback_volume(m.elem) == 0 {
+		return audio.ErrNoVolumeControl
+	}
+	if !volume && C.snd_mixer_selem_has_playback_switch(m.elem) == 0 {
+		return audio.ErrNoSwitchControl
+	}
+	if ret := C.snd_mixer_handle_events(m.h); ret < 0 {
+		return mixerError("could not handle mixer events", ret)
+	}
+	return nil
+}
+
+func (m *mixer) Volume() (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.refresh(true); err != nil {
+		return 0, err
+	}
+	var min, max, v C.long
+	var ret C.int
+	if ret = C.snd_mixer_selem_get_playback_volume_range(m.elem, &min, &max); ret < 0 {
+		return 0, mixerError("could not read volume range of "+m.name, ret)
+	}
+	if ret = C.snd_mixer_selem_get_playback_volume(m.elem, C.SND_MIXER_SCHN_FRONT_LEFT, &v); ret < 0 {
+		return 0, mixerError("could not read volume of "+m.name, ret)
+	}
+	return rawToPercent(int64(v), int64(min), int64(max)), nil
+}
+
+func (m *mixer) SetVolume(percent int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.refresh(true); err != nil {
+		return err
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("volume %d out of range 0-100", percent)
+	}
+	var min, max C.long
+	var ret C.int
+	if ret = C.snd_mixer_selem_get_playback_volume_range(m.elem, &min, &max); ret < 0 {
+		return mixerError("could not read volume range of "+m.name, ret)
+	}
+	v := percentToRaw(percent, int64(min), int64(max))
+	if ret = C.snd_mixer_selem_set_playback_volume_all(m.elem, C.long(v)); ret < 0 {
+		return mixerError("could not set volume of "+m.name, ret)
+	}
+	if log.GetLevel() >= log.DebugLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.alsa", "method": "SetVolume", "element": m.name, "percent": percent, "raw": v}).
+			Debug("Mixer volume set")
+	}
+	return nil
+}
+
+func (m *mixer) VolumeDB() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.refresh(true); err != nil {
+		return 0, err
+	}
+	var v C.long
+	if ret := C.snd_mixer_selem_get_playback_dB(m.elem, C.SND_MIXER_SCHN_FRONT_LEFT, &v); ret < 0 {
+		return 0, mixerError("could not read dB volume of "+m.name, ret)
+	}
+	return fromCentiDB(int64(v)), nil
+}
+
+func (m *mixer) SetVolumeDB(db float64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.refresh(true); err != nil {
+		return err
+	}
+	min, max, err := m.dbRange()
+	if err != nil {
+		return err
+	}
+	db = clampDB(db, min, max)
+	//round down so that we never end up louder than requested
+	if ret := C.snd_mixer_selem_set_playback_dB_all(m.elem, C.long(toCentiDB(db)), -1); ret < 0 {
+		return mixerError("could not set dB volume of "+m.name, ret)
+	}
+	if log.GetLevel() >= log.DebugLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.alsa", "method": "SetVolumeDB", "element": m.name, "dB": db}).
+			Debug("Mixer volume set")
+	}
+	return nil
+}
+
+func (m *mixer) DBRange() (float64, float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.refresh(true); err != nil {
+		return 0, 0, err
+	}
+	return m.dbRange()
+}
+
+func (m *mixer) dbRange() (float64, float64, error) {
+	var min, max C.long
+	if ret := C.snd_mixer_selem_get_playback_dB_range(m.elem, &min, &max); ret < 0 {
+		return 0, 0, mixerError("could not read dB range of "+m.name, ret)
+	}
+	return fromCentiDB(int64(min)), fromCentiDB(int64(max)), nil
+}
+
+func (m *mixer) Muted() (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.refresh(false); err != nil {
+		return false, err
+	}
+	var on C.int
+	if ret := C.snd_mixer_selem_get_playback_switch(m.elem, C.SND_MIXER_SCHN_FRONT_LEFT, &on); ret < 0 {
+		return false, mixerError("could not read playback switch of "+m.name, ret)
+	}
+	//the switch is "on" when the channel is audible
+	return on == 0, nil
+}
+
+func (m *mixer) SetMuted(muted bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.refresh(false); err != nil {
+		return err
+	}
+	var on C.int = 1
+	if muted {
+		on = 0
+	}
+	if ret := C.snd_mixer_selem_set_playback_switch_all(m.elem, on); ret < 0 {
+		return mixerError("could not set playback switch of "+m.name, ret)
+	}
+	return nil
+}
+
+func (m *mixer) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.h != nil {
+		C.snd_mixer_close(m.h)
+		m.h = nil
+		m.elem = nil
+	}
+}
@@ -0,0 +1,59 @@
+package alsa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//This file holds the parts of the simple mixer wrapper that do not need cgo so that they can be tested without it.
+
+//parseElement splits amixer style "name[,index]" element identifiers
+func parseElement(element string) (string, int, error) {
+	if element == "" {
+		return "", 0, fmt.Errorf("mixer element name is empty")
+	}
+	i := strings.LastIndex(element, ",")
+	if i < 0 {
+		return element, 0, nil
+	}
+	index, err := strconv.Atoi(element[i+1:])
+	if err != nil || index < 0 {
+		return "", 0, fmt.Errorf("invalid mixer element index in %s", element)
+	}
+	return element[:i], index, nil
+}
+
+//rawToPercent maps the raw control value 'v' linearly onto 0-100 over the range of the control
+func rawToPercent(v int64, min int64, max int64) int {
+	if max <= min {
+		return 0
+	}
+	return int(((v-min)*100 + (max-min)/2) / (max - min))
+}
+
+//percentToRaw is the inverse of rawToPercent
+func percentToRaw(percent int, min int64, max int64) int64 {
+	return min + ((max-min)*int64(percent)+50)/100
+}
+
+//clampDB limits 'db' to the range of the control
+func clampDB(db float64, min float64, max float64) float64 {
+	if db < min {
+		return min
+	}
+	if db > max {
+		return max
+	}
+	return db
+}
+
+//fromCentiDB converts the hundredths of dB ALSA uses for dB values
+func fromCentiDB(v int64) float64 {
+	return float64(v) / 100
+}
+
+//toCentiDB is the inverse of fromCentiDB; fractions of a hundredth are truncated
+func toCentiDB(db float64) int64 {
+	return int64(db * 100)
+}
@@ -0,0 +1,91 @@
+package alsa
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type SelemTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SelemTestSuite) TestParseElement() {
+	a := assert.New(suite.T())
+	for _, c := range []struct {
+		element string
+		name    string
+		index   int
+		err     bool
+	}{
+		{"PCM", "PCM", 0, false},
+		{"PCM,1", "PCM", 1, false},
+		{"Master Playback,0", "Master Playback", 0, false},
+		//only the last comma separates the index
+		{"Line,Out,2", "Line,Out", 2, false},
+		{"", "", 0, true},
+		{"PCM,", "", 0, true},
+		{"PCM,x", "", 0, true},
+		{"PCM,-1", "", 0, true},
+	} {
+		name, index, err := parseElement(c.element)
+		if c.err {
+			a.Error(err, "element %q", c.element)
+			continue
+		}
+		a.NoError(err, "element %q", c.element)
+		a.Equal(c.name, name, "element %q", c.element)
+		a.Equal(c.index, index, "element %q", c.element)
+	}
+}
+
+func (suite *SelemTestSuite) TestPercent() {
+	a := assert.New(suite.T())
+	for _, c := range []struct {
+		raw     int64
+		min     int64
+		max     int64
+		percent int
+	}{
+		{0, 0, 255, 0},
+		{255, 0, 255, 100},
+		{128, 0, 255, 50},
+		{-4000, -10000, 0, 60},
+		{-10000, -10000, 0, 0},
+		{5, 0, 10, 50},
+	} {
+		a.Equal(c.percent, rawToPercent(c.raw, c.min, c.max), "raw %d in %d-%d", c.raw, c.min, c.max)
+		a.Equal(c.raw, percentToRaw(c.percent, c.min, c.max), "%d%% of %d-%d", c.percent, c.min, c.max)
+	}
+	//controls without a range read as 0
+	a.Equal(0, rawToPercent(3, 3, 3))
+	//every percentage survives the round trip on a coarse control
+	for p := 0; p <= 100; p++ {
+		a.Equal(p, rawToPercent(percentToRaw(p, 0, 1000), 0, 1000))
+	}
+}
+
+func (suite *SelemTestSuite) TestDB() {
+	a := assert.New(suite.T())
+	for _, c := range []struct {
+		db       float64
+		clamped  float64
+		centiDBs int64
+	}{
+		{-20, -20, -2000},
+		{-80, -51, -5100},
+		{6, 0, 0},
+		{-12.345, -12.345, -1234},
+	} {
+		db := clampDB(c.db, -51, 0)
+		a.Equal(c.clamped, db, "%.3f dB", c.db)
+		a.Equal(c.centiDBs, toCentiDB(db), "%.3f dB", c.db)
+	}
+	a.Equal(-51.0, fromCentiDB(-5100))
+	a.Equal(0.25, fromCentiDB(25))
+}
+
+func TestSelemTestSuite(t *testing.T) {
+	suite.Run(t, new(SelemTestSuite))
+}
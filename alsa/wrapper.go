@@ -1,23 +1,72 @@
+//go:build cgo
+// +build cgo
+
 package alsa
 
 import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
 	goalsa "github.com/mklimuk/goalsa"
 	"github.com/mklimuk/test-alsa/audio"
 )
 
 const deviceName = "sysdefault"
-const format = goalsa.FormatS16LE
+
+//nativeFormats lists the formats tried when the device format is not configured, best first
+var nativeFormats = []audio.Format{audio.FormatS32LE, audio.FormatS24LE, audio.FormatS16LE}
+
+var alsaFormats = map[audio.Format]goalsa.Format{
+	audio.FormatS16LE:   goalsa.FormatS16LE,
+	audio.FormatS24LE:   goalsa.FormatS24LE,
+	audio.FormatS32LE:   goalsa.FormatS32LE,
+	audio.FormatFloatLE: goalsa.FormatFloatLE,
+}
+
+//rawDevice reports goalsa xruns as audio.ErrUnderrun; goalsa has already prepared the device for the next write
+type rawDevice struct {
+	*goalsa.PlaybackDevice
+}
+
+func (d rawDevice) Write(buffer interface{}) (int, error) {
+	n, err := d.PlaybackDevice.Write(buffer)
+	if err == goalsa.ErrUnderrun {
+		return n, audio.ErrUnderrun
+	}
+	return n, err
+}
 
 //Factory implements audio.DeviceFactory interface
 type Factory struct {
+	//Format forces the device sample format; when empty the best format accepted by the device is used
+	Format audio.Format
 }
 
 //New wraps goalsa PlaybackDevice constructor for testing convienience
 func (f *Factory) New(sampleRate int, channels int, bp *audio.BufferParams) (audio.PlaybackDevice, error) {
+	formats := nativeFormats
+	if f.Format != "" {
+		if _, ok := alsaFormats[f.Format]; !ok {
+			return nil, fmt.Errorf("sample format %s is not supported for playback", f.Format)
+		}
+		formats = []audio.Format{f.Format}
+	}
 	var err error
 	var dev *goalsa.PlaybackDevice
-	if dev, err = goalsa.NewPlaybackDevice(deviceName, channels, format, sampleRate, goalsa.BufferParams{BufferFrames: bp.BufferFrames, PeriodFrames: bp.PeriodFrames, Periods: bp.Periods}); err != nil {
-		return nil, err
+	for _, format := range formats {
+		if dev, err = goalsa.NewPlaybackDevice(deviceName, channels, alsaFormats[format], sampleRate, goalsa.BufferParams{BufferFrames: bp.BufferFrames, PeriodFrames: bp.PeriodFrames, Periods: bp.Periods}); err != nil {
+			if log.GetLevel() >= log.DebugLevel {
+				log.WithFields(log.Fields{"logger": "audio-endpoint.alsa", "method": "New", "format": format}).
+					WithError(err).Debug("Device rejected sample format")
+			}
+			continue
+		}
+		var raw audio.RawDevice
+		if raw, err = audio.NewFormatDevice(rawDevice{dev}, format); err != nil {
+			dev.Close()
+			return nil, err
+		}
+		return audio.NewPlaybackDevice(raw, bp.BufferFrames), nil
 	}
-	return audio.NewPlaybackDevice(dev, bp.BufferFrames), nil
+	return nil, err
 }
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/mklimuk/husar/rest"
+	"github.com/mklimuk/test-alsa/audio"
+	"github.com/mklimuk/test-alsa/config"
+)
+
+//defaultAlarmPriority is used when the configuration does not give one; it is above the priorities of announcements
+const defaultAlarmPriority = 100
+
+type alarmAPI struct {
+	a        audio.Playback
+	priority int
+}
+
+//NewAlarmAPI is the alarm signal API constructor
+func NewAlarmAPI(a audio.Playback, conf *config.AudioConf) rest.API {
+	c := alarmAPI{a, conf.AlarmPriority}
+	if c.priority <= 0 {
+		c.priority = defaultAlarmPriority
+	}
+	return rest.API(&c)
+}
+
+func (c *alarmAPI) AddRoutes(router *gin.Engine) {
+	router.POST("/audio/alarm", c.play)
+}
+
+// play starts the alarm signal named by the 'pattern' form field; it plays until stopped with DELETE /audio/play
+// giving a priority of at least the one of the alarm
+func (c *alarmAPI) play(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	pattern := audio.AlarmPattern(ctx.PostForm("pattern"))
+	clog := log.WithFields(log.Fields{"logger": "audio-endpoint.api", "method": "alarm", "pattern": pattern})
+	if !pattern.Valid() {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pattern; expected slow-whoop, continuous or pulsed"})
+		return
+	}
+	priority := c.priority
+	if p := ctx.PostForm("priority"); p != "" {
+		var err error
+		if priority, err = strconv.Atoi(p); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid priority"})
+			return
+		}
+	}
+	//volume 0 mutes the stream; without it the stream plays at unity gain
+	var volume *int
+	if v := ctx.PostForm("volume"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 100 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid volume; expected 0-100"})
+			return
+		}
+		volume = &n
+	}
+	context := &audio.StreamContext{Description: "Alarm: " + string(pattern), Priority: priority, Volume: volume, Type: "alarm"}
+	if err := c.a.PlayAlarm(pattern, context); err != nil {
+		clog.WithError(err).Error("Could not play alarm signal")
+		if err == audio.ErrDeviceBusy {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	clog.Info("Alarm signal started")
+	ctx.JSON(http.StatusAccepted, gin.H{"pattern": pattern, "status": "playing"})
+}
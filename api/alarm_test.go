@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/mklimuk/test-alsa/audio"
+	"github.com/mklimuk/test-alsa/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type AlarmAPITestSuite struct {
+	suite.Suite
+	router *gin.Engine
+	serv   *httptest.Server
+	a      *alarmAPI
+}
+
+func (suite *AlarmAPITestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+	suite.a = NewAlarmAPI(&audio.PlaybackMock{}, &config.AudioConf{}).(*alarmAPI)
+	suite.router = gin.New()
+	suite.a.AddRoutes(suite.router)
+	suite.serv = httptest.NewServer(suite.router)
+}
+
+func (suite *AlarmAPITestSuite) TearDownSuite() {
+	suite.serv.Close()
+}
+
+//formRequest posts the URL-encoded 'form' to 'url'
+func formRequest(url string, form string) *http.Request {
+	r, _ := http.NewRequest("POST", url, bytes.NewBufferString(form))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func (suite *AlarmAPITestSuite) TestPlay() {
+	p := &audio.PlaybackMock{}
+	p.On("PlayAlarm", audio.AlarmSlowWhoop, &audio.StreamContext{Description: "Alarm: slow-whoop", Priority: defaultAlarmPriority, Type: "alarm"}).Return(nil).Once()
+	p.On("PlayAlarm", audio.AlarmPulsed, &audio.StreamContext{Description: "Alarm: pulsed", Priority: 7, Volume: volume(80), Type: "alarm"}).Return(audio.ErrDeviceBusy).Once()
+	p.On("PlayAlarm", audio.AlarmSlowWhoop, &audio.StreamContext{Description: "Alarm: slow-whoop", Priority: defaultAlarmPriority, Volume: volume(0), Type: "alarm"}).Return(nil).Once()
+	p.On("PlayAlarm", audio.AlarmContinuous, mock.Anything).Return(audio.DeviceError{Err: fmt.Errorf("mock error")}).Once()
+	suite.a.a = p
+	url := fmt.Sprintf("%s/audio/alarm", suite.serv.URL)
+	a := assert.New(suite.T())
+	for _, req := range []struct {
+		form   string
+		status int
+	}{
+		{"pattern=slow-whoop", http.StatusAccepted},
+		{"pattern=pulsed&priority=7&volume=80", http.StatusConflict},
+		{"pattern=slow-whoop&volume=0", http.StatusAccepted},
+		{"pattern=continuous", http.StatusInternalServerError},
+		{"pattern=siren", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+		{"pattern=pulsed&priority=high", http.StatusBadRequest},
+		{"pattern=pulsed&volume=150", http.StatusBadRequest},
+	} {
+		res, err := http.DefaultClient.Do(formRequest(url, req.form))
+		a.NoError(err)
+		a.Equal(req.status, res.StatusCode, "form %s", req.form)
+	}
+	p.AssertExpectations(suite.T())
+}
+
+//volume returns a pointer to 'v' for audio.StreamContext.Volume
+func volume(v int) *int {
+	return &v
+}
+
+func TestAlarmAPITestSuite(t *testing.T) {
+	suite.Run(t, new(AlarmAPITestSuite))
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -48,6 +49,51 @@ func (suite *PlaybackAPITestSuite) TestRequestError() {
 	a.Equal(400, res.StatusCode)
 }
 
+func (suite *PlaybackAPITestSuite) TestStatus() {
+	p := &audio.PlaybackMock{}
+	p.On("Status").Return(&audio.Status{}).Once()
+	p.On("Status").Return(&audio.Status{Busy: true, Stream: &audio.StreamContext{Description: "Train delayed", Priority: 2, Type: "clip", SampleRate: 22050, Channels: 1}, BytesRead: 1024, FramesWrote: 512}).Once()
+	suite.a.a = p
+	a := assert.New(suite.T())
+	res, err := http.Get(fmt.Sprintf("%s/%s", suite.serv.URL, "audio/status"))
+	a.NoError(err)
+	a.Equal(200, res.StatusCode)
+	var s map[string]interface{}
+	a.NoError(json.NewDecoder(res.Body).Decode(&s))
+	a.Equal(false, s["busy"])
+	a.Nil(s["stream"])
+
+	res, err = http.Get(fmt.Sprintf("%s/%s", suite.serv.URL, "audio/status"))
+	a.NoError(err)
+	a.Equal(200, res.StatusCode)
+	var busy audio.Status
+	a.NoError(json.NewDecoder(res.Body).Decode(&busy))
+	a.True(busy.Busy)
+	a.Equal("Train delayed", busy.Stream.Description)
+	a.Equal(22050, busy.Stream.SampleRate)
+	a.Equal(int64(1024), busy.BytesRead)
+	a.Equal(512, busy.FramesWrote)
+	p.AssertExpectations(suite.T())
+}
+
+func (suite *PlaybackAPITestSuite) TestStop() {
+	p := &audio.PlaybackMock{}
+	//a caller without a priority only stops streams of the lowest priority
+	p.On("Stop", defaultStopPriority, mock.AnythingOfType("string")).Return(nil, audio.ErrDeviceBusy).Once()
+	p.On("Stop", 7, mock.AnythingOfType("string")).Return(&audio.StreamContext{Description: "Train delayed"}, nil).Once()
+	p.On("Stop", 2, mock.AnythingOfType("string")).Return(nil, audio.ErrDeviceBusy).Once()
+	p.On("Stop", 5, mock.AnythingOfType("string")).Return(nil, audio.ErrNotPlaying).Once()
+	suite.a.a = p
+	a := assert.New(suite.T())
+	for query, status := range map[string]int{"": 409, "?priority=7": 200, "?priority=2": 409, "?priority=5": 404, "?priority=high": 400} {
+		r, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/audio/play%s", suite.serv.URL, query), nil)
+		res, err := http.DefaultClient.Do(r)
+		a.NoError(err)
+		a.Equal(status, res.StatusCode, "query %s", query)
+	}
+	p.AssertExpectations(suite.T())
+}
+
 func (suite *PlaybackAPITestSuite) TestVersion() {
 
 }
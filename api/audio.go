@@ -1,6 +1,9 @@
 package api
 
 import (
+	"net/http"
+	"strconv"
+
 	"github.com/gin-gonic/gin"
 	"github.com/mklimuk/husar/rest"
 	"github.com/mklimuk/test-alsa/audio"
@@ -9,6 +12,10 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
+//defaultStopPriority is the priority of stop requests that do not give one: only streams of the lowest
+//priority can be stopped without it
+const defaultStopPriority = 0
+
 type playAPI struct {
 	a       audio.Playback
 	factory websocket.ConnectionFactory
@@ -22,6 +29,8 @@ func NewPlaybackAPI(a audio.Playback, factory websocket.ConnectionFactory) rest.
 
 func (p *playAPI) AddRoutes(router *gin.Engine) {
 	router.GET("/audio/play", p.play)
+	router.GET("/audio/status", p.status)
+	router.DELETE("/audio/play", p.stop)
 }
 
 // play establishes a websocket connection and writes binary data to the playback device
@@ -37,3 +46,37 @@ func (p *playAPI) play(ctx *gin.Context) {
 	}
 	p.a.PlayFromWsConnection(c)
 }
+
+// status reports whether the device is playing along with the current stream details
+func (p *playAPI) status(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	ctx.JSON(http.StatusOK, p.a.Status())
+}
+
+// stop ends the current stream; the 'priority' query parameter is the priority of the caller (0 when omitted)
+// and streams with a higher priority are not stopped
+func (p *playAPI) stop(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	priority := defaultStopPriority
+	if q := ctx.Query("priority"); q != "" {
+		var err error
+		if priority, err = strconv.Atoi(q); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid priority"})
+			return
+		}
+	}
+	requester := ctx.ClientIP()
+	stopped, err := p.a.Stop(priority, requester)
+	switch err {
+	case nil:
+		log.WithFields(log.Fields{"logger": "audio-endpoint.api", "method": "stop", "requester": requester, "description": stopped.Description}).
+			Info("Stream stopped")
+		ctx.JSON(http.StatusOK, gin.H{"status": "stopped", "stream": stopped})
+	case audio.ErrNotPlaying:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case audio.ErrDeviceBusy:
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Stream has a higher priority"})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/mklimuk/husar/errors"
+	"github.com/mklimuk/husar/rest"
+	"github.com/mklimuk/test-alsa/audio"
+	"github.com/mklimuk/test-alsa/clip"
+	"github.com/mklimuk/test-alsa/config"
+)
+
+type clipAPI struct {
+	s        clip.Store
+	a        audio.Playback
+	priority int
+}
+
+//NewClipAPI is the clip store API constructor
+func NewClipAPI(s clip.Store, a audio.Playback, conf *config.ClipConf) rest.API {
+	c := clipAPI{s, a, conf.Priority}
+	return rest.API(&c)
+}
+
+func (c *clipAPI) AddRoutes(router *gin.Engine) {
+	router.POST("/audio", c.dispatch)
+	router.PUT("/audio", c.play)
+	router.GET("/audio/clips", c.list)
+	router.GET("/audio/clips/:id", c.get)
+	router.DELETE("/audio/clips/:id", c.remove)
+}
+
+// dispatch tells uploads (multipart form) from play requests (URL-encoded form) as both share the same route
+func (c *clipAPI) dispatch(ctx *gin.Context) {
+	if strings.HasPrefix(ctx.ContentType(), "multipart/form-data") {
+		c.upload(ctx)
+		return
+	}
+	c.play(ctx)
+}
+
+// upload stores the audio file sent by husar audio controller as a multipart form;
+// files that cannot be decoded are refused with 415
+func (c *clipAPI) upload(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	id := ctx.PostForm("id")
+	description := ctx.PostForm("description")
+	clog := log.WithFields(log.Fields{"logger": "audio-endpoint.api", "method": "upload", "id": id})
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Missing clip id"})
+		return
+	}
+	var f multipart.File
+	var err error
+	if f, _, err = ctx.Request.FormFile("file"); err != nil {
+		clog.WithError(err).Warn("Upload request without a file part")
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Missing file part"})
+		return
+	}
+	defer f.Close()
+	var cl *clip.Clip
+	if cl, err = c.s.Save(id, description, f); err != nil {
+		clog.WithError(err).Error("Could not store clip")
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, cl)
+}
+
+// play starts playback of a stored clip identified by the 'id' form field
+func (c *clipAPI) play(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	id := ctx.PostForm("id")
+	clog := log.WithFields(log.Fields{"logger": "audio-endpoint.api", "method": "play", "id": id})
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Missing clip id"})
+		return
+	}
+	priority := c.priority
+	if p := ctx.PostForm("priority"); p != "" {
+		var err error
+		if priority, err = strconv.Atoi(p); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid priority"})
+			return
+		}
+	}
+	//volume 0 mutes the stream; without it the stream plays at unity gain
+	var volume *int
+	if v := ctx.PostForm("volume"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 100 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid volume; expected 0-100"})
+			return
+		}
+		volume = &n
+	}
+	f, cl, err := c.s.Open(id)
+	if err != nil {
+		clog.WithError(err).Warn("Could not open clip")
+		ctx.JSON(playErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	context := &audio.StreamContext{Description: cl.Description, Priority: priority, Volume: volume, Type: "clip"}
+	if err = c.a.PlayClip(f, context); err != nil {
+		clog.WithError(err).Warn("Could not play clip")
+		ctx.JSON(playErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	clog.Info("Clip playback started")
+	ctx.JSON(http.StatusAccepted, gin.H{"id": id, "status": "playing"})
+}
+
+func (c *clipAPI) list(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	clips, err := c.s.List()
+	if err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.api", "method": "list"}).
+			WithError(err).Error("Could not list clips")
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, clips)
+}
+
+func (c *clipAPI) get(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	cl, err := c.s.Get(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, cl)
+}
+
+func (c *clipAPI) remove(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	if err := c.s.Delete(ctx.Param("id")); err != nil {
+		ctx.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+//playErrorStatus lets the caller tell a busy device from a clip that cannot be played; anything else is a server error
+func playErrorStatus(err error) int {
+	if err == audio.ErrDeviceBusy {
+		return http.StatusConflict
+	}
+	if _, ok := err.(audio.DecodeError); ok {
+		return http.StatusUnsupportedMediaType
+	}
+	if errors.GetType(err) == errors.NotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+//errorStatus maps husar error types to HTTP status codes; plain errors (e.g. I/O failures of the store) are
+//server errors even though errors.GetType reports them as Unrecognized
+func errorStatus(err error) int {
+	switch {
+	case errors.IsType(err, errors.NotFound):
+		return http.StatusNotFound
+	case errors.IsType(err, errors.BadRequest):
+		return http.StatusBadRequest
+	case errors.IsType(err, errors.Unrecognized):
+		//the store refuses clips it cannot decode with an explicit Unrecognized error
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusInternalServerError
+}
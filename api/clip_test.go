@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/mklimuk/husar/errors"
+	"github.com/mklimuk/test-alsa/audio"
+	"github.com/mklimuk/test-alsa/clip"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type ClipAPITestSuite struct {
+	suite.Suite
+	router *gin.Engine
+	serv   *httptest.Server
+	a      clipAPI
+}
+
+func (suite *ClipAPITestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+	suite.a = clipAPI{s: &clip.StoreMock{}, a: &audio.PlaybackMock{}, priority: 1}
+	suite.router = gin.New()
+	suite.a.AddRoutes(suite.router)
+	suite.serv = httptest.NewServer(suite.router)
+}
+
+func (suite *ClipAPITestSuite) TearDownSuite() {
+	suite.serv.Close()
+}
+
+func uploadRequest(url string, id string, file []byte) *http.Request {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	w.WriteField("description", "Train delayed")
+	if id != "" {
+		w.WriteField("id", id)
+	}
+	if file != nil {
+		part, _ := w.CreateFormFile("file", fmt.Sprintf("%s.ogg", id))
+		part.Write(file)
+	}
+	w.Close()
+	r, _ := http.NewRequest("POST", url, body)
+	r.Header.Add("Content-Type", w.FormDataContentType())
+	return r
+}
+
+func (suite *ClipAPITestSuite) TestUpload() {
+	s := &clip.StoreMock{}
+	s.On("Save", "abc", "Train delayed", mock.Anything).Return(&clip.Clip{ID: "abc"}, nil).Once()
+	suite.a.s = s
+	res, err := http.DefaultClient.Do(uploadRequest(fmt.Sprintf("%s/audio", suite.serv.URL), "abc", []byte("OggS")))
+	a := assert.New(suite.T())
+	a.NoError(err)
+	a.Equal(http.StatusCreated, res.StatusCode)
+	s.AssertExpectations(suite.T())
+}
+
+func (suite *ClipAPITestSuite) TestUploadRejected() {
+	s := &clip.StoreMock{}
+	s.On("Save", "abc", "Train delayed", mock.Anything).Return(nil, errors.NewError("bad file", errors.BadRequest)).Once()
+	s.On("Save", "abc", "Train delayed", mock.Anything).Return(nil, errors.NewError("no audio", errors.Unrecognized)).Once()
+	s.On("Save", "abc", "Train delayed", mock.Anything).Return(nil, fmt.Errorf("disk full")).Once()
+	suite.a.s = s
+	a := assert.New(suite.T())
+	res, err := http.DefaultClient.Do(uploadRequest(fmt.Sprintf("%s/audio", suite.serv.URL), "abc", []byte("ID3")))
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, res.StatusCode)
+	res, err = http.DefaultClient.Do(uploadRequest(fmt.Sprintf("%s/audio", suite.serv.URL), "abc", []byte("OggS")))
+	a.NoError(err)
+	a.Equal(http.StatusUnsupportedMediaType, res.StatusCode)
+	//failures of the store itself are not the fault of the file
+	res, err = http.DefaultClient.Do(uploadRequest(fmt.Sprintf("%s/audio", suite.serv.URL), "abc", []byte("OggS")))
+	a.NoError(err)
+	a.Equal(http.StatusInternalServerError, res.StatusCode)
+	res, err = http.DefaultClient.Do(uploadRequest(fmt.Sprintf("%s/audio", suite.serv.URL), "", []byte("OggS")))
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, res.StatusCode)
+	res, err = http.DefaultClient.Do(uploadRequest(fmt.Sprintf("%s/audio", suite.serv.URL), "abc", nil))
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, res.StatusCode)
+	s.AssertExpectations(suite.T())
+}
+
+func (suite *ClipAPITestSuite) TestGetListDelete() {
+	s := &clip.StoreMock{}
+	s.On("List").Return([]*clip.Clip{{ID: "abc"}}, nil).Once()
+	s.On("List").Return(nil, fmt.Errorf("permission denied")).Once()
+	s.On("Get", "abc").Return(&clip.Clip{ID: "abc"}, nil).Once()
+	s.On("Get", "xyz").Return(nil, errors.NewError("not found", errors.NotFound)).Once()
+	s.On("Delete", "abc").Return(nil).Once()
+	suite.a.s = s
+	a := assert.New(suite.T())
+	res, err := http.Get(fmt.Sprintf("%s/audio/clips", suite.serv.URL))
+	a.NoError(err)
+	a.Equal(http.StatusOK, res.StatusCode)
+	res, err = http.Get(fmt.Sprintf("%s/audio/clips", suite.serv.URL))
+	a.NoError(err)
+	a.Equal(http.StatusInternalServerError, res.StatusCode)
+	res, err = http.Get(fmt.Sprintf("%s/audio/clips/abc", suite.serv.URL))
+	a.NoError(err)
+	a.Equal(http.StatusOK, res.StatusCode)
+	res, err = http.Get(fmt.Sprintf("%s/audio/clips/xyz", suite.serv.URL))
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, res.StatusCode)
+	r, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/audio/clips/abc", suite.serv.URL), nil)
+	res, err = http.DefaultClient.Do(r)
+	a.NoError(err)
+	a.Equal(http.StatusNoContent, res.StatusCode)
+	s.AssertExpectations(suite.T())
+}
+
+func playRequest(method string, url string, id string) *http.Request {
+	data := neturl.Values{}
+	data.Set("id", id)
+	r, _ := http.NewRequest(method, url, bytes.NewBufferString(data.Encode()))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func (suite *ClipAPITestSuite) TestPlay() {
+	f, _ := ioutil.TempFile("", "clip")
+	defer os.Remove(f.Name())
+	s := &clip.StoreMock{}
+	s.On("Open", "abc").Return(f, &clip.Clip{ID: "abc", Description: "Train delayed"}, nil)
+	s.On("Open", "xyz").Return(nil, nil, errors.NewError("not found", errors.NotFound))
+	s.On("Open", "err").Return(nil, nil, fmt.Errorf("permission denied"))
+	p := &audio.PlaybackMock{}
+	p.On("PlayClip", f, &audio.StreamContext{Description: "Train delayed", Priority: 1, Type: "clip"}).Return(nil).Once()
+	p.On("PlayClip", f, &audio.StreamContext{Description: "Train delayed", Priority: 1, Volume: volume(0), Type: "clip"}).Return(nil).Once()
+	p.On("PlayClip", f, mock.Anything).Return(audio.ErrDeviceBusy).Once()
+	p.On("PlayClip", f, mock.Anything).Return(audio.DeviceError{Err: fmt.Errorf("mock error")}).Once()
+	p.On("PlayClip", f, mock.Anything).Return(audio.DecodeError{Err: audio.ErrUnknownContainer}).Once()
+	p.On("PlayClip", f, mock.Anything).Return(errors.NewError("clip removed", errors.NotFound)).Once()
+	p.On("PlayClip", f, mock.Anything).Return(fmt.Errorf("mock error")).Once()
+	suite.a.s = s
+	suite.a.a = p
+	url := fmt.Sprintf("%s/audio", suite.serv.URL)
+	a := assert.New(suite.T())
+	res, err := http.DefaultClient.Do(playRequest("PUT", url, "abc"))
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, res.StatusCode)
+	res, err = http.DefaultClient.Do(playRequest("POST", url, "abc"))
+	a.NoError(err)
+	a.Equal(http.StatusConflict, res.StatusCode)
+	res, err = http.DefaultClient.Do(playRequest("POST", url, "abc"))
+	a.NoError(err)
+	a.Equal(http.StatusInternalServerError, res.StatusCode)
+	res, err = http.DefaultClient.Do(playRequest("POST", url, "abc"))
+	a.NoError(err)
+	a.Equal(http.StatusUnsupportedMediaType, res.StatusCode)
+	res, err = http.DefaultClient.Do(playRequest("POST", url, "abc"))
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, res.StatusCode)
+	//errors that are neither the caller's nor the clip's are server errors
+	res, err = http.DefaultClient.Do(playRequest("POST", url, "abc"))
+	a.NoError(err)
+	a.Equal(http.StatusInternalServerError, res.StatusCode)
+	res, err = http.DefaultClient.Do(playRequest("PUT", url, "xyz"))
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, res.StatusCode)
+	res, err = http.DefaultClient.Do(playRequest("PUT", url, "err"))
+	a.NoError(err)
+	a.Equal(http.StatusInternalServerError, res.StatusCode)
+	res, err = http.DefaultClient.Do(playRequest("PUT", url, ""))
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, res.StatusCode)
+	req, _ := http.NewRequest("PUT", url, bytes.NewBufferString("id=abc&volume=150"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, res.StatusCode)
+	//volume 0 mutes the clip
+	req, _ = http.NewRequest("PUT", url, bytes.NewBufferString("id=abc&volume=0"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusAccepted, res.StatusCode)
+	p.AssertExpectations(suite.T())
+}
+
+func TestClipAPITestSuite(t *testing.T) {
+	suite.Run(t, new(ClipAPITestSuite))
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/mklimuk/husar/rest"
+	"github.com/mklimuk/test-alsa/audio"
+	"github.com/mklimuk/test-alsa/config"
+)
+
+type signalAPI struct {
+	a        audio.Playback
+	priority int
+}
+
+//NewSignalAPI is the test signal API constructor
+func NewSignalAPI(a audio.Playback, conf *config.AudioConf) rest.API {
+	c := signalAPI{a, conf.TestPriority}
+	return rest.API(&c)
+}
+
+func (c *signalAPI) AddRoutes(router *gin.Engine) {
+	router.POST("/audio/signal", c.play)
+}
+
+// play starts the test signal described by the form fields 'type', 'frequency', 'to', 'level' and 'duration'
+// (see audio.TestSignal); omitted fields take the defaults while an explicit 0 is refused as it would select
+// them too (e.g. duration=0 would play for 5 s). The signal can be stopped early with DELETE /audio/play
+func (c *signalAPI) play(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	signal := audio.TestSignal{Type: audio.SignalType(ctx.PostForm("type"))}
+	clog := log.WithFields(log.Fields{"logger": "audio-endpoint.api", "method": "signal", "type": signal.Type})
+	var err error
+	for field, value := range map[string]*float64{"frequency": &signal.Frequency, "to": &signal.To, "level": &signal.Level} {
+		if v := ctx.PostForm(field); v != "" {
+			if *value, err = strconv.ParseFloat(v, 64); err != nil || *value == 0 {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + field})
+				return
+			}
+		}
+	}
+	if d := ctx.PostForm("duration"); d != "" {
+		if signal.Duration, err = strconv.Atoi(d); err != nil || signal.Duration == 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration"})
+			return
+		}
+	}
+	priority := c.priority
+	if p := ctx.PostForm("priority"); p != "" {
+		if priority, err = strconv.Atoi(p); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid priority"})
+			return
+		}
+	}
+	if err = signal.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	context := &audio.StreamContext{Description: "Test signal: " + signal.String(), Priority: priority, Type: "signal"}
+	if err = c.a.PlaySignal(signal, context); err != nil {
+		clog.WithError(err).Warn("Could not play test signal")
+		status := http.StatusBadRequest
+		if err == audio.ErrDeviceBusy {
+			status = http.StatusConflict
+		} else if _, ok := err.(audio.DeviceError); ok {
+			status = http.StatusInternalServerError
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	clog.Info("Test signal started")
+	ctx.JSON(http.StatusAccepted, gin.H{"signal": signal.String(), "status": "playing"})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/mklimuk/test-alsa/audio"
+	"github.com/mklimuk/test-alsa/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type SignalAPITestSuite struct {
+	suite.Suite
+	router *gin.Engine
+	serv   *httptest.Server
+	a      *signalAPI
+}
+
+func (suite *SignalAPITestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+	suite.a = NewSignalAPI(&audio.PlaybackMock{}, &config.AudioConf{TestPriority: 1}).(*signalAPI)
+	suite.router = gin.New()
+	suite.a.AddRoutes(suite.router)
+	suite.serv = httptest.NewServer(suite.router)
+}
+
+func (suite *SignalAPITestSuite) TearDownSuite() {
+	suite.serv.Close()
+}
+
+func (suite *SignalAPITestSuite) TestPlay() {
+	p := &audio.PlaybackMock{}
+	sweep := audio.TestSignal{Type: audio.SignalSweep, Frequency: 100, To: 5000, Level: -12, Duration: 10000}
+	p.On("PlaySignal", sweep, &audio.StreamContext{Description: "Test signal: sweep 100-5000 Hz at -12.0 dBFS", Priority: 1, Type: "signal"}).Return(nil).Once()
+	p.On("PlaySignal", audio.TestSignal{Type: audio.SignalPink}, mock.MatchedBy(func(c *audio.StreamContext) bool { return c.Priority == 3 })).Return(audio.ErrDeviceBusy).Once()
+	p.On("PlaySignal", audio.TestSignal{Type: audio.SignalSine, Frequency: 15000}, mock.Anything).Return(fmt.Errorf("frequency out of range")).Once()
+	p.On("PlaySignal", audio.TestSignal{Type: audio.SignalChannels}, mock.Anything).Return(audio.DeviceError{Err: fmt.Errorf("mock error")}).Once()
+	suite.a.a = p
+	url := fmt.Sprintf("%s/audio/signal", suite.serv.URL)
+	a := assert.New(suite.T())
+	for _, req := range []struct {
+		form   string
+		status int
+	}{
+		{"type=sweep&frequency=100&to=5000&level=-12&duration=10000", http.StatusAccepted},
+		{"type=pink&priority=3", http.StatusConflict},
+		{"type=sine&frequency=15000", http.StatusBadRequest},
+		{"type=channels", http.StatusInternalServerError},
+		{"type=square", http.StatusBadRequest},
+		{"type=white&level=0.5", http.StatusBadRequest},
+		{"type=white&duration=600000", http.StatusBadRequest},
+		{"type=white&duration=0", http.StatusBadRequest},
+		{"type=white&level=0", http.StatusBadRequest},
+		{"type=sine&frequency=high", http.StatusBadRequest},
+		{"type=sine&priority=high", http.StatusBadRequest},
+	} {
+		res, err := http.DefaultClient.Do(formRequest(url, req.form))
+		a.NoError(err)
+		a.Equal(req.status, res.StatusCode, "form %s", req.form)
+	}
+	p.AssertExpectations(suite.T())
+}
+
+func TestSignalAPITestSuite(t *testing.T) {
+	suite.Run(t, new(SignalAPITestSuite))
+}
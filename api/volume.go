@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/mklimuk/husar/rest"
+	"github.com/mklimuk/test-alsa/audio"
+)
+
+type volumeAPI struct {
+	v audio.VolumeControl
+}
+
+//NewVolumeAPI is the volume control API constructor
+func NewVolumeAPI(v audio.VolumeControl) rest.API {
+	c := volumeAPI{v}
+	return rest.API(&c)
+}
+
+func (c *volumeAPI) AddRoutes(router *gin.Engine) {
+	//the path is the one used by husar audio controller (SetVolume)
+	router.PUT("/amp/control/volume/:vol", c.setVolume)
+	router.GET("/amp/control/volume", c.volume)
+}
+
+// setVolume sets the output level (0-100) of the device
+func (c *volumeAPI) setVolume(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	clog := log.WithFields(log.Fields{"logger": "audio-endpoint.api", "method": "setVolume", "volume": ctx.Param("vol")})
+	vol, err := strconv.Atoi(ctx.Param("vol"))
+	if err != nil || vol < 0 || vol > 100 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid volume; expected 0-100"})
+		return
+	}
+	if err = c.v.SetVolume(vol); err != nil {
+		clog.WithError(err).Error("Could not set volume")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	clog.Info("Volume changed")
+	ctx.JSON(http.StatusOK, gin.H{"volume": vol})
+}
+
+// volume returns the current output level of the device
+func (c *volumeAPI) volume(ctx *gin.Context) {
+	defer rest.ErrorHandler(ctx)
+	vol, err := c.v.Volume()
+	if err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.api", "method": "volume"}).
+			WithError(err).Error("Could not read volume")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"volume": vol})
+}
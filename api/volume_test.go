@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/mklimuk/test-alsa/audio"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type VolumeAPITestSuite struct {
+	suite.Suite
+	router *gin.Engine
+	serv   *httptest.Server
+	a      volumeAPI
+}
+
+func (suite *VolumeAPITestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+	suite.a = volumeAPI{&audio.MixerMock{}}
+	suite.router = gin.New()
+	suite.a.AddRoutes(suite.router)
+	suite.serv = httptest.NewServer(suite.router)
+}
+
+func (suite *VolumeAPITestSuite) TearDownSuite() {
+	suite.serv.Close()
+}
+
+func (suite *VolumeAPITestSuite) TestSetVolume() {
+	m := &audio.MixerMock{}
+	m.On("SetVolume", 40).Return(nil).Once()
+	m.On("SetVolume", 50).Return(fmt.Errorf("mock error")).Once()
+	suite.a.v = m
+	a := assert.New(suite.T())
+	for vol, status := range map[string]int{"40": http.StatusOK, "50": http.StatusInternalServerError, "101": http.StatusBadRequest, "-1": http.StatusBadRequest, "loud": http.StatusBadRequest} {
+		r, _ := http.NewRequest("PUT", fmt.Sprintf("%s/amp/control/volume/%s", suite.serv.URL, vol), nil)
+		res, err := http.DefaultClient.Do(r)
+		a.NoError(err)
+		a.Equal(status, res.StatusCode, "volume %s", vol)
+	}
+	m.AssertExpectations(suite.T())
+}
+
+func (suite *VolumeAPITestSuite) TestGetVolume() {
+	suite.a.v = audio.NewMaster(65)
+	res, err := http.Get(fmt.Sprintf("%s/amp/control/volume", suite.serv.URL))
+	a := assert.New(suite.T())
+	a.NoError(err)
+	a.Equal(http.StatusOK, res.StatusCode)
+	var body map[string]int
+	a.NoError(json.NewDecoder(res.Body).Decode(&body))
+	a.Equal(65, body["volume"])
+}
+
+func TestVolumeAPITestSuite(t *testing.T) {
+	suite.Run(t, new(VolumeAPITestSuite))
+}
@@ -0,0 +1,153 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//AlarmPattern names one of the alert signals generated by PlayAlarm
+type AlarmPattern string
+
+//Supported alarm patterns
+const (
+	AlarmSlowWhoop  AlarmPattern = "slow-whoop" //500 to 1200 Hz sweep over 3.5 s followed by 0.5 s of silence
+	AlarmContinuous AlarmPattern = "continuous" //steady 970 Hz tone
+	AlarmPulsed     AlarmPattern = "pulsed"     //970 Hz tone, 0.5 s on and 0.5 s off
+)
+
+const (
+	//alarmLevel is the peak level of alarm signals in dBFS
+	alarmLevel = -3.0
+	//alarmEdge is the ramp at the start and end of every tone of an interrupted pattern in ms; it keeps them from clicking
+	alarmEdge = 5
+)
+
+//alarmCycle is a single period of an alarm pattern: a tone sweeping linearly from 'from' to 'to' (in Hz)
+//for 'on' ms followed by 'off' ms of silence; the cycle repeats until the alarm is stopped
+type alarmCycle struct {
+	from float64
+	to   float64
+	on   int
+	off  int
+}
+
+var alarmCycles = map[AlarmPattern]alarmCycle{
+	AlarmSlowWhoop:  {from: 500, to: 1200, on: 3500, off: 500},
+	AlarmContinuous: {from: 970, to: 970, on: 1000},
+	AlarmPulsed:     {from: 970, to: 970, on: 500, off: 500},
+}
+
+//Valid tells if the pattern is supported
+func (a AlarmPattern) Valid() bool {
+	_, ok := alarmCycles[a]
+	return ok
+}
+
+/*PlayAlarm plays the alarm signal 'pattern' with the given stream context until it is stopped (see Stop) or
+preempted. The priority check is the same as for clips; alarms are meant to be given a priority above the ones
+of announcements. The signal is generated in the format of the device.
+*/
+func (p *play) PlayAlarm(pattern AlarmPattern, context *StreamContext) error {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+
+	cycle, ok := alarmCycles[pattern]
+	if !ok {
+		return fmt.Errorf("unknown alarm pattern %s", pattern)
+	}
+	if !p.admit(context) {
+		return ErrDeviceBusy
+	}
+	d, err := p.bus.acquire(0, 0)
+	if err != nil {
+		return err
+	}
+	//the signal is mono until the pipeline maps it to the device channels
+	context.SampleRate = d.rate
+	context.Channels = 1
+
+	p.preempt(context)
+	src := newSource(context, newAlarmInput(cycle, d.rate, newPipeline(d.rate, d.rate, 1, p.newGain(context), p.newMapper(1, d.channels))))
+	if err = p.bus.add(src, d); err != nil {
+		return err
+	}
+	s := &session{context: context, source: src}
+	context.started = time.Now()
+	p.start(s)
+	go p.doPlayAlarm(s)
+	return nil
+}
+
+func (p *play) doPlayAlarm(s *session) {
+	defer p.cleanup(nil, s)
+	log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayAlarm", "description": s.context.Description}).
+		Warn("Alarm signal started")
+	if err := s.source.wait(); err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayAlarm", "description": s.context.Description}).
+			WithError(err).Error("Could not play alarm signal")
+		return
+	}
+	log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayAlarm", "description": s.context.Description}).
+		Warn("Alarm signal stopped")
+}
+
+/*alarmInput generates an alarm pattern at the rate of the bus without end; it leaves the bus once aborted.
+The mono signal runs through 'pipe' which applies the stream gain and maps it to the device channels.
+*/
+type alarmInput struct {
+	cycle alarmCycle
+	rate  int
+	pipe  *pipeline
+	//amp is the peak sample value
+	amp float64
+	//on and off are the durations of the tone and the silence in frames
+	on  int
+	off int
+	//pos is the frame within the cycle and phase the one of the tone in radians
+	pos   int
+	phase float64
+}
+
+func newAlarmInput(cycle alarmCycle, rate int, pipe *pipeline) *alarmInput {
+	a := &alarmInput{cycle: cycle, rate: rate, pipe: pipe, amp: 32767 * DBToLinear(alarmLevel), on: msToFrames(cycle.on, rate)}
+	if cycle.off > 0 {
+		a.off = msToFrames(cycle.off, rate)
+	}
+	return a
+}
+
+func (a *alarmInput) read(max int) ([]int16, bool) {
+	out := make([]int16, max/a.pipe.outChannels())
+	for i := range out {
+		out[i] = int16(a.amp * a.next())
+	}
+	return a.pipe.process(out), true
+}
+
+//next returns the next frame of the pattern scaled to [-1, 1]
+func (a *alarmInput) next() float64 {
+	var v float64
+	if a.pos < a.on {
+		//the phase is accumulated so that the sweep stays continuous
+		f := a.cycle.from + (a.cycle.to-a.cycle.from)*float64(a.pos)/float64(a.on)
+		v = math.Sin(a.phase)
+		if a.phase += 2 * math.Pi * f / float64(a.rate); a.phase >= 2*math.Pi {
+			a.phase -= 2 * math.Pi
+		}
+		if edge := fadeFrames(alarmEdge, a.rate); a.off > 0 && edge > 0 {
+			if a.pos < edge {
+				v *= float64(a.pos) / float64(edge)
+			}
+			if a.on-a.pos <= edge {
+				v *= float64(a.on-a.pos-1) / float64(edge)
+			}
+		}
+	} else {
+		a.phase = 0
+	}
+	a.pos = (a.pos + 1) % (a.on + a.off)
+	return v
+}
@@ -0,0 +1,125 @@
+package audio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type AlarmTestSuite struct {
+	suite.Suite
+}
+
+func (suite *AlarmTestSuite) SetupSuite() {
+	log.SetLevel(log.ErrorLevel)
+}
+
+func (suite *AlarmTestSuite) TearDownSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+//generate reads 'frames' frames of a mono alarm pattern at 8 kHz
+func generate(pattern AlarmPattern, frames int) []int16 {
+	g := newAlarmInput(alarmCycles[pattern], 8000, newPipeline(8000, 8000, 1, nil, nil))
+	var out []int16
+	for len(out) < frames {
+		s, _ := g.read(100)
+		out = append(out, s...)
+	}
+	return out[:frames]
+}
+
+//crossings counts the sign changes of 'samples'
+func crossings(samples []int16) int {
+	n := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			n++
+		}
+	}
+	return n
+}
+
+func (suite *AlarmTestSuite) TestPatterns() {
+	a := assert.New(suite.T())
+	peak := 32767 * DBToLinear(alarmLevel)
+
+	//a continuous tone never pauses
+	out := generate(AlarmContinuous, 16000)
+	a.InDelta(2*970, crossings(out[:8000]), 2)
+	a.InDelta(2*970, crossings(out[8000:]), 2)
+
+	//a pulsed one is silent half of the time and ramps in and out
+	out = generate(AlarmPulsed, 16000)
+	a.Equal(int16(0), out[0])
+	a.Equal(int16(0), out[3999])
+	a.Equal(make([]int16, 4000), out[4000:8000])
+	a.InDelta(2*970*0.5, crossings(out[:4000]), 2)
+	a.Equal(out[:4000], out[8000:12000])
+
+	//the slow whoop sweeps up and starts over after a pause
+	out = generate(AlarmSlowWhoop, 36000)
+	low, high := crossings(out[:4000]), crossings(out[24000:28000])
+	a.True(low < high, "no sweep: %d crossings at the start and %d at the end", low, high)
+	//0.5 s at the mean frequency of its first 0.5 s
+	a.InDelta(500+(1200-500)*0.25/3.5, low, 4)
+	a.Equal(make([]int16, 4000), out[28000:32000])
+	a.Equal(out[:4000], out[32000:36000])
+	for _, s := range out {
+		if s > int16(peak)+1 || s < -int16(peak)-1 {
+			a.Fail("sample above the alarm level", "%d", s)
+			break
+		}
+	}
+}
+
+func (suite *AlarmTestSuite) TestPlayAlarm() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Drain").Return()
+	d.On("Abort").Return()
+	d.On("Close").Return()
+	d.On("Xruns").Return(0)
+	fm := &FactoryMock{}
+	fm.On("New", 8000, 2, mock.Anything).Return(d, nil).Once()
+	p := New(&config.AudioConf{DeviceRate: 8000, DeviceChannels: 2, FadeIn: -1, FadeOut: -1, DeviceIdle: -1}, fm, "").(*play)
+
+	a.Error(p.PlayAlarm(AlarmPattern("siren"), &StreamContext{Priority: 100}))
+	a.NoError(p.PlayAlarm(AlarmPulsed, &StreamContext{Description: "Evacuation", Priority: 100, Type: "alarm"}))
+	time.Sleep(20 * time.Millisecond)
+	st := p.Status()
+	a.True(st.Busy)
+	a.Equal("alarm", st.Stream.Type)
+	a.Equal(8000, st.Stream.SampleRate)
+	//announcements do not interrupt it
+	a.Equal(ErrDeviceBusy, p.PlayClip(ioutil.NopCloser(bytes.NewReader(nil)), &StreamContext{Priority: 5}))
+	_, err := p.Stop(5, "10.0.0.1")
+	a.Equal(ErrDeviceBusy, err)
+	//it plays until stopped
+	time.Sleep(20 * time.Millisecond)
+	a.True(p.Status().Busy)
+	stopped, err := p.Stop(AnyPriority, "10.0.0.1")
+	a.NoError(err)
+	a.Equal("Evacuation", stopped.Description)
+	time.Sleep(20 * time.Millisecond)
+	a.False(p.Status().Busy)
+	played := frames.get()
+	if a.NotEmpty(played) {
+		a.Equal(played[0][0], played[0][1])
+		a.Equal(played[0][2], played[0][3])
+		a.NotEqual(int16(0), played[0][2])
+	}
+	fm.AssertExpectations(suite.T())
+}
+
+func TestAlarmTestSuite(t *testing.T) {
+	suite.Run(t, new(AlarmTestSuite))
+}
@@ -0,0 +1,644 @@
+package audio
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/config"
+)
+
+const (
+	//limiterCeiling is the highest sample value the limiter lets through
+	limiterCeiling = 32767
+	//defaultLimiterRelease is the time in which the limiter gain recovers after a peak, in ms
+	defaultLimiterRelease = 50
+	//reopenInterval is the shortest time between two reopens of a failing device; a device failing again
+	//sooner is given up
+	reopenInterval = time.Second
+)
+
+//errDeviceGone is reported to sources added to a device that failed after it had been acquired
+var errDeviceGone = errors.New("audio device closed")
+
+//busInput provides the audio of a source already converted to the bus format; read must not block
+type busInput interface {
+	//read returns up to 'max' samples (whole frames); nil stands for silence; ok is false once the input has ended
+	read(max int) (samples []int16, ok bool)
+}
+
+/*source is a single input of the bus. Its gain is decided by the priority rules of the bus.
+The done channel is closed once the source leaves the bus: at the end of its input, on abort or on device error.
+The audible and played channels follow the audio through the device: they are closed once the first audio
+of the source is heard and once its last audio has been played out (or it has been dropped).
+*/
+type source struct {
+	context   *StreamContext
+	input     busInput
+	gain      *Gain
+	db        float64
+	frames    int64
+	ducked    int32
+	suspended int32
+	aborted   int32
+	err       error
+	done      chan bool
+	audible   chan bool
+	played    chan bool
+	//after is the source this one follows: it is not mixed before the end of 'after'
+	after *source
+	//the playout state below belongs to the bus goroutine
+	started bool
+	paused  bool
+	faded   bool
+	//fadeLeft is the number of frames left of a fade out
+	fadeLeft int
+	//tail is the number of samples mixed in the last period of the source (the mix pass 'ended')
+	tail  int
+	ended uint64
+}
+
+func newSource(context *StreamContext, input busInput) *source {
+	return &source{context: context, input: input, done: make(chan bool), audible: make(chan bool), played: make(chan bool)}
+}
+
+//release frees what the input holds (e.g. a decoding goroutine) once the source will not be read any more
+func (s *source) release() {
+	if c, ok := s.input.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+//follow makes the source start right after the end of 'prev' (e.g. a stream after its intro); it must be
+//called before the source is added to the bus
+func (s *source) follow(prev *source) {
+	s.after = prev
+}
+
+//abort makes the bus drop the source discarding the audio it has not mixed yet
+func (s *source) abort() {
+	atomic.StoreInt32(&s.aborted, 1)
+}
+
+//suspend makes the bus skip the source keeping its position until resume is called
+func (s *source) suspend() {
+	atomic.StoreInt32(&s.suspended, 1)
+}
+
+//resume continues mixing a suspended source where it stopped
+func (s *source) resume() {
+	atomic.StoreInt32(&s.suspended, 0)
+}
+
+func (s *source) isSuspended() bool {
+	return atomic.LoadInt32(&s.suspended) == 1
+}
+
+//wait blocks until the source has left the bus and returns the device error that ended it, if any
+func (s *source) wait() error {
+	<-s.done
+	return s.err
+}
+
+//attenuated tells if the priority rules attenuate the source
+func (s *source) attenuated() bool {
+	return atomic.LoadInt32(&s.ducked) == 1
+}
+
+//framesMixed returns the number of frames of the source mixed so far
+func (s *source) framesMixed() int {
+	return int(atomic.LoadInt64(&s.frames))
+}
+
+//busDevice is a device opened by the bus; sources are mixed into it until it has been idle for the idle timeout
+type busDevice struct {
+	dev      PlaybackDevice
+	rate     int
+	channels int
+	//pending counts sources about to be added (see bus.acquire)
+	pending int
+	//xruns counts the underruns of the devices replaced after errors
+	xruns int
+	//latency is the time audio written to the device takes to be heard (its buffer)
+	latency time.Duration
+	closed  chan bool
+}
+
+/*bus mixes all active sources into a single device. The device is opened with the first source
+(at the configured rate and channels or the ones of the source) and kept open, fed with silence, until no source
+has played for the idle timeout; an idle device is reopened for a source in another format unless the format is
+configured. A device that fails is reopened and the sources go on playing on the new one.
+Every period each source is read, scaled by the gain the priority rules give it and summed;
+the limiter keeps the sum within full scale. The device is fed with silence while sources are buffering.
+Sources below the highest priority playing are attenuated by the first ducking rule covering them
+(or set to lowerDB when none does) and restored once no higher priority plays.
+Sources fade in when they start and fade out when stopped or suspended; unless crossfades are enabled
+a new source waits for the ones fading out.
+*/
+type bus struct {
+	mutex     sync.Mutex
+	factory   DeviceFactory
+	bufParams *BufferParams
+	//rate and channels force the device format; 0 takes the one of the first source
+	rate     int
+	channels int
+	//ramp is the duration of gain changes in ms
+	ramp int
+	//release is the limiter release time in ms
+	release int
+	//idle is the time the device is kept open after the last source; 0 closes it at once
+	idle time.Duration
+	//lowerDB is the gain of sources below the highest priority playing that no ducking rule covers
+	lowerDB float64
+	ducking []config.DuckConf
+	//fadeIn and fadeOut are the durations of fades at the start and stop of sources in ms
+	fadeIn  int
+	fadeOut int
+	//crossfade lets a new source fade in while the ones it replaces fade out
+	crossfade bool
+	sources   []*source
+	//pass counts the periods mixed
+	pass uint64
+	//heard are the audible and played channels of sources to close once the last period mixed is heard
+	heard []chan bool
+	cur   *busDevice
+	//closing is the device being closed; a new one is opened once it is released
+	closing *busDevice
+}
+
+func newBus(factory DeviceFactory, bp *BufferParams, rate int, channels int, ramp int) *bus {
+	return &bus{factory: factory, bufParams: bp, rate: rate, channels: channels, ramp: ramp,
+		release: defaultLimiterRelease, lowerDB: math.Inf(-1)}
+}
+
+/*acquire opens the device unless it is open already and keeps it open until the source is added (or the
+acquisition cancelled). A source at 'rate' with 'channels' channels has to be converted to the format
+of the returned device; a source generated in the format of the device passes 0 for both.
+*/
+func (b *bus) acquire(rate int, channels int) (*busDevice, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.waitClosed()
+	if b.rate > 0 {
+		rate = b.rate
+	}
+	if b.channels > 0 {
+		channels = b.channels
+	}
+	if b.cur != nil && len(b.sources) == 0 && b.cur.pending == 0 &&
+		((rate > 0 && b.cur.rate != rate) || (channels > 0 && b.cur.channels != channels)) {
+		//the idle device is closed (see run) rather than converting the source
+		b.closing = b.cur
+		b.cur = nil
+		b.waitClosed()
+	}
+	if rate <= 0 {
+		rate = defaultSampleRate
+	}
+	if channels < 1 {
+		channels = 1
+	}
+	if b.cur == nil {
+		dev, err := b.factory.New(rate, channels, b.bufParams)
+		if err != nil {
+			return nil, DeviceError{err}
+		}
+		d := &busDevice{dev: dev, rate: rate, channels: channels, latency: b.latency(rate), closed: make(chan bool)}
+		devbuf := make(chan []int16)
+		deverr := dev.WriteAsync(devbuf)
+		b.cur = d
+		go b.run(d, devbuf, deverr)
+	}
+	b.cur.pending++
+	return b.cur, nil
+}
+
+//latency returns the duration of the device buffer at 'rate'
+func (b *bus) latency(rate int) time.Duration {
+	if b.bufParams == nil {
+		return 0
+	}
+	frames := b.bufParams.BufferFrames
+	if frames <= 0 {
+		frames = b.bufParams.PeriodFrames * b.bufParams.Periods
+	}
+	return time.Duration(frames) * time.Second / time.Duration(rate)
+}
+
+//waitClosed waits until the device being closed is released; it must be called with the mutex held
+func (b *bus) waitClosed() {
+	for b.cur == nil && b.closing != nil {
+		closed := b.closing.closed
+		b.mutex.Unlock()
+		<-closed
+		b.mutex.Lock()
+	}
+}
+
+//add starts mixing 's' into the device returned by acquire
+func (b *bus) add(s *source, d *busDevice) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.cur != d {
+		s.release()
+		return DeviceError{errDeviceGone}
+	}
+	d.pending--
+	s.db, _ = b.level(s, b.top())
+	s.gain = NewGain(s.db, d.channels, 0)
+	if fadeIn := fadeFrames(b.fadeIn, d.rate); fadeIn > 0 {
+		s.gain = NewGain(math.Inf(-1), d.channels, fadeIn)
+		s.gain.SetDB(s.db)
+	}
+	b.sources = append(b.sources, s)
+	return nil
+}
+
+//cancel releases a device acquired for a source that will not be added
+func (b *bus) cancel(d *busDevice) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.cur == d {
+		d.pending--
+	}
+}
+
+//xruns returns the device underruns recovered since the device was opened
+func (b *bus) xruns() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.cur == nil {
+		return 0
+	}
+	return b.cur.xruns + b.cur.dev.Xruns()
+}
+
+//top returns the highest priority among sources still playing; it must be called with the mutex held
+func (b *bus) top() int {
+	top := math.MinInt32
+	for _, s := range b.sources {
+		if atomic.LoadInt32(&s.aborted) == 0 && !s.isSuspended() && s.context.Priority > top {
+			top = s.context.Priority
+		}
+	}
+	return top
+}
+
+//level returns the gain the priority rules give to 's' while 'top' is the highest priority playing
+//and the duration of the ramp to it in ms
+func (b *bus) level(s *source, top int) (float64, int) {
+	if s.context.Priority >= top {
+		if r := b.duckRule(s.context, AnyPriority); r != nil {
+			return 0, r.Release
+		}
+		return 0, b.ramp
+	}
+	if r := b.duckRule(s.context, top); r != nil {
+		return r.Gain, r.Attack
+	}
+	return b.lowerDB, b.ramp
+}
+
+//duckRule returns the first ducking rule attenuating a stream with 'context' under a stream with priority 'top'
+func (b *bus) duckRule(context *StreamContext, top int) *config.DuckConf {
+	for i := range b.ducking {
+		r := &b.ducking[i]
+		if context.Priority < r.MinPriority || (r.MaxPriority != 0 && context.Priority > r.MaxPriority) {
+			continue
+		}
+		if top <= context.Priority || top < r.Trigger {
+			continue
+		}
+		if len(r.Types) == 0 {
+			return r
+		}
+		for _, t := range r.Types {
+			if t == context.Type {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+//ducks tells if a stream with 'context' is ducked rather than stopped or muted under a stream with priority 'top'
+func (b *bus) ducks(context *StreamContext, top int) bool {
+	return b.duckRule(context, top) != nil
+}
+
+func (b *bus) run(d *busDevice, devbuf chan []int16, deverr chan error) {
+	period := msToFrames(10, d.rate)
+	if b.bufParams != nil && b.bufParams.PeriodFrames > 0 {
+		period = b.bufParams.PeriodFrames
+	}
+	idle := time.Duration(period) * time.Second / time.Duration(d.rate)
+	if idle < time.Millisecond {
+		idle = time.Millisecond
+	}
+	lim := newLimiter(d.channels, d.rate, b.release)
+	mix := make([]float64, period*d.channels)
+	var started, aborted bool
+	var idleSince, reopened time.Time
+	for {
+		b.mutex.Lock()
+		if len(b.sources) == 0 && d.pending == 0 {
+			if idleSince.IsZero() {
+				idleSince = time.Now()
+			}
+			//a stream stopped without a fade is discarded from the device which cannot be kept open then
+			if b.cur != d || aborted || time.Since(idleSince) >= b.idle {
+				if b.cur == d {
+					b.cur = nil
+				}
+				b.closing = d
+				b.mutex.Unlock()
+				b.close(d, devbuf, aborted)
+				return
+			}
+		} else {
+			idleSince = time.Time{}
+		}
+		var n int
+		n, aborted = b.mix(mix, d)
+		last := len(b.sources) == 0 && d.pending == 0 && (b.idle == 0 || aborted)
+		b.mutex.Unlock()
+		if n == 0 {
+			if last {
+				continue
+			}
+			if !started {
+				//the device starts with the first audio instead of a run of silence
+				b.notify(0)
+				time.Sleep(idle)
+				continue
+			}
+			n = len(mix)
+		}
+		started = true
+		select {
+		case devbuf <- lim.process(mix[:n]):
+			b.notify(d.latency)
+		case err := <-deverr:
+			log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "run"}).
+				WithError(err).Error("Could not write buffer content to device")
+			if time.Since(reopened) >= reopenInterval {
+				var rerr error
+				if devbuf, deverr, rerr = b.reopen(d, devbuf); rerr == nil {
+					reopened = time.Now()
+					continue
+				}
+			}
+			b.fail(d, err)
+			b.close(d, devbuf, true)
+			return
+		}
+	}
+}
+
+/*reopen replaces the failed device of 'd' with a new one in the same format and returns its buffer and error channels.
+The audio queued in the failed device is lost. When the device cannot be opened 'd' is left with the failed one.
+*/
+func (b *bus) reopen(d *busDevice, devbuf chan []int16) (chan []int16, chan error, error) {
+	dev, err := b.factory.New(d.rate, d.channels, b.bufParams)
+	if err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "reopen"}).
+			WithError(err).Error("Could not reopen the device")
+		return devbuf, nil, err
+	}
+	failed := d.dev
+	failed.Abort()
+	close(devbuf)
+	failed.Close()
+	b.mutex.Lock()
+	d.xruns += failed.Xruns()
+	d.dev = dev
+	b.mutex.Unlock()
+	if log.GetLevel() >= log.InfoLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "reopen", "rate": d.rate, "channels": d.channels}).
+			Info("Device reopened after an error")
+	}
+	devbuf = make(chan []int16)
+	return devbuf, dev.WriteAsync(devbuf), nil
+}
+
+/*mix sums a period of every source into 'out' and returns the number of samples mixed (0 when all sources
+are silent). Stopped and suspended sources fade out first; resumed ones fade back in. A source following
+another one starts at the sample following its end. Sources that ended or were stopped are removed;
+'aborted' tells if the last one removed was stopped without a fade.
+It must be called with the mutex held.
+*/
+func (b *bus) mix(out []float64, d *busDevice) (n int, aborted bool) {
+	for i := range out {
+		out[i] = 0
+	}
+	b.pass++
+	top := b.top()
+	fadeOut := fadeFrames(b.fadeOut, d.rate)
+	kept := b.sources[:0]
+	for _, s := range b.sources {
+		stop := atomic.LoadInt32(&s.aborted) == 1
+		pause := !stop && s.isSuspended()
+		if (stop || pause) && s.fadeLeft == 0 {
+			if !s.faded && !s.paused && s.started && fadeOut > 0 {
+				//what is still playing fades out before the source stops
+				s.fadeLeft = fadeOut
+				s.gain.rampFrames = fadeOut
+				s.gain.SetDB(math.Inf(-1))
+			} else if stop {
+				b.leave(s)
+				//only audio cut short has to be dropped from the device; a source cancelled before it started
+				//or stopped while suspended left nothing of its own queued
+				aborted = s.started && !s.faded && !s.paused
+				s.faded = false
+				continue
+			} else {
+				s.paused, s.faded = true, false
+				kept = append(kept, s)
+				continue
+			}
+		}
+		if !stop && !pause && (s.paused || s.faded || s.fadeLeft > 0) {
+			//a resumed source fades back in
+			s.paused, s.faded, s.fadeLeft = false, false, 0
+			s.db, _ = b.level(s, top)
+			s.gain.rampFrames = fadeFrames(b.fadeIn, d.rate)
+			s.gain.SetDB(s.db)
+		}
+		if db, ramp := b.level(s, top); s.fadeLeft == 0 && db != s.db {
+			s.db = db
+			s.gain.rampFrames = rampFrames(ramp, d.rate)
+			s.gain.SetDB(db)
+			var ducked int32
+			if db < 0 {
+				ducked = 1
+			}
+			atomic.StoreInt32(&s.ducked, ducked)
+		}
+		if !s.started && !b.crossfade && b.fading() {
+			//without crossfades a new source waits for the ones it replaces to fade out
+			kept = append(kept, s)
+			continue
+		}
+		var offset int
+		if s.after != nil {
+			select {
+			case <-s.after.done:
+			default:
+				kept = append(kept, s)
+				continue
+			}
+			//the source goes on right where the one it follows ended
+			if s.after.ended == b.pass {
+				offset = s.after.tail
+			}
+			s.after = nil
+			if offset >= len(out) {
+				kept = append(kept, s)
+				continue
+			}
+		}
+		samples, ok := s.input.read(len(out) - offset)
+		if len(samples) > 0 {
+			if !s.started {
+				b.heard = append(b.heard, s.audible)
+			}
+			s.started = true
+			s.gain.Process(samples)
+			for i, v := range samples {
+				out[offset+i] += float64(v)
+			}
+			if offset+len(samples) > n {
+				n = offset + len(samples)
+			}
+			atomic.AddInt64(&s.frames, int64(len(samples)/d.channels))
+		}
+		if s.fadeLeft > 0 {
+			if s.fadeLeft -= len(out) / d.channels; s.fadeLeft <= 0 {
+				s.fadeLeft = 0
+				s.faded = true
+			}
+		}
+		if !ok {
+			s.tail, s.ended = offset+len(samples), b.pass
+			b.leave(s)
+			aborted = false
+			continue
+		}
+		kept = append(kept, s)
+	}
+	for i := len(kept); i < len(b.sources); i++ {
+		b.sources[i] = nil
+	}
+	b.sources = kept
+	return n, aborted
+}
+
+//leave ends source 's'; it must be called with the mutex held
+func (b *bus) leave(s *source) {
+	s.release()
+	close(s.done)
+	b.heard = append(b.heard, s.played)
+}
+
+//notify closes the channels of sources waiting for the audio mixed so far to be heard once 'latency' has passed
+func (b *bus) notify(latency time.Duration) {
+	b.mutex.Lock()
+	heard := b.heard
+	b.heard = nil
+	b.mutex.Unlock()
+	if len(heard) == 0 {
+		return
+	}
+	time.AfterFunc(latency, func() {
+		for _, c := range heard {
+			close(c)
+		}
+	})
+}
+
+//fading tells if a source is fading out or has just faded out; it must be called with the mutex held
+func (b *bus) fading() bool {
+	for _, s := range b.sources {
+		if s.fadeLeft > 0 || s.faded {
+			return true
+		}
+	}
+	return false
+}
+
+//fail ends all sources with the device error 'err'
+func (b *bus) fail(d *busDevice, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, s := range b.sources {
+		s.err = DeviceError{err}
+		b.leave(s)
+	}
+	b.sources = nil
+	b.cur = nil
+	b.closing = d
+}
+
+//close finishes the device, playing out what it holds unless 'abort' is set, and lets the next one open
+func (b *bus) close(d *busDevice, devbuf chan []int16, abort bool) {
+	if abort {
+		d.dev.Abort()
+	}
+	close(devbuf)
+	if !abort {
+		d.dev.Drain()
+	}
+	d.dev.Close()
+	b.notify(0)
+	b.mutex.Lock()
+	if b.closing == d {
+		b.closing = nil
+	}
+	b.mutex.Unlock()
+	close(d.closed)
+}
+
+/*limiter keeps the mixed signal within full scale. Peaks are caught instantly (the gain drops
+to what brings the loudest sample of the frame down to the ceiling) and the gain recovers exponentially.
+*/
+type limiter struct {
+	channels int
+	gain     float64
+	//coef is the share of the distance to unity gain recovered every frame
+	coef float64
+}
+
+func newLimiter(channels int, rate int, release int) *limiter {
+	if release <= 0 {
+		release = defaultLimiterRelease
+	}
+	return &limiter{channels: channels, gain: 1, coef: 1 - math.Exp(-1000/float64(release*rate))}
+}
+
+//process limits whole frames of 'in' and converts them to int16
+func (l *limiter) process(in []float64) []int16 {
+	out := make([]int16, len(in))
+	for f := 0; f+l.channels <= len(in); f += l.channels {
+		var peak float64
+		for _, v := range in[f : f+l.channels] {
+			peak = math.Max(peak, math.Abs(v))
+		}
+		g := 1.0
+		if peak > limiterCeiling {
+			g = limiterCeiling / peak
+		}
+		if g < l.gain {
+			l.gain = g
+		} else {
+			l.gain += (g - l.gain) * l.coef
+		}
+		for i, v := range in[f : f+l.channels] {
+			out[f+i] = int16(math.Max(-limiterCeiling-1, math.Min(limiterCeiling, math.Floor(v*l.gain+.5))))
+		}
+	}
+	return out
+}
@@ -0,0 +1,549 @@
+package audio
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type BusTestSuite struct {
+	suite.Suite
+}
+
+func (suite *BusTestSuite) SetupSuite() {
+	log.SetLevel(log.ErrorLevel)
+}
+
+func (suite *BusTestSuite) TearDownSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+//constInput plays 'frames' frames of a constant value; it is held back until released
+type constInput struct {
+	mutex  sync.Mutex
+	value  int16
+	frames int
+	held   bool
+}
+
+func (c *constInput) release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.held = false
+}
+
+func (c *constInput) read(max int) ([]int16, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.held {
+		return nil, true
+	}
+	if max > c.frames {
+		max = c.frames
+	}
+	c.frames -= max
+	out := make([]int16, max)
+	for i := range out {
+		out[i] = c.value
+	}
+	return out, c.frames > 0
+}
+
+func (suite *BusTestSuite) TestMix() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Drain").Return().Once()
+	d.On("Close").Return().Once()
+	f := &FactoryMock{}
+	f.On("New", 1000, 1, mock.Anything).Return(d, nil).Once()
+	b := newBus(f, &BufferParams{PeriodFrames: 4}, 0, 0, 0)
+
+	dev, err := b.acquire(1000, 1)
+	a.NoError(err)
+	//the second source shares the device opened for the first one
+	dev2, err := b.acquire(8000, 2)
+	a.NoError(err)
+	a.Equal(dev, dev2)
+	a.Equal(1000, dev.rate)
+	a.Equal(1, dev.channels)
+	in1 := &constInput{value: 1000, frames: 6, held: true}
+	in2 := &constInput{value: 2000, frames: 4, held: true}
+	s1 := newSource(&StreamContext{Priority: 1}, in1)
+	s2 := newSource(&StreamContext{Priority: 1}, in2)
+	a.NoError(b.add(s1, dev))
+	a.NoError(b.add(s2, dev))
+	in1.release()
+	in2.release()
+	a.NoError(s1.wait())
+	a.NoError(s2.wait())
+	time.Sleep(10 * time.Millisecond)
+	a.Equal([][]int16{{3000, 3000, 3000, 3000}, {1000, 1000}}, frames.get())
+	a.Equal(6, s1.framesMixed())
+	d.AssertExpectations(suite.T())
+	f.AssertExpectations(suite.T())
+}
+
+func (suite *BusTestSuite) TestPriorities() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Drain").Return()
+	d.On("Close").Return()
+	d2 := &DeviceMock{}
+	frames2 := consumeFrames(d2)
+	d2.On("Drain").Return()
+	d2.On("Close").Return()
+	f := &FactoryMock{}
+	f.On("New", 1000, 1, mock.Anything).Return(d, nil).Once()
+	f.On("New", 1000, 1, mock.Anything).Return(d2, nil).Once()
+	b := newBus(f, &BufferParams{PeriodFrames: 4}, 0, 0, 1)
+
+	//a lower priority source is muted while a higher one plays
+	dev, _ := b.acquire(1000, 1)
+	b.acquire(1000, 1)
+	low := newSource(&StreamContext{Priority: 1}, &constInput{value: 1000, frames: 8})
+	high := newSource(&StreamContext{Priority: 2}, &constInput{value: 2000, frames: 4})
+	b.add(high, dev)
+	b.add(low, dev)
+	low.wait()
+	time.Sleep(10 * time.Millisecond)
+	a.Equal([][]int16{{2000, 2000, 2000, 2000}, {1000, 1000, 1000, 1000}}, frames.get())
+
+	//with a configured lower priority gain the sources are mixed
+	b.lowerDB = -6.0206
+	dev, _ = b.acquire(1000, 1)
+	b.acquire(1000, 1)
+	low = newSource(&StreamContext{Priority: 1}, &constInput{value: 1000, frames: 4})
+	high = newSource(&StreamContext{Priority: 2}, &constInput{value: 2000, frames: 4})
+	b.add(high, dev)
+	b.add(low, dev)
+	low.wait()
+	time.Sleep(10 * time.Millisecond)
+	a.Equal([][]int16{{2500, 2500, 2500, 2500}}, frames2.get())
+}
+
+func (suite *BusTestSuite) TestDucking() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Drain").Return().Once()
+	d.On("Close").Return().Once()
+	f := &FactoryMock{}
+	f.On("New", 1000, 1, mock.Anything).Return(d, nil).Once()
+	b := newBus(f, &BufferParams{PeriodFrames: 4}, 0, 0, 0)
+	b.ducking = []config.DuckConf{{MinPriority: 1, MaxPriority: 2, Gain: -6.0206, Attack: 1, Release: 2}}
+
+	dev, _ := b.acquire(1000, 1)
+	b.acquire(1000, 1)
+	music := &constInput{value: 1000, frames: 16, held: true}
+	announcement := &constInput{value: 0, frames: 8, held: true}
+	low := newSource(&StreamContext{Priority: 1}, music)
+	b.add(low, dev)
+	b.add(newSource(&StreamContext{Priority: 5}, announcement), dev)
+	music.release()
+	announcement.release()
+	low.wait()
+	time.Sleep(10 * time.Millisecond)
+	//the music is attenuated while the announcement plays and restored after it
+	a.Equal([][]int16{{500, 500, 500, 500}, {500, 500, 500, 500}, {750, 1000, 1000, 1000}, {1000, 1000, 1000, 1000}}, frames.get())
+	a.False(low.attenuated())
+	d.AssertExpectations(suite.T())
+}
+
+func (suite *BusTestSuite) TestSuspend() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Drain").Return().Once()
+	d.On("Close").Return().Once()
+	f := &FactoryMock{}
+	f.On("New", 1000, 1, mock.Anything).Return(d, nil).Once()
+	b := newBus(f, &BufferParams{PeriodFrames: 4}, 0, 0, 0)
+
+	dev, _ := b.acquire(1000, 1)
+	b.acquire(1000, 1)
+	loop := newSource(&StreamContext{Priority: 1}, &constInput{value: 1000, frames: 8})
+	loop.suspend()
+	announcement := newSource(&StreamContext{Priority: 5}, &constInput{value: 2000, frames: 4})
+	b.add(loop, dev)
+	b.add(announcement, dev)
+	announcement.wait()
+	time.Sleep(10 * time.Millisecond)
+	//a suspended source keeps the device open and its position
+	a.Equal([][]int16{{2000, 2000, 2000, 2000}}, frames.get()[:1])
+	a.Equal(0, loop.framesMixed())
+	loop.resume()
+	loop.wait()
+	time.Sleep(10 * time.Millisecond)
+	var played int
+	for _, fr := range frames.get() {
+		for _, v := range fr {
+			if v == 1000 {
+				played++
+			}
+		}
+	}
+	a.Equal(8, played)
+	d.AssertExpectations(suite.T())
+}
+
+func (suite *BusTestSuite) TestDuckingRules() {
+	a := assert.New(suite.T())
+	b := newBus(&FactoryMock{}, &BufferParams{}, 0, 0, 0)
+	b.ducking = []config.DuckConf{
+		{MinPriority: 1, MaxPriority: 2, Types: []string{"music"}, Gain: -20},
+		{MinPriority: 3, Trigger: 8, Gain: -10},
+	}
+	music := &StreamContext{Priority: 2, Type: "music"}
+	a.Equal(-20.0, b.duckRule(music, 3).Gain)
+	a.False(b.ducks(music, 2))
+	a.False(b.ducks(&StreamContext{Priority: 2, Type: "news"}, 5))
+	a.False(b.ducks(&StreamContext{Priority: 0, Type: "music"}, 5))
+	//the second rule only applies under streams of priority 8 and above
+	a.False(b.ducks(&StreamContext{Priority: 4}, 7))
+	a.Equal(-10.0, b.duckRule(&StreamContext{Priority: 40}, 50).Gain)
+}
+
+func (suite *BusTestSuite) TestFades() {
+	a := assert.New(suite.T())
+	b := newBus(&FactoryMock{}, &BufferParams{}, 0, 0, 0)
+	b.fadeIn, b.fadeOut = 4, 4
+	d := &busDevice{rate: 1000, channels: 1, pending: 1}
+	b.cur = d
+	out := make([]float64, 4)
+	s := newSource(&StreamContext{Priority: 1}, &constInput{value: 1000, frames: 100})
+	a.NoError(b.add(s, d))
+	b.mix(out, d)
+	a.Equal([]float64{250, 500, 750, 1000}, out)
+	b.mix(out, d)
+	a.Equal([]float64{1000, 1000, 1000, 1000}, out)
+
+	//a suspended source fades out, keeps its position and fades back in on resume
+	s.suspend()
+	b.mix(out, d)
+	a.Equal([]float64{750, 500, 250, 0}, out)
+	n, _ := b.mix(out, d)
+	a.Equal(0, n)
+	a.Equal(12, s.framesMixed())
+	s.resume()
+	b.mix(out, d)
+	a.Equal([]float64{250, 500, 750, 1000}, out)
+
+	//a stopped source fades out before it is removed, so the device is drained rather than aborted
+	s.abort()
+	n, aborted := b.mix(out, d)
+	a.Equal([]float64{750, 500, 250, 0}, out)
+	a.Equal(4, n)
+	a.False(aborted)
+	n, aborted = b.mix(out, d)
+	a.Equal(0, n)
+	a.False(aborted)
+	a.NoError(s.wait())
+	a.Empty(b.sources)
+
+	//a source that has not played yet is removed at once and has nothing to drop from the device
+	s = newSource(&StreamContext{Priority: 1}, &constInput{value: 1000, frames: 100, held: true})
+	d.pending++
+	b.add(s, d)
+	b.mix(out, d)
+	s.abort()
+	_, aborted = b.mix(out, d)
+	a.False(aborted)
+	a.Empty(b.sources)
+}
+
+func (suite *BusTestSuite) TestCrossfade() {
+	a := assert.New(suite.T())
+	for _, crossfade := range []bool{false, true} {
+		b := newBus(&FactoryMock{}, &BufferParams{}, 0, 0, 0)
+		b.fadeIn, b.fadeOut, b.crossfade = 4, 4, crossfade
+		d := &busDevice{rate: 1000, channels: 1, pending: 2}
+		b.cur = d
+		out := make([]float64, 4)
+		old := newSource(&StreamContext{Priority: 1}, &constInput{value: 1000, frames: 100})
+		b.add(old, d)
+		b.mix(out, d)
+		b.mix(out, d)
+		old.abort()
+		b.add(newSource(&StreamContext{Priority: 1}, &constInput{value: 2000, frames: 100}), d)
+		b.mix(out, d)
+		if crossfade {
+			a.Equal([]float64{1250, 1500, 1750, 2000}, out)
+			continue
+		}
+		//the new source starts once the old one has faded out
+		a.Equal([]float64{750, 500, 250, 0}, out)
+		b.mix(out, d)
+		a.Equal([]float64{500, 1000, 1500, 2000}, out)
+	}
+}
+
+func (suite *BusTestSuite) TestFollow() {
+	a := assert.New(suite.T())
+	b := newBus(&FactoryMock{}, &BufferParams{}, 0, 0, 0)
+	d := &busDevice{rate: 1000, channels: 1, pending: 2}
+	b.cur = d
+	out := make([]float64, 4)
+	intro := newSource(&StreamContext{Priority: 1}, &constInput{value: 1000, frames: 6})
+	stream := newSource(&StreamContext{Priority: 1}, &constInput{value: 2000, frames: 4})
+	stream.follow(intro)
+	b.add(intro, d)
+	b.add(stream, d)
+	b.mix(out, d)
+	a.Equal([]float64{1000, 1000, 1000, 1000}, out)
+	b.notify(0)
+	select {
+	case <-intro.audible:
+	case <-time.After(time.Second):
+		a.Fail("intro not audible")
+	}
+	//the stream starts on the sample following the end of the intro
+	b.mix(out, d)
+	a.Equal([]float64{1000, 1000, 2000, 2000}, out)
+	n, _ := b.mix(out, d)
+	a.Equal(2, n)
+	a.Equal([]float64{2000, 2000, 0, 0}, out)
+	b.notify(0)
+	select {
+	case <-stream.played:
+	case <-time.After(time.Second):
+		a.Fail("stream not played")
+	}
+	a.Empty(b.sources)
+}
+
+func (suite *BusTestSuite) TestAbort() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Abort").Return().Once()
+	d.On("Close").Return().Once()
+	f := &FactoryMock{}
+	f.On("New", 22050, 1, mock.Anything).Return(d, nil).Once()
+	f.On("New", 22050, 1, mock.Anything).Return(nil, errors.New("mock error")).Once()
+	b := newBus(f, &BufferParams{}, 0, 0, 0)
+
+	dev, _ := b.acquire(0, 0)
+	in := &constInput{value: 1000, frames: 100000}
+	s := newSource(&StreamContext{}, in)
+	b.add(s, dev)
+	select {
+	case <-s.audible:
+	case <-time.After(time.Second):
+		a.Fail("source not audible")
+	}
+	s.abort()
+	a.NoError(s.wait())
+	time.Sleep(10 * time.Millisecond)
+	//the last source aborted drops the device queue
+	a.NotEmpty(frames.get())
+	d.AssertExpectations(suite.T())
+
+	_, err := b.acquire(0, 0)
+	_, ok := err.(DeviceError)
+	a.True(ok)
+	//a device gone meanwhile cannot take sources
+	_, ok = b.add(newSource(&StreamContext{}, in), dev).(DeviceError)
+	a.True(ok)
+}
+
+func (suite *BusTestSuite) TestCancelQueued() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	consumeFrames(d)
+	d.On("Drain").Return().Once()
+	d.On("Close").Return().Once()
+	f := &FactoryMock{}
+	f.On("New", 1000, 1, mock.Anything).Return(d, nil).Once()
+	b := newBus(f, &BufferParams{PeriodFrames: 4}, 0, 0, 0)
+	b.idle = 100 * time.Millisecond
+
+	//a source stopped before it played anything leaves the idle device open
+	dev, _ := b.acquire(1000, 1)
+	s := newSource(&StreamContext{}, &constInput{value: 1000, frames: 4, held: true})
+	b.add(s, dev)
+	s.abort()
+	a.NoError(s.wait())
+	time.Sleep(10 * time.Millisecond)
+	d.AssertNotCalled(suite.T(), "Abort")
+	d.AssertNotCalled(suite.T(), "Close")
+	dev2, err := b.acquire(1000, 1)
+	a.NoError(err)
+	a.Equal(dev, dev2)
+	b.cancel(dev2)
+	time.Sleep(150 * time.Millisecond)
+	d.AssertExpectations(suite.T())
+	f.AssertExpectations(suite.T())
+}
+
+func (suite *BusTestSuite) TestStalledReader() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Abort").Return().Once()
+	d.On("Close").Return().Once()
+	f := &FactoryMock{}
+	f.On("New", 1000, 1, mock.Anything).Return(d, nil).Once()
+	b := newBus(f, &BufferParams{PeriodFrames: 4}, 0, 0, 0)
+
+	dev, _ := b.acquire(1000, 1)
+	dev, _ = b.acquire(1000, 1)
+	//a reader waiting for I/O does not hold up the other sources: the one below gets its first chunk of
+	//silence and waits for the rest
+	r, w := io.Pipe()
+	go w.Write(make([]byte, 2*pipelineReadBuffer))
+	stalled := newSource(&StreamContext{}, newReaderInput(r, 1, 0))
+	b.add(stalled, dev)
+	playing := newSource(&StreamContext{}, &constInput{value: 1000, frames: 8})
+	b.add(playing, dev)
+	a.NoError(playing.wait())
+	time.Sleep(10 * time.Millisecond)
+	if played := frames.get(); a.True(len(played) >= 2) {
+		//the device is fed with silence while the reader waits
+		a.Equal([][]int16{{1000, 1000, 1000, 1000}, {1000, 1000, 1000, 1000}}, played[:2])
+	}
+	//the last source stopped drops the device queue
+	stalled.abort()
+	a.NoError(stalled.wait())
+	w.Close()
+	time.Sleep(10 * time.Millisecond)
+	d.AssertExpectations(suite.T())
+}
+
+func (suite *BusTestSuite) TestDeviceError() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	e := make(chan error)
+	d.On("WriteAsync", mock.AnythingOfType("chan []int16")).Return(e)
+	d.On("Abort").Return().Once()
+	d.On("Close").Return().Once()
+	f := &FactoryMock{}
+	f.On("New", 1000, 1, mock.Anything).Return(d, nil).Once()
+	//the device cannot be reopened
+	f.On("New", 1000, 1, mock.Anything).Return(nil, errors.New("mock error")).Once()
+	b := newBus(f, &BufferParams{}, 0, 0, 0)
+	dev, _ := b.acquire(1000, 1)
+	s := newSource(&StreamContext{}, &constInput{value: 1, frames: 1000})
+	b.add(s, dev)
+	e <- errors.New("mock error")
+	_, ok := s.wait().(DeviceError)
+	a.True(ok)
+	time.Sleep(10 * time.Millisecond)
+	d.AssertExpectations(suite.T())
+	f.AssertExpectations(suite.T())
+}
+
+func (suite *BusTestSuite) TestReopen() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	e := make(chan error)
+	d.On("WriteAsync", mock.AnythingOfType("chan []int16")).Return(e)
+	d.On("Abort").Return().Once()
+	d.On("Close").Return().Once()
+	d.On("Xruns").Return(2)
+	d2 := &DeviceMock{}
+	frames := consumeFrames(d2)
+	d2.On("Drain").Return().Once()
+	d2.On("Close").Return().Once()
+	d2.On("Xruns").Return(1)
+	f := &FactoryMock{}
+	f.On("New", 1000, 1, mock.Anything).Return(d, nil).Once()
+	f.On("New", 1000, 1, mock.Anything).Return(d2, nil).Once()
+	b := newBus(f, &BufferParams{PeriodFrames: 4}, 0, 0, 0)
+	dev, _ := b.acquire(1000, 1)
+	s := newSource(&StreamContext{}, &constInput{value: 1, frames: 12})
+	b.add(s, dev)
+	//the source goes on playing on the reopened device; the period being written is lost
+	e <- errors.New("mock error")
+	a.NoError(s.wait())
+	time.Sleep(10 * time.Millisecond)
+	a.Equal([][]int16{{1, 1, 1, 1}, {1, 1, 1, 1}}, frames.get())
+	a.Equal(3, dev.xruns+d2.Xruns())
+	d.AssertExpectations(suite.T())
+	d2.AssertExpectations(suite.T())
+}
+
+func (suite *BusTestSuite) TestIdle() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Drain").Return().Once()
+	d.On("Close").Return().Once()
+	d2 := &DeviceMock{}
+	consumeFrames(d2)
+	d2.On("Drain").Return().Once()
+	d2.On("Close").Return().Once()
+	f := &FactoryMock{}
+	f.On("New", 1000, 1, mock.Anything).Return(d, nil).Once()
+	f.On("New", 2000, 1, mock.Anything).Return(d2, nil).Once()
+	b := newBus(f, &BufferParams{PeriodFrames: 4}, 0, 0, 0)
+	b.idle = 100 * time.Millisecond
+
+	dev, _ := b.acquire(1000, 1)
+	s := newSource(&StreamContext{}, &constInput{value: 1000, frames: 4})
+	b.add(s, dev)
+	a.NoError(s.wait())
+	//the device stays open fed with silence and the next source plays on it
+	time.Sleep(5 * time.Millisecond)
+	dev2, err := b.acquire(1000, 1)
+	a.NoError(err)
+	a.Equal(dev, dev2)
+	s = newSource(&StreamContext{}, &constInput{value: 1000, frames: 4})
+	b.add(s, dev2)
+	a.NoError(s.wait())
+	time.Sleep(5 * time.Millisecond)
+	var played, silent int
+	for _, fr := range frames.get() {
+		for _, v := range fr {
+			if v == 1000 {
+				played++
+			} else {
+				silent++
+			}
+		}
+	}
+	a.Equal(8, played)
+	a.True(silent > 0)
+
+	//an idle device is reopened for a source in another format
+	dev3, err := b.acquire(2000, 1)
+	a.NoError(err)
+	a.Equal(2000, dev3.rate)
+	d.AssertExpectations(suite.T())
+	//and closed once it has been idle for the timeout
+	b.cancel(dev3)
+	time.Sleep(10 * time.Millisecond)
+	d2.AssertNotCalled(suite.T(), "Close")
+	time.Sleep(150 * time.Millisecond)
+	d2.AssertExpectations(suite.T())
+	f.AssertExpectations(suite.T())
+}
+
+func (suite *BusTestSuite) TestLimiter() {
+	a := assert.New(suite.T())
+	l := newLimiter(2, 1000, 10)
+	out := l.process([]float64{40000, -20000, 1000, 1000})
+	//the peak is brought down to full scale and the gain holds the channel balance
+	a.Equal(int16(32767), out[0])
+	a.InDelta(-16384, out[1], 1)
+	a.True(out[2] < 1000)
+	//the gain recovers after the release time
+	for i := 0; i < 100; i++ {
+		out = l.process([]float64{1000, 1000})
+	}
+	a.Equal([]int16{1000, 1000}, out)
+	a.Equal([]int16{32767, -32767}, l.process([]float64{1e6, -1e6}))
+}
+
+func TestBusTestSuite(t *testing.T) {
+	suite.Run(t, new(BusTestSuite))
+}
@@ -0,0 +1,107 @@
+package audio
+
+import "fmt"
+
+//stereoDownmixGain keeps a downmix of fully correlated channels (e.g. dual mono) at the original level without clipping
+const stereoDownmixGain = 0.5
+
+/*ChannelMapper converts interleaved frames between channel layouts using a mixing matrix.
+The matrix has one row per output channel and one column per input channel:
+
+	out[o] = sum(matrix[o][i] * in[i])
+*/
+type ChannelMapper struct {
+	in     int
+	out    int
+	matrix [][]float64
+}
+
+//NewChannelMapper validates 'matrix' and creates a mapper from 'in' to 'out' channels
+func NewChannelMapper(in int, out int, matrix [][]float64) (*ChannelMapper, error) {
+	if in < 1 || out < 1 {
+		return nil, fmt.Errorf("invalid channel mapping %d to %d", in, out)
+	}
+	if len(matrix) != out {
+		return nil, fmt.Errorf("channel matrix for %d to %d channels needs %d rows, got %d", in, out, out, len(matrix))
+	}
+	for o, row := range matrix {
+		if len(row) != in {
+			return nil, fmt.Errorf("channel matrix row %d needs %d columns, got %d", o, in, len(row))
+		}
+	}
+	return &ChannelMapper{in: in, out: out, matrix: matrix}, nil
+}
+
+/*DefaultChannelMatrix returns the matrix used when none is configured for a pair of layouts:
+  - a mono stream is duplicated to the device channels listed in 'mono' (all when empty)
+  - a mono device gets the average of the stream channels (-6 dB for stereo)
+  - otherwise output channel n gets input channel n; extra output channels repeat the input
+    layout and extra input channels are averaged into the output channel they wrap onto
+
+Invalid channel numbers in 'mono' are ignored.
+*/
+func DefaultChannelMatrix(in int, out int, mono []int) [][]float64 {
+	m := make([][]float64, out)
+	for o := range m {
+		m[o] = make([]float64, in)
+	}
+	switch {
+	case in == 1:
+		var selected bool
+		for _, o := range mono {
+			if o >= 0 && o < out {
+				m[o][0] = 1
+				selected = true
+			}
+		}
+		if !selected {
+			for o := range m {
+				m[o][0] = 1
+			}
+		}
+	case out == 1:
+		g := 1 / float64(in)
+		if in == 2 {
+			g = stereoDownmixGain
+		}
+		for i := 0; i < in; i++ {
+			m[0][i] = g
+		}
+	case out >= in:
+		for o := 0; o < out; o++ {
+			m[o][o%in] = 1
+		}
+	default:
+		counts := make([]int, out)
+		for i := 0; i < in; i++ {
+			counts[i%out]++
+		}
+		for i := 0; i < in; i++ {
+			m[i%out][i] = 1 / float64(counts[i%out])
+		}
+	}
+	return m
+}
+
+//Channels returns the number of output channels
+func (m *ChannelMapper) Channels() int {
+	return m.out
+}
+
+//Process maps whole frames of 'in' to a newly allocated buffer
+func (m *ChannelMapper) Process(in []int16) []int16 {
+	frames := len(in) / m.in
+	out := make([]int16, frames*m.out)
+	for f := 0; f < frames; f++ {
+		src := in[f*m.in : (f+1)*m.in]
+		dst := out[f*m.out : (f+1)*m.out]
+		for o, row := range m.matrix {
+			var acc float64
+			for i, g := range row {
+				acc += g * float64(src[i])
+			}
+			dst[o] = clampS16(acc)
+		}
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package audio
+
+import (
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ChannelsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ChannelsTestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func (suite *ChannelsTestSuite) TestDefaultMatrix() {
+	a := assert.New(suite.T())
+	a.Equal([][]float64{{1}, {1}}, DefaultChannelMatrix(1, 2, nil))
+	a.Equal([][]float64{{0}, {1}}, DefaultChannelMatrix(1, 2, []int{1, 5}))
+	a.Equal([][]float64{{0.5, 0.5}}, DefaultChannelMatrix(2, 1, nil))
+	a.Equal([][]float64{{1, 0}, {0, 1}, {1, 0}, {0, 1}}, DefaultChannelMatrix(2, 4, nil))
+	a.Equal([][]float64{{0.5, 0, 0.5, 0}, {0, 0.5, 0, 0.5}}, DefaultChannelMatrix(4, 2, nil))
+}
+
+func (suite *ChannelsTestSuite) TestMapper() {
+	a := assert.New(suite.T())
+	_, err := NewChannelMapper(2, 1, [][]float64{{1, 1}, {1, 1}})
+	a.Error(err)
+	_, err = NewChannelMapper(2, 1, [][]float64{{1}})
+	a.Error(err)
+	_, err = NewChannelMapper(0, 1, [][]float64{{}})
+	a.Error(err)
+
+	m, err := NewChannelMapper(2, 1, DefaultChannelMatrix(2, 1, nil))
+	a.NoError(err)
+	a.Equal(1, m.Channels())
+	a.Equal([]int16{0, 1000}, m.Process([]int16{1000, -1000, 1000, 1000}))
+
+	//summing without attenuation saturates instead of wrapping around
+	m, _ = NewChannelMapper(2, 1, [][]float64{{1, 1}})
+	a.Equal([]int16{32767, -32768}, m.Process([]int16{30000, 30000, -30000, -30000}))
+
+	m, _ = NewChannelMapper(1, 2, DefaultChannelMatrix(1, 2, nil))
+	a.Equal([]int16{7, 7, -3, -3}, m.Process([]int16{7, -3}))
+}
+
+func (suite *ChannelsTestSuite) TestPlaybackMapper() {
+	a := assert.New(suite.T())
+	conf := &config.AudioConf{
+		DeviceChannels: 2,
+		ChannelMaps: []config.ChannelMapConf{
+			{In: 1, Out: 2, Matrix: [][]float64{{1}, {0}}},
+			{In: 4, Out: 2, Matrix: [][]float64{{1}}},
+		},
+	}
+	p := New(conf, &FactoryMock{}, "").(*play)
+	a.Nil(p.newMapper(2, 2))
+	m := p.newMapper(1, 2)
+	a.Equal([]int16{5, 0}, m.Process([]int16{5}))
+	//an invalid configured matrix falls back to the default one
+	m = p.newMapper(4, 2)
+	a.Equal([]int16{5, 5}, m.Process([]int16{5, 5, 5, 5}))
+}
+
+func TestChannelsTestSuite(t *testing.T) {
+	suite.Run(t, new(ChannelsTestSuite))
+}
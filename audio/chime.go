@@ -0,0 +1,159 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mklimuk/test-alsa/config"
+)
+
+const (
+	//chimeLevel is the peak level of synthesized chimes in dBFS
+	chimeLevel = -6.0
+	//chimeRelease is the fade closing every synthesized tone in ms; it keeps tones cut while ringing from clicking
+	chimeRelease = 5
+)
+
+/*chimes holds the chimes configured by name and the rules choosing them for streams that request an intro
+without naming a chime. The intro file given to New (the unnamed chime) is played when no rule applies.
+*/
+type chimes struct {
+	byName   map[string]config.ChimeConf
+	rules    []config.ChimeRuleConf
+	fallback string
+}
+
+func newChimes(conf []config.ChimeConf, rules []config.ChimeRuleConf, fallback string) *chimes {
+	c := &chimes{byName: make(map[string]config.ChimeConf), rules: rules, fallback: fallback}
+	for _, ch := range conf {
+		c.byName[ch.Name] = ch
+	}
+	return c
+}
+
+//rule returns the first default rule covering 'context' or nil
+func (c *chimes) rule(context *StreamContext) *config.ChimeRuleConf {
+	for i := range c.rules {
+		r := &c.rules[i]
+		if context.Priority < r.MinPriority || (r.MaxPriority != 0 && context.Priority > r.MaxPriority) {
+			continue
+		}
+		if len(r.Types) == 0 {
+			return r
+		}
+		for _, t := range r.Types {
+			if t == context.Type {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+//intro returns the name of the intro chime of 'context' (empty for the intro file); ok is false when the stream has no intro
+func (c *chimes) intro(context *StreamContext) (name string, ok bool) {
+	if context.Chime != "" {
+		return context.Chime, true
+	}
+	if !context.PlayIntro {
+		return "", false
+	}
+	if r := c.rule(context); r != nil {
+		return r.Intro, true
+	}
+	return "", true
+}
+
+//outro returns the name of the outro chime of 'context'; it is empty when the stream has no outro
+func (c *chimes) outro(context *StreamContext) string {
+	if context.Outro != "" {
+		return context.Outro
+	}
+	if !context.PlayIntro || context.Chime != "" {
+		return ""
+	}
+	if r := c.rule(context); r != nil {
+		return r.Outro
+	}
+	return ""
+}
+
+//openChime adds the chime called 'name' to the bus as a source with 'context' starting after 'after' (when not nil)
+func (p *play) openChime(name string, context *StreamContext, after *source) (*source, error) {
+	if name == "" {
+		return p.openFile(p.chimes.fallback, context, 0, after)
+	}
+	ch, ok := p.chimes.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown chime %s", name)
+	}
+	if ch.File != "" {
+		return p.openFile(ch.File, context, ch.Gain, after)
+	}
+	d, err := p.bus.acquire(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	src := newSource(context, newGongInput(ch, d.rate, newPipeline(d.rate, d.rate, 1, p.masterGain(0, 1, d.rate), p.newMapper(1, d.channels))))
+	src.follow(after)
+	if err = p.bus.add(src, d); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+/*gongInput synthesizes the tones of a chime at the rate of the bus; the mono signal runs through 'pipe' which
+applies the master gain and maps it to the device channels. Every tone is the sum of its partials shaped by
+a linear attack and an exponential decay and closed by a short release.
+*/
+type gongInput struct {
+	tones []config.ToneConf
+	rate  int
+	pipe  *pipeline
+	//amp is the peak sample value
+	amp  float64
+	tone int
+	pos  int
+}
+
+func newGongInput(ch config.ChimeConf, rate int, pipe *pipeline) *gongInput {
+	return &gongInput{tones: ch.Tones, rate: rate, pipe: pipe, amp: 32767 * DBToLinear(chimeLevel+ch.Gain)}
+}
+
+func (g *gongInput) read(max int) ([]int16, bool) {
+	frames := max / g.pipe.outChannels()
+	out := make([]int16, 0, frames)
+	for len(out) < frames && g.tone < len(g.tones) {
+		t := &g.tones[g.tone]
+		n := msToFrames(t.Duration, g.rate)
+		out = append(out, int16(g.amp*g.sample(t, g.pos, n)))
+		if g.pos++; g.pos >= n {
+			g.tone++
+			g.pos = 0
+		}
+	}
+	return g.pipe.process(out), g.tone < len(g.tones)
+}
+
+//sample returns frame 'pos' of tone 't' lasting 'n' frames scaled to [-1, 1]
+func (g *gongInput) sample(t *config.ToneConf, pos int, n int) float64 {
+	if len(t.Frequencies) == 0 {
+		return 0
+	}
+	at := float64(pos) / float64(g.rate)
+	env := 1.0
+	if attack := float64(t.Attack) / 1000; at < attack {
+		env = at / attack
+	}
+	if t.Decay > 0 {
+		env *= math.Exp(-at * 1000 / float64(t.Decay))
+	}
+	if release := fadeFrames(chimeRelease, g.rate); n-pos <= release {
+		env *= float64(n-pos-1) / float64(release)
+	}
+	var v float64
+	for _, f := range t.Frequencies {
+		v += math.Sin(2 * math.Pi * f * at)
+	}
+	return env * v / float64(len(t.Frequencies))
+}
@@ -0,0 +1,164 @@
+package audio
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type ChimeTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ChimeTestSuite) SetupSuite() {
+	log.SetLevel(log.ErrorLevel)
+}
+
+func (suite *ChimeTestSuite) TearDownSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func (suite *ChimeTestSuite) TestGong() {
+	a := assert.New(suite.T())
+	m, _ := NewChannelMapper(1, 2, DefaultChannelMatrix(1, 2, nil))
+	g := newGongInput(config.ChimeConf{Tones: []config.ToneConf{
+		{Frequencies: []float64{250}, Duration: 20},
+		{Frequencies: []float64{125, 250}, Duration: 40, Attack: 10, Decay: 10},
+	}}, 1000, newPipeline(1000, 1000, 1, nil, m))
+	var out []int16
+	for {
+		s, ok := g.read(8)
+		a.True(len(s) <= 8)
+		out = append(out, s...)
+		if !ok {
+			break
+		}
+	}
+	a.Len(out, 120)
+	peak := 32767 * DBToLinear(chimeLevel)
+	for i := 0; i < len(out); i += 2 {
+		a.Equal(out[i], out[i+1], "channels differ at frame %d", i/2)
+		a.True(math.Abs(float64(out[i])) <= peak+1)
+	}
+	//the first tone peaks on its second frame and every tone ends in silence
+	a.InDelta(peak, out[2], 1)
+	a.Equal(int16(0), out[38])
+	a.Equal(int16(0), out[118])
+	//the second one rises over the attack
+	a.True(math.Abs(float64(out[44])) < math.Abs(float64(out[52])))
+}
+
+func (suite *ChimeTestSuite) TestSelection() {
+	a := assert.New(suite.T())
+	c := newChimes(nil, []config.ChimeRuleConf{
+		{MinPriority: 5, Intro: "emergency", Outro: "emergency-end"},
+		{Types: []string{"departure"}, Intro: "departure"},
+	}, "dong.wav")
+	name, ok := c.intro(&StreamContext{Priority: 1})
+	a.False(ok)
+	name, ok = c.intro(&StreamContext{Priority: 1, Chime: "delay"})
+	a.True(ok)
+	a.Equal("delay", name)
+	a.Equal("", c.outro(&StreamContext{Priority: 1, Chime: "delay"}))
+	a.Equal("delay-end", c.outro(&StreamContext{Priority: 1, Chime: "delay", Outro: "delay-end"}))
+
+	//streams requesting an intro get the one of the first rule covering them
+	name, _ = c.intro(&StreamContext{Priority: 6, PlayIntro: true, Type: "departure"})
+	a.Equal("emergency", name)
+	a.Equal("emergency-end", c.outro(&StreamContext{Priority: 6, PlayIntro: true}))
+	name, _ = c.intro(&StreamContext{Priority: 1, PlayIntro: true, Type: "departure"})
+	a.Equal("departure", name)
+	a.Equal("", c.outro(&StreamContext{Priority: 1, PlayIntro: true, Type: "departure"}))
+	//and the intro file when none does
+	name, ok = c.intro(&StreamContext{Priority: 1, PlayIntro: true})
+	a.True(ok)
+	a.Equal("", name)
+}
+
+func (suite *ChimeTestSuite) TestOpen() {
+	a := assert.New(suite.T())
+	f, err := ioutil.TempFile("", "chime")
+	a.NoError(err)
+	defer os.Remove(f.Name())
+	f.Write(wavFile(wavChunk("fmt ", wavFmt(wavFormatPCM, 1, 1000, 16)), wavChunk("data", []byte{0xE8, 0x03, 0xE8, 0x03})))
+	f.Close()
+
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Drain").Return()
+	d.On("Close").Return()
+	fm := &FactoryMock{}
+	fm.On("New", 1000, 1, mock.Anything).Return(d, nil).Twice()
+	p := New(&config.AudioConf{DeviceRate: 1000, DeviceChannels: 1, FadeIn: -1, FadeOut: -1, DeviceIdle: -1, Chimes: []config.ChimeConf{
+		{Name: "file", File: f.Name(), Gain: -6.0206},
+		{Name: "gong", Tones: []config.ToneConf{{Frequencies: []float64{250}, Duration: 8}}},
+	}}, fm, "").(*play)
+
+	_, err = p.openChime("missing", &StreamContext{}, nil)
+	a.Error(err)
+	file, err := p.openChime("file", &StreamContext{}, nil)
+	a.NoError(err)
+	a.NoError(file.wait())
+	time.Sleep(10 * time.Millisecond)
+	a.Equal([][]int16{{500, 500}}, frames.get())
+	//a synthesized chime is generated in the format of the device
+	gong, err := p.openChime("gong", &StreamContext{}, nil)
+	a.NoError(err)
+	a.NoError(gong.wait())
+	time.Sleep(10 * time.Millisecond)
+	if played := frames.get(); a.Len(played, 2) {
+		a.Len(played[1], 8)
+		a.InDelta(32767*DBToLinear(chimeLevel), played[1][1], 1)
+	}
+	fm.AssertExpectations(suite.T())
+}
+
+func (suite *ChimeTestSuite) TestMasterVolume() {
+	a := assert.New(suite.T())
+	f, err := ioutil.TempFile("", "chime")
+	a.NoError(err)
+	defer os.Remove(f.Name())
+	f.Write(wavFile(wavChunk("fmt ", wavFmt(wavFormatPCM, 1, 1000, 16)), wavChunk("data", []byte{0xE8, 0x03, 0xE8, 0x03})))
+	f.Close()
+
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Drain").Return()
+	d.On("Close").Return()
+	fm := &FactoryMock{}
+	d.On("Xruns").Return(0)
+	fm.On("New", 1000, 1, mock.Anything).Return(d, nil)
+	p := New(&config.AudioConf{DeviceRate: 1000, DeviceChannels: 1, FadeIn: -1, FadeOut: -1, DeviceIdle: -1, Chimes: []config.ChimeConf{
+		{Name: "file", File: f.Name()},
+		{Name: "gong", Tones: []config.ToneConf{{Frequencies: []float64{250}, Duration: 8}}},
+	}}, fm, f.Name()).(*play)
+	a.NoError(p.Master().SetVolume(0))
+
+	//chimes, files and signals are muted with the master volume like streams
+	for _, name := range []string{"file", "gong", ""} {
+		c, err := p.openChime(name, &StreamContext{}, nil)
+		a.NoError(err)
+		a.NoError(c.wait())
+	}
+	a.NoError(p.PlayFile(f.Name()))
+	a.NoError(p.PlaySignal(TestSignal{Type: SignalSine, Frequency: 250, Duration: 8}, &StreamContext{}))
+	time.Sleep(30 * time.Millisecond)
+	//the number of writes depends on the scheduling of the bus; every one of them has to be silent
+	played := frames.get()
+	a.NotEmpty(played)
+	for _, frame := range played {
+		a.Equal(make([]int16, len(frame)), frame)
+	}
+}
+
+func TestChimeTestSuite(t *testing.T) {
+	suite.Run(t, new(ChimeTestSuite))
+}
@@ -0,0 +1,47 @@
+package audio
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+//ErrUnknownContainer is returned when the stream is neither a WAV nor an Ogg file
+var ErrUnknownContainer = errors.New("unrecognized audio container; expected WAV or Ogg Vorbis")
+
+//Decoder provides interleaved signed 16-bit little endian samples decoded from an audio container
+type Decoder interface {
+	io.Reader
+	SampleRate() int
+	Channels() int
+}
+
+//NewDecoder recognizes the container by its magic number and returns the matching decoder
+func NewDecoder(r *bufio.Reader) (Decoder, error) {
+	magic, err := r.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch string(magic) {
+	case "RIFF":
+		return NewWavReader(r)
+	case "OggS":
+		return NewOggReader(r)
+	}
+	return nil, ErrUnknownContainer
+}
+
+//readErrors records the first error of the underlying reader other than io.EOF so that a stream that could not
+//be read can be told apart from a stream that could not be decoded
+type readErrors struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrors) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
@@ -1,13 +1,20 @@
 package audio
 
 import (
+	"errors"
 	"io"
+	"sync"
+	"sync/atomic"
 
 	log "github.com/Sirupsen/logrus"
 )
 
 const sampleSizeBytes = 2
 
+//ErrUnderrun is returned by RawDevice.Write when the device ran out of audio (ALSA xrun);
+//the device is prepared again and the buffer may be written anew
+var ErrUnderrun = errors.New("device buffer underrun")
+
 //BufferParams is a copy of Alsa configuration parameters present in audio package for isolation purposes
 //(to allow testability of audio package without cgo)
 type BufferParams struct {
@@ -20,14 +27,21 @@ type BufferParams struct {
 //It is defined for testing convienience (mocking and cgo independence)
 type RawDevice interface {
 	Write(buffer interface{}) (samples int, err error)
+	Drop() error
 	Close()
 }
 
-//PlaybackDevice is responsible for sending data to the audio device
+/*PlaybackDevice is responsible for sending data to the audio device.
+A device is finished either with Drain, which plays out everything queued, or with Abort, which discards it.
+Close releases the device and must be called in both cases.
+*/
 type PlaybackDevice interface {
 	WriteSync(reader io.Reader) error
 	WriteAsync(buffer chan []int16) chan error
 	FramesWrote() int
+	Xruns() int
+	Drain()
+	Abort()
 	Close()
 }
 
@@ -38,19 +52,31 @@ type DeviceFactory interface {
 
 type dev struct {
 	bufferSize  int
-	framesWrote int
+	framesWrote int64
+	xruns       int32
+	aborted     int32
 	ctrl        chan bool
+	done        chan bool
 	errors      chan error
 	raw         RawDevice
+	closeOnce   sync.Once
+	//the raw device is not safe for concurrent calls: while a goroutine is 'writing' it owns the device
+	//and drops it itself when aborted; 'wake' tells it about the abort
+	mutex   sync.Mutex
+	writing bool
+	dropped bool
+	wake    chan bool
 }
 
 //NewPlaybackDevice is the audio device constructor
 func NewPlaybackDevice(raw RawDevice, bufferSize int) PlaybackDevice {
-	d := dev{bufferSize: bufferSize, raw: raw}
+	d := dev{bufferSize: bufferSize, raw: raw, wake: make(chan bool, 1)}
 	return &d
 }
 
 func (d *dev) WriteSync(reader io.Reader) error {
+	d.own()
+	defer d.release()
 
 	buf := make([]byte, d.bufferSize)
 	buf16 := make([]int16, d.bufferSize/sampleSizeBytes)
@@ -60,26 +86,34 @@ func (d *dev) WriteSync(reader io.Reader) error {
 	var wrote int
 
 	for {
-		if read, err = reader.Read(buf); err != nil && err != io.EOF {
+		//short reads would otherwise leave stale samples at the end of the buffer
+		if read, err = io.ReadFull(reader, buf); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 			return err
 		}
+		read -= read % sampleSizeBytes
 		if read == 0 {
 			break
 		}
-		convertBuffers(buf, buf16)
-		if wrote, err = d.raw.Write(buf16); err != nil {
+		if atomic.LoadInt32(&d.aborted) == 1 {
+			break
+		}
+		convertBuffers(buf[:read], buf16[:read/sampleSizeBytes])
+		if wrote, err = d.write(buf16[:read/sampleSizeBytes]); err != nil {
 			log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "playFile"}).
 				WithError(err).Error("Could not write buffer content to device")
 			return err
 		}
-		d.framesWrote += wrote
+		atomic.AddInt64(&d.framesWrote, int64(wrote))
 	}
 	return nil
 }
 
 func (d *dev) WriteAsync(buffer chan []int16) chan error {
 	d.ctrl = make(chan bool)
+	d.done = make(chan bool)
 	d.errors = make(chan error)
+	//the send loop owns the raw device from now on
+	d.own()
 	go d.sendToDevice(buffer)
 	return d.errors
 }
@@ -89,6 +123,8 @@ func (d *dev) sendToDevice(buffer chan []int16) {
 	var wrote int
 	var frame []int16
 	var ok bool
+	defer close(d.done)
+	defer d.release()
 
 	for {
 		select {
@@ -100,14 +136,29 @@ func (d *dev) sendToDevice(buffer chan []int16) {
 				}
 				return
 			}
-			if wrote, err = d.raw.Write(frame); err != nil {
-				d.errors <- err
+			if atomic.LoadInt32(&d.aborted) == 1 {
+				//keep consuming so that the producer does not block until it notices the abort
+				d.drop()
+				continue
+			}
+			if wrote, err = d.write(frame); err != nil {
+				//the reader may be gone already (e.g. the device is being closed)
+				select {
+				case d.errors <- err:
+				case <-d.wake:
+					//the stream is aborted; nobody waits for the error anymore
+					d.drop()
+				case <-d.ctrl:
+					return
+				}
 			}
 			if log.GetLevel() >= log.DebugLevel {
 				log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "sendToDevice", "wroteFrames": wrote}).
 					Debug("Wrote read buffer to device")
 			}
-			d.framesWrote += wrote
+			atomic.AddInt64(&d.framesWrote, int64(wrote))
+		case <-d.wake:
+			d.drop()
 		case <-d.ctrl:
 			if log.GetLevel() >= log.InfoLevel {
 				log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "sendToDevice"}).
@@ -118,17 +169,97 @@ func (d *dev) sendToDevice(buffer chan []int16) {
 	}
 }
 
-func (d *dev) Close() {
-	if d.ctrl != nil {
-		d.ctrl <- true
-		close(d.ctrl)
+//write sends 'buf' to the device recovering from an xrun in place by writing the buffer again
+func (d *dev) write(buf []int16) (int, error) {
+	wrote, err := d.raw.Write(buf)
+	if err == ErrUnderrun {
+		atomic.AddInt32(&d.xruns, 1)
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "write"}).
+			Warn("Device buffer underrun; recovering")
+		wrote, err = d.raw.Write(buf)
 	}
-	if d.errors != nil {
-		close(d.errors)
+	return wrote, err
+}
+
+//Abort discards audio queued in the device and makes pending writes no-ops; the device still has to be closed.
+//While a write loop runs the device is dropped by that loop once the write in progress returns.
+func (d *dev) Abort() {
+	if !atomic.CompareAndSwapInt32(&d.aborted, 0, 1) {
+		return
 	}
-	d.raw.Close()
+	d.mutex.Lock()
+	writing := d.writing
+	d.mutex.Unlock()
+	if writing {
+		select {
+		case d.wake <- true:
+		default:
+		}
+		return
+	}
+	d.drop()
+}
+
+//own makes the calling goroutine the writer of the raw device until it calls release
+func (d *dev) own() {
+	d.mutex.Lock()
+	d.writing = true
+	d.mutex.Unlock()
+}
+
+//release ends the ownership of the raw device; an abort that happened meanwhile is carried out
+func (d *dev) release() {
+	d.mutex.Lock()
+	d.writing = false
+	d.mutex.Unlock()
+	d.drop()
+}
+
+//drop discards the audio queued in an aborted device once; it must not be called concurrently with raw writes
+func (d *dev) drop() {
+	if atomic.LoadInt32(&d.aborted) == 0 {
+		return
+	}
+	d.mutex.Lock()
+	dropped := d.dropped
+	d.dropped = true
+	d.mutex.Unlock()
+	if dropped {
+		return
+	}
+	if err := d.raw.Drop(); err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "Abort"}).
+			WithError(err).Warn("Could not drop device buffer")
+	}
+}
+
+//Drain waits until everything queued has been written and closes the device which plays out its hardware buffer.
+//In asynchronous mode the caller has to close the buffer channel first.
+func (d *dev) Drain() {
+	if d.done != nil {
+		<-d.done
+	}
+	d.Close()
+}
+
+func (d *dev) Close() {
+	d.closeOnce.Do(func() {
+		if d.ctrl != nil {
+			//the send loop may have finished on its own when the buffer channel was closed
+			close(d.ctrl)
+			<-d.done
+			close(d.errors)
+		}
+		//closing the raw device drains it unless it has been dropped
+		d.raw.Close()
+	})
 }
 
 func (d *dev) FramesWrote() int {
-	return d.framesWrote
+	return int(atomic.LoadInt64(&d.framesWrote))
+}
+
+//Xruns returns the number of device underruns recovered so far
+func (d *dev) Xruns() int {
+	return int(atomic.LoadInt32(&d.xruns))
 }
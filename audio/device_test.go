@@ -1,10 +1,14 @@
 package audio
 
 import (
+	"bytes"
+	"errors"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -29,12 +33,54 @@ func (suite *DeviceTestSuite) TestConstructor() {
 	a.Equal(1024, dev.bufferSize)
 	a.Nil(dev.errors)
 	a.Nil(dev.ctrl)
-	a.Equal(0, dev.framesWrote)
+	a.Equal(0, dev.FramesWrote())
 }
 
 func (suite *DeviceTestSuite) TestSyncPlaybackError() {
 	r := &RawDeviceMock{}
-	b := buffers.Byte
+	r.On("Write", mock.AnythingOfType("[]int16")).Return(0, errors.New("mock error")).Once()
+	d := NewPlaybackDevice(r, 4)
+	err := d.WriteSync(bytes.NewReader([]byte{0x01, 0x00, 0x02, 0x00}))
+	a := assert.New(suite.T())
+	a.Error(err)
+	a.Equal(0, d.FramesWrote())
+	r.AssertExpectations(suite.T())
+}
+
+func (suite *DeviceTestSuite) TestSyncPlaybackShortRead() {
+	r := &RawDeviceMock{}
+	var wrote [][]int16
+	r.On("Write", mock.AnythingOfType("[]int16")).Run(func(args mock.Arguments) {
+		wrote = append(wrote, append([]int16(nil), args.Get(0).([]int16)...))
+	}).Return(1, nil).Twice()
+	d := NewPlaybackDevice(r, 4)
+	//odd trailing byte is dropped
+	err := d.WriteSync(bytes.NewReader([]byte{0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04}))
+	a := assert.New(suite.T())
+	a.NoError(err)
+	a.Equal([][]int16{{1, 2}, {3}}, wrote)
+	a.Equal(2, d.FramesWrote())
+}
+
+func (suite *DeviceTestSuite) TestXrunRecovery() {
+	r := &RawDeviceMock{}
+	r.On("Write", []int16{1}).Return(0, ErrUnderrun).Once()
+	r.On("Write", []int16{1}).Return(1, nil).Once()
+	r.On("Write", []int16{2}).Return(0, ErrUnderrun).Twice()
+	r.On("Close").Return().Once()
+	d := NewPlaybackDevice(r, 2)
+	buf := make(chan []int16, 2)
+	errs := d.WriteAsync(buf)
+	//the first xrun is recovered by writing the buffer again; a repeated one is reported
+	buf <- []int16{1}
+	buf <- []int16{2}
+	a := assert.New(suite.T())
+	a.Equal(ErrUnderrun, <-errs)
+	close(buf)
+	d.Drain()
+	a.Equal(2, d.Xruns())
+	a.Equal(1, d.FramesWrote())
+	r.AssertExpectations(suite.T())
 }
 
 func (suite *DeviceTestSuite) TestConvertBuffers() {
@@ -46,11 +92,79 @@ func (suite *DeviceTestSuite) TestPlaybackOngoing() {
 }
 
 func (suite *DeviceTestSuite) TestRegularPlayback() {
+	r := &RawDeviceMock{}
+	r.On("Write", mock.AnythingOfType("[]int16")).Return(1, nil).Times(3)
+	r.On("Close").Return().Once()
+	d := NewPlaybackDevice(r, 2)
+	buf := make(chan []int16, 3)
+	d.WriteAsync(buf)
+	buf <- []int16{1}
+	buf <- []int16{2}
+	buf <- []int16{3}
+	close(buf)
+	//everything queued is written before the device gets closed
+	d.Drain()
+	d.Close()
+	a := assert.New(suite.T())
+	a.Equal(3, d.FramesWrote())
+	r.AssertExpectations(suite.T())
+}
 
+func (suite *DeviceTestSuite) TestAsyncAbort() {
+	r := &RawDeviceMock{}
+	r.On("Drop").Return(nil).Once()
+	r.On("Close").Return().Once()
+	d := NewPlaybackDevice(r, 2)
+	buf := make(chan []int16, 3)
+	d.Abort()
+	d.WriteAsync(buf)
+	buf <- []int16{1}
+	buf <- []int16{2}
+	//closing while the buffer is still open must not block
+	d.Close()
+	r.AssertNotCalled(suite.T(), "Write", mock.Anything)
+	r.AssertExpectations(suite.T())
 }
 
-func (suite *DeviceTestSuite) TestPlaybackInterrupt() {
+func (suite *DeviceTestSuite) TestAbortWhileWriting() {
+	r := &RawDeviceMock{}
+	writing := make(chan bool)
+	proceed := make(chan bool)
+	r.On("Write", mock.AnythingOfType("[]int16")).Run(func(args mock.Arguments) {
+		writing <- true
+		<-proceed
+	}).Return(1, nil).Once()
+	r.On("Drop").Return(nil).Once()
+	r.On("Close").Return().Once()
+	d := NewPlaybackDevice(r, 2)
+	buf := make(chan []int16, 1)
+	d.WriteAsync(buf)
+	buf <- []int16{1}
+	<-writing
+	//the send loop drops the device itself once the write in progress returns
+	d.Abort()
+	r.AssertNotCalled(suite.T(), "Drop")
+	proceed <- true
+	time.Sleep(10 * time.Millisecond)
+	r.AssertCalled(suite.T(), "Drop")
+	d.Close()
+	r.AssertExpectations(suite.T())
+}
 
+func (suite *DeviceTestSuite) TestPlaybackInterrupt() {
+	r := &RawDeviceMock{}
+	d := NewPlaybackDevice(r, 2)
+	r.On("Write", mock.AnythingOfType("[]int16")).Run(func(args mock.Arguments) {
+		d.Abort()
+	}).Return(1, nil).Once()
+	r.On("Drop").Return(nil).Once()
+	err := d.WriteSync(bytes.NewReader([]byte{0x01, 0x00, 0x02, 0x00, 0x03, 0x00}))
+	a := assert.New(suite.T())
+	a.NoError(err)
+	a.Equal(1, d.FramesWrote())
+	//aborting twice drops the buffer only once
+	d.Abort()
+	r.AssertExpectations(suite.T())
 }
 
 func TestDeviceTestSuite(t *testing.T) {
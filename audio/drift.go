@@ -0,0 +1,86 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	defaultMaxDrift = 1000 //ppm
+	//driftLevelTau is the time constant smoothing the buffer fill level, in seconds
+	driftLevelTau = 2.0
+	//driftInterval is the period of drift estimates, in seconds
+	driftInterval = 1.0
+	//driftGain is the smoothing factor of drift estimates
+	driftGain = 0.2
+	//driftSettle is the time in which the fill level is brought back to the target, in seconds
+	driftSettle = 30.0
+)
+
+/*driftEstimator measures the difference between the sender and the device clocks from the fill level
+of the jitter buffer and computes the resampling ratio that keeps the level at the target.
+The fill level is smoothed to filter out network jitter; its slope, corrected by the ratio applied
+during the measurement, is the drift of the sender in frames per second.
+*/
+type driftEstimator struct {
+	rate float64
+	//max is the largest correction applied (as a fraction of the rate)
+	max       float64
+	level     float64
+	last      time.Time
+	mark      time.Time
+	markLevel float64
+	//drift is the surplus of frames sent per second with no correction applied
+	drift float64
+	ready bool
+	ratio float64
+}
+
+//newDriftEstimator creates an estimator for a device running at 'rate' correcting at most 'maxPPM' parts per million
+func newDriftEstimator(rate int, maxPPM int) *driftEstimator {
+	if maxPPM <= 0 {
+		maxPPM = defaultMaxDrift
+	}
+	return &driftEstimator{rate: float64(rate), max: float64(maxPPM) / 1e6, ratio: 1}
+}
+
+//update feeds the fill level 'depth' observed at 'now' and returns the resampling ratio to apply
+func (d *driftEstimator) update(now time.Time, depth int, target int) float64 {
+	if d.last.IsZero() {
+		d.level = float64(depth)
+		d.last, d.mark = now, now
+		d.markLevel = d.level
+		return d.ratio
+	}
+	a := now.Sub(d.last).Seconds() / driftLevelTau
+	if a > 1 {
+		a = 1
+	}
+	d.level += (float64(depth) - d.level) * a
+	d.last = now
+	span := now.Sub(d.mark).Seconds()
+	if span < driftInterval {
+		return d.ratio
+	}
+	observed := (d.level-d.markLevel)/span - d.rate*(d.ratio-1)
+	if d.ready {
+		d.drift += (observed - d.drift) * driftGain
+	} else {
+		d.drift = observed
+		d.ready = true
+	}
+	d.mark, d.markLevel = now, d.level
+	correction := d.drift/d.rate + (d.level-float64(target))/(d.rate*driftSettle)
+	d.ratio = 1 - math.Max(-d.max, math.Min(d.max, correction))
+	return d.ratio
+}
+
+//reset restarts level tracking after a discontinuity (e.g. audio dropped or concealed); the drift estimate is kept
+func (d *driftEstimator) reset() {
+	d.last = time.Time{}
+}
+
+//ppm returns the estimated drift and the applied correction in parts per million
+func (d *driftEstimator) ppm() (drift float64, correction float64) {
+	return d.drift / d.rate * 1e6, (d.ratio - 1) * 1e6
+}
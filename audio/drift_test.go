@@ -0,0 +1,84 @@
+package audio
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type DriftTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DriftTestSuite) SetupSuite() {
+	log.SetLevel(log.ErrorLevel)
+}
+
+func (suite *DriftTestSuite) TearDownSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+//simulate plays 'minutes' of a sender whose clock is 'ppm' fast through a jitter buffer read by a 1 kHz device
+//and returns the fill levels in ms at the end of every minute
+func simulate(j *jitterBuffer, ppm float64, minutes int) []int {
+	now := time.Now()
+	j.now = func() time.Time { return now }
+	var sent float64
+	var levels []int
+	j.push(make([]int16, j.target))
+	for tick := 1; tick <= minutes*6000; tick++ {
+		//the sender produces 10 ms of audio per tick which the resampler stretches by the ratio
+		sent += 10 * (1 + ppm/1e6) * j.ratio()
+		n := int(sent)
+		sent -= float64(n)
+		j.push(make([]int16, n))
+		j.read(10)
+		now = now.Add(10 * time.Millisecond)
+		if tick%6000 == 0 {
+			levels = append(levels, j.stats().Depth)
+		}
+	}
+	return levels
+}
+
+func (suite *DriftTestSuite) TestFastSender() {
+	a := assert.New(suite.T())
+	j := newJitterBuffer(1000, 1, 100, 1000, 0)
+	levels := simulate(j, 500, 20)
+	st := j.stats()
+	//without compensation the buffer would grow by 30 ms every minute
+	for _, l := range levels[5:] {
+		a.InDelta(100, l, 10)
+	}
+	a.InDelta(500, st.Drift, 50)
+	a.InDelta(-500, st.Correction, 50)
+	a.Equal(0, st.Overruns)
+	a.Equal(0, st.Underruns)
+}
+
+func (suite *DriftTestSuite) TestSlowSender() {
+	a := assert.New(suite.T())
+	j := newJitterBuffer(1000, 1, 100, 1000, 0)
+	levels := simulate(j, -300, 20)
+	for _, l := range levels[5:] {
+		a.InDelta(100, l, 10)
+	}
+	a.InDelta(-300, j.stats().Drift, 50)
+	a.Equal(0, j.stats().Underruns)
+}
+
+func (suite *DriftTestSuite) TestLimit() {
+	a := assert.New(suite.T())
+	j := newJitterBuffer(1000, 1, 100, 1000, 100)
+	simulate(j, 500, 5)
+	//the correction never exceeds the configured maximum
+	a.InDelta(-100, j.stats().Correction, 1e-6)
+	a.True(j.stats().Depth > 150)
+}
+
+func TestDriftTestSuite(t *testing.T) {
+	suite.Run(t, new(DriftTestSuite))
+}
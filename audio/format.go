@@ -0,0 +1,186 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+//Format is a PCM sample encoding named after its ALSA counterpart; an empty format means S16_LE
+type Format string
+
+//Supported sample formats
+const (
+	FormatS8      Format = "S8"
+	FormatU8      Format = "U8"
+	FormatS16LE   Format = "S16_LE"
+	FormatS24LE   Format = "S24_LE"  //24 bits in the lower bytes of a 4-byte little endian container
+	FormatS243LE  Format = "S24_3LE" //packed 3-byte little endian
+	FormatS32LE   Format = "S32_LE"
+	FormatFloatLE Format = "FLOAT_LE" //32-bit IEEE float in the -1.0..1.0 range
+)
+
+//formatSizes maps supported formats to their sample size in bytes
+var formatSizes = map[Format]int{
+	"":            2,
+	FormatS8:      1,
+	FormatU8:      1,
+	FormatS16LE:   2,
+	FormatS24LE:   4,
+	FormatS243LE:  3,
+	FormatS32LE:   4,
+	FormatFloatLE: 4,
+}
+
+//Valid tells if the format is supported
+func (f Format) Valid() bool {
+	_, ok := formatSizes[f]
+	return ok
+}
+
+//SampleSize returns the size of a single sample in bytes or 0 for unsupported formats
+func (f Format) SampleSize() int {
+	return formatSizes[f]
+}
+
+func (f Format) String() string {
+	if f == "" {
+		return string(FormatS16LE)
+	}
+	return string(f)
+}
+
+//ditherer generates triangular (TPDF) dither of +/-1 LSB used when reducing bit depth
+type ditherer struct {
+	state uint32
+}
+
+//next returns a uniformly distributed value in [0, 1) (xorshift32)
+func (d *ditherer) next() float64 {
+	d.state ^= d.state << 13
+	d.state ^= d.state >> 17
+	d.state ^= d.state << 5
+	return float64(d.state) / (1 << 32)
+}
+
+func (d *ditherer) tpdf() float64 {
+	return d.next() - d.next()
+}
+
+/*sampleConverter converts a byte stream in a given format to S16 samples. Formats with more than 16 bits
+are dithered before being rounded. Incomplete samples are kept until the rest of their bytes arrive.
+*/
+type sampleConverter struct {
+	format Format
+	size   int
+	dither ditherer
+	carry  []byte
+}
+
+func newSampleConverter(f Format) (*sampleConverter, error) {
+	if !f.Valid() {
+		return nil, fmt.Errorf("unsupported sample format %s", f)
+	}
+	return &sampleConverter{format: f, size: f.SampleSize(), dither: ditherer{0x9E3779B9}}, nil
+}
+
+//convert returns the samples of all complete samples in 'in' (including carried over bytes)
+func (c *sampleConverter) convert(in []byte) []int16 {
+	if len(c.carry) > 0 {
+		in = append(c.carry, in...)
+		c.carry = nil
+	}
+	if rest := len(in) % c.size; rest > 0 {
+		c.carry = append([]byte(nil), in[len(in)-rest:]...)
+		in = in[:len(in)-rest]
+	}
+	out := make([]int16, len(in)/c.size)
+	switch c.format {
+	case "", FormatS16LE:
+		convertBuffers(in, out)
+	case FormatS8:
+		for i := range out {
+			out[i] = int16(int8(in[i])) << 8
+		}
+	case FormatU8:
+		for i := range out {
+			out[i] = (int16(in[i]) - 128) << 8
+		}
+	case FormatS24LE:
+		for i := range out {
+			//sign extend the lower 24 bits
+			v := int32(binary.LittleEndian.Uint32(in[i*4:])<<8) >> 8
+			out[i] = c.reduce(float64(v) / (1 << 8))
+		}
+	case FormatS243LE:
+		for i := range out {
+			b := in[i*3:]
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			out[i] = c.reduce(float64(v) / (1 << 8))
+		}
+	case FormatS32LE:
+		for i := range out {
+			v := int32(binary.LittleEndian.Uint32(in[i*4:]))
+			out[i] = c.reduce(float64(v) / (1 << 16))
+		}
+	case FormatFloatLE:
+		for i := range out {
+			v := float64(math.Float32frombits(binary.LittleEndian.Uint32(in[i*4:])))
+			if math.IsNaN(v) {
+				v = 0
+			}
+			out[i] = c.reduce(v * (1 << 15))
+		}
+	}
+	return out
+}
+
+//reduce rounds a sample expressed in S16 units to 16 bits after adding dither
+func (c *sampleConverter) reduce(v float64) int16 {
+	return clampS16(v + c.dither.tpdf())
+}
+
+/*NewFormatDevice wraps a raw device opened in format 'f' so that it accepts the S16 buffers written
+by PlaybackDevice. Samples are widened without loss; S16_LE devices are returned as they are.
+*/
+func NewFormatDevice(raw RawDevice, f Format) (RawDevice, error) {
+	switch f {
+	case "", FormatS16LE:
+		return raw, nil
+	case FormatS24LE, FormatS32LE, FormatFloatLE:
+		return &formatDevice{RawDevice: raw, format: f}, nil
+	}
+	return nil, fmt.Errorf("sample format %s is not supported for playback", f)
+}
+
+type formatDevice struct {
+	RawDevice
+	format Format
+}
+
+func (d *formatDevice) Write(buffer interface{}) (int, error) {
+	in, ok := buffer.([]int16)
+	if !ok {
+		return d.RawDevice.Write(buffer)
+	}
+	switch d.format {
+	case FormatFloatLE:
+		out := make([]float32, len(in))
+		for i, s := range in {
+			out[i] = float32(s) / (1 << 15)
+		}
+		return d.RawDevice.Write(out)
+	case FormatS24LE:
+		out := make([]int32, len(in))
+		for i, s := range in {
+			out[i] = int32(s) << 8
+		}
+		return d.RawDevice.Write(out)
+	default:
+		out := make([]int32, len(in))
+		for i, s := range in {
+			out[i] = int32(s) << 16
+		}
+		return d.RawDevice.Write(out)
+	}
+}
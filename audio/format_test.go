@@ -0,0 +1,121 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type FormatTestSuite struct {
+	suite.Suite
+}
+
+func (suite *FormatTestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func (suite *FormatTestSuite) TestFormats() {
+	a := assert.New(suite.T())
+	a.True(Format("").Valid())
+	a.True(FormatFloatLE.Valid())
+	a.False(Format("S20_3LE").Valid())
+	a.Equal(2, Format("").SampleSize())
+	a.Equal(3, FormatS243LE.SampleSize())
+	a.Equal("S16_LE", Format("").String())
+	_, err := newSampleConverter("MU_LAW")
+	a.Error(err)
+}
+
+func (suite *FormatTestSuite) TestWidening() {
+	a := assert.New(suite.T())
+	c, _ := newSampleConverter(FormatU8)
+	a.Equal([]int16{-32768, 0, 32512}, c.convert([]byte{0, 128, 255}))
+	c, _ = newSampleConverter(FormatS8)
+	a.Equal([]int16{-32768, 0, 256}, c.convert([]byte{0x80, 0, 1}))
+	//incomplete samples are carried over to the next buffer
+	c, _ = newSampleConverter(FormatS16LE)
+	a.Equal([]int16{0x1234}, c.convert([]byte{0x34, 0x12, 0x78}))
+	a.Equal([]int16{0x5678}, c.convert([]byte{0x56}))
+}
+
+func (suite *FormatTestSuite) TestReduction() {
+	a := assert.New(suite.T())
+	c, _ := newSampleConverter(FormatS243LE)
+	out := c.convert([]byte{0x00, 0x34, 0x12, 0x00, 0x00, 0x80, 0x00})
+	a.Len(out, 2)
+	a.InDelta(0x1234, out[0], 1)
+	a.InDelta(-32768, out[1], 1)
+	out = c.convert([]byte{0x00, 0x10})
+	a.Len(out, 1)
+	a.InDelta(0x1000, out[0], 1)
+
+	c, _ = newSampleConverter(FormatS24LE)
+	out = c.convert([]byte{0x00, 0xCC, 0xED, 0xFF})
+	a.InDelta(-0x1234, out[0], 1)
+
+	c, _ = newSampleConverter(FormatS32LE)
+	out = c.convert([]byte{0x00, 0x00, 0x34, 0x12, 0xFF, 0xFF, 0xFF, 0x7F})
+	a.InDelta(0x1234, out[0], 1)
+	a.Equal(int16(32767), out[1])
+
+	c, _ = newSampleConverter(FormatFloatLE)
+	in := make([]byte, 16)
+	binary.LittleEndian.PutUint32(in, math.Float32bits(0.5))
+	binary.LittleEndian.PutUint32(in[4:], math.Float32bits(-1))
+	binary.LittleEndian.PutUint32(in[8:], math.Float32bits(2))
+	binary.LittleEndian.PutUint32(in[12:], math.Float32bits(float32(math.NaN())))
+	out = c.convert(in)
+	a.InDelta(16384, out[0], 1)
+	a.InDelta(-32768, out[1], 1)
+	a.Equal(int16(32767), out[2])
+	a.InDelta(0, out[3], 1)
+}
+
+func (suite *FormatTestSuite) TestDither() {
+	a := assert.New(suite.T())
+	//a level between two 16-bit steps is preserved on average instead of being rounded away
+	c, _ := newSampleConverter(FormatS243LE)
+	in := make([]byte, 0, 3*10000)
+	for i := 0; i < 10000; i++ {
+		in = append(in, 0x40, 0x64, 0x00) //100.25 LSB
+	}
+	var sum float64
+	for _, s := range c.convert(in) {
+		a.True(s >= 99 && s <= 101, "dither too large: %d", s)
+		sum += float64(s)
+	}
+	a.InDelta(100.25, sum/10000, 0.05)
+}
+
+func (suite *FormatTestSuite) TestFormatDevice() {
+	a := assert.New(suite.T())
+	r := &RawDeviceMock{}
+	d, err := NewFormatDevice(r, FormatS16LE)
+	a.NoError(err)
+	a.Equal(r, d)
+	_, err = NewFormatDevice(r, FormatS243LE)
+	a.Error(err)
+
+	r.On("Write", []int32{0x12340000, -0x80000000}).Return(2, nil).Once()
+	r.On("Write", []int32{0x123400}).Return(1, nil).Once()
+	r.On("Write", []float32{0.5, -1}).Return(2, nil).Once()
+	r.On("Close").Return().Once()
+	d, _ = NewFormatDevice(r, FormatS32LE)
+	n, err := d.Write([]int16{0x1234, -32768})
+	a.NoError(err)
+	a.Equal(2, n)
+	d, _ = NewFormatDevice(r, FormatS24LE)
+	d.Write([]int16{0x1234})
+	d, _ = NewFormatDevice(r, FormatFloatLE)
+	d.Write([]int16{16384, -32768})
+	d.Close()
+	r.AssertExpectations(suite.T())
+}
+
+func TestFormatTestSuite(t *testing.T) {
+	suite.Run(t, new(FormatTestSuite))
+}
@@ -0,0 +1,206 @@
+package audio
+
+import "math"
+
+const (
+	//MinGainDB is the attenuation treated as silence
+	MinGainDB = -60.0
+	//MaxGainDB is the highest boost accepted for a stream
+	MaxGainDB = 12.0
+	//volumeRangeDB is the range covered by the 1-100 volume scale (100 is 0 dB, 1 is close to MinGainDB)
+	volumeRangeDB = -MinGainDB
+	//clipThreshold is the level (-1 dBFS) above which samples are softly saturated instead of hard clipped
+	clipThreshold = 0.891
+	defaultRampMs = 50
+)
+
+//VolumeToDB maps the 0-100 volume scale to decibels; 0 is silence and 100 is unity gain
+func VolumeToDB(volume int) float64 {
+	if volume <= 0 {
+		return math.Inf(-1)
+	}
+	if volume > 100 {
+		volume = 100
+	}
+	return float64(volume-100) * volumeRangeDB / 100
+}
+
+//DBToLinear converts decibels to a linear amplitude factor; anything below MinGainDB is silence
+func DBToLinear(db float64) float64 {
+	if db <= MinGainDB {
+		return 0
+	}
+	if db > MaxGainDB {
+		db = MaxGainDB
+	}
+	return math.Pow(10, db/20)
+}
+
+//GainDB returns the gain requested in the stream context. A non-zero Gain (in dB) takes precedence;
+//otherwise Volume (0-100, 0 mutes) is used when set; a context with neither plays at unity gain.
+func (c *StreamContext) GainDB() float64 {
+	if c.Gain != 0 {
+		return c.Gain
+	}
+	if c.Volume != nil {
+		return VolumeToDB(*c.Volume)
+	}
+	return 0
+}
+
+//Gain is a software gain stage for interleaved S16 samples. Gain changes are applied
+//as linear ramps to avoid zipper noise and boosted samples are softly saturated.
+type Gain struct {
+	channels   int
+	rampFrames int
+	//db is the stream gain without the master gain
+	db        float64
+	master    *Master
+	masterVer uint64
+	current   float64
+	target    float64
+	step      float64
+	remaining int
+	//pos is the channel of the next sample within its frame
+	pos int
+	//Clipped counts samples that had to be saturated
+	Clipped int
+}
+
+//NewGain creates a gain stage starting at 'db'; gain changes ramp over 'rampFrames' frames
+func NewGain(db float64, channels int, rampFrames int) *Gain {
+	if channels < 1 {
+		channels = 1
+	}
+	g := &Gain{channels: channels, rampFrames: rampFrames, db: db}
+	g.current = DBToLinear(db)
+	g.target = g.current
+	return g
+}
+
+//rampFrames returns the number of frames in a ramp of 'ms' milliseconds at 'sampleRate'
+func rampFrames(ms int, sampleRate int) int {
+	if ms <= 0 {
+		ms = defaultRampMs
+	}
+	if sampleRate <= 0 {
+		sampleRate = defaultSampleRate
+	}
+	return ms * sampleRate / 1000
+}
+
+//follow adds the master gain to the stream gain; later master changes are ramped like stream gain changes
+func (g *Gain) follow(m *Master) {
+	if m == nil {
+		return
+	}
+	var db float64
+	g.master = m
+	db, g.masterVer = m.state()
+	g.current = DBToLinear(g.db + db)
+	g.target = g.current
+	g.remaining = 0
+}
+
+//SetDB starts a ramp towards the new stream gain
+func (g *Gain) SetDB(db float64) {
+	g.db = db
+	if g.master != nil {
+		var master float64
+		master, g.masterVer = g.master.state()
+		db += master
+	}
+	g.rampTo(db)
+}
+
+func (g *Gain) rampTo(db float64) {
+	g.target = DBToLinear(db)
+	if g.rampFrames <= 0 {
+		g.current = g.target
+		g.remaining = 0
+		return
+	}
+	g.remaining = g.rampFrames
+	g.step = (g.target - g.current) / float64(g.rampFrames)
+}
+
+//DB returns the gain currently applied
+func (g *Gain) DB() float64 {
+	if g.current == 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(g.current)
+}
+
+//Process applies the gain in place; buffers do not need to be frame aligned
+func (g *Gain) Process(buf []int16) {
+	if g.master != nil {
+		if db, ver := g.master.state(); ver != g.masterVer {
+			g.masterVer = ver
+			g.rampTo(g.db + db)
+		}
+	}
+	if g.remaining == 0 && g.current == 1 {
+		g.pos = (g.pos + len(buf)) % g.channels
+		return
+	}
+	for i := range buf {
+		if g.pos == 0 && g.remaining > 0 {
+			g.current += g.step
+			g.remaining--
+			if g.remaining == 0 {
+				g.current = g.target
+			}
+		}
+		buf[i] = g.saturate(float64(buf[i]) * g.current)
+		if g.pos++; g.pos == g.channels {
+			g.pos = 0
+		}
+	}
+}
+
+//saturate converts a scaled sample back to int16 compressing peaks above clipThreshold smoothly towards full scale
+func (g *Gain) saturate(v float64) int16 {
+	x := v / 32768
+	a := math.Abs(x)
+	if a > clipThreshold {
+		g.Clipped++
+		a = clipThreshold + (1-clipThreshold)*math.Tanh((a-clipThreshold)/(1-clipThreshold))
+		if x < 0 {
+			x = -a
+		} else {
+			x = a
+		}
+	}
+	s := math.Floor(x*32768 + .5)
+	if s > 32767 {
+		return 32767
+	}
+	if s < -32768 {
+		return -32768
+	}
+	return int16(s)
+}
+
+//fadeFrames returns the number of frames in a fade of 'ms' milliseconds at 'sampleRate'; fades of 0 ms or less are disabled
+func fadeFrames(ms int, sampleRate int) int {
+	if ms <= 0 {
+		return 0
+	}
+	return ms * sampleRate / 1000
+}
+
+/*fadeTail fades out the end of a source in place. 'samples' starts 'remaining' frames before the end of the source
+and the fade covers its last 'frames' frames, reaching silence on the very last one.
+*/
+func fadeTail(samples []int16, channels int, remaining int, frames int) {
+	if frames <= 0 {
+		return
+	}
+	for i := range samples {
+		left := remaining - i/channels - 1
+		if left < frames {
+			samples[i] = int16(float64(samples[i]) * float64(left) / float64(frames))
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type GainTestSuite struct {
+	suite.Suite
+}
+
+func (suite *GainTestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func (suite *GainTestSuite) TestVolumeScale() {
+	a := assert.New(suite.T())
+	a.Equal(0.0, VolumeToDB(100))
+	a.Equal(0.0, VolumeToDB(150))
+	a.Equal(-30.0, VolumeToDB(50))
+	a.True(math.IsInf(VolumeToDB(0), -1))
+	a.Equal(0.0, DBToLinear(VolumeToDB(0)))
+	a.InDelta(0.5, DBToLinear(-6.0206), 1e-4)
+	a.Equal(DBToLinear(MaxGainDB), DBToLinear(40))
+
+	a.Equal(0.0, (&StreamContext{}).GainDB())
+	a.Equal(-30.0, (&StreamContext{Volume: volume(50)}).GainDB())
+	a.Equal(-6.0, (&StreamContext{Volume: volume(50), Gain: -6}).GainDB())
+	//volume 0 mutes the stream
+	a.True(math.IsInf((&StreamContext{Volume: volume(0)}).GainDB(), -1))
+}
+
+//volume returns a pointer to 'v' for StreamContext.Volume
+func volume(v int) *int {
+	return &v
+}
+
+func (suite *GainTestSuite) TestProcess() {
+	a := assert.New(suite.T())
+	g := NewGain(0, 2, 0)
+	buf := []int16{1000, -1000, 32767, -32768}
+	g.Process(buf)
+	a.Equal([]int16{1000, -1000, 32767, -32768}, buf)
+
+	g = NewGain(-6.0206, 2, 0)
+	buf = []int16{1000, -1000, 20000, -20000}
+	g.Process(buf)
+	a.Equal([]int16{500, -500, 10000, -10000}, buf)
+
+	g = NewGain(VolumeToDB(0), 1, 0)
+	buf = []int16{1000, -1000}
+	g.Process(buf)
+	a.Equal([]int16{0, 0}, buf)
+}
+
+func (suite *GainTestSuite) TestRamp() {
+	a := assert.New(suite.T())
+	g := NewGain(0, 2, 100)
+	g.SetDB(-6.0206)
+	buf := make([]int16, 400)
+	for i := range buf {
+		buf[i] = 10000
+	}
+	//feed the ramp in chunks that split frames to make sure channels stay in sync
+	g.Process(buf[:3])
+	g.Process(buf[3:151])
+	g.Process(buf[151:])
+	for i := 0; i < len(buf); i += 2 {
+		a.Equal(buf[i], buf[i+1], "channels diverged at frame %d", i/2)
+		if i > 0 {
+			a.True(buf[i] <= buf[i-2], "ramp is not monotonic at frame %d", i/2)
+			a.True(buf[i-2]-buf[i] <= 60, "ramp step too large at frame %d", i/2)
+		}
+	}
+	a.Equal(int16(5000), buf[len(buf)-1])
+	a.InDelta(-6.0206, g.DB(), 1e-3)
+}
+
+func (suite *GainTestSuite) TestSaturation() {
+	a := assert.New(suite.T())
+	g := NewGain(MaxGainDB, 1, 0)
+	buf := []int16{100, 20000, -20000, 32767, -32768}
+	g.Process(buf)
+	a.Equal(int16(398), buf[0])
+	a.Equal(4, g.Clipped)
+	for _, v := range buf[1:] {
+		a.True(v > 29000 || v < -29000, "peak not preserved: %d", v)
+	}
+	//louder input never produces a quieter output
+	a.True(buf[3] >= buf[1])
+	a.True(buf[4] <= buf[2])
+}
+
+func (suite *GainTestSuite) TestFadeTail() {
+	a := assert.New(suite.T())
+	buf := []int16{1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000}
+	//the buffer holds the last 4 stereo frames; the fade covers the last 2
+	fadeTail(buf, 2, 4, 2)
+	a.Equal([]int16{1000, 1000, 1000, 1000, 500, 500, 0, 0}, buf)
+
+	//the input reads ahead to fade out the end of the source across reads
+	in := make([]byte, 0, 12)
+	for i := 0; i < 6; i++ {
+		in = append(in, 0xE8, 0x03) //1000
+	}
+	r := newReaderInput(bytes.NewReader(in), 1, 4)
+	s, ok := r.read(4)
+	a.True(ok)
+	a.Equal([]int16{1000, 1000, 750, 500}, s)
+	s, ok = r.read(4)
+	a.False(ok)
+	a.Equal([]int16{250, 0}, s)
+	a.Equal(0, fadeFrames(-1, 1000))
+	a.Equal(10, fadeFrames(10, 1000))
+}
+
+func (suite *GainTestSuite) TestPipelineReader() {
+	a := assert.New(suite.T())
+	in := make([]byte, 0, 40)
+	for i := 0; i < 10; i++ {
+		in = append(in, 0x10, 0x27, 0xF0, 0xD8) //10000, -10000
+	}
+	//odd sized reads force partial frames to be carried over
+	r := newPipelineReader(&chunkReader{bytes.NewReader(in), 7}, newPipeline(22050, 22050, 2, NewGain(-6.0206, 2, 0), nil))
+	out, err := ioutil.ReadAll(r)
+	a.NoError(err)
+	a.Equal(len(in), len(out))
+	for i := 0; i < len(out); i += 4 {
+		a.Equal(int16(5000), int16(binary.LittleEndian.Uint16(out[i:])))
+		a.Equal(int16(-5000), int16(binary.LittleEndian.Uint16(out[i+2:])))
+	}
+}
+
+//chunkReader returns at most 'n' bytes per read
+type chunkReader struct {
+	r *bytes.Reader
+	n int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > c.n {
+		p = p[:c.n]
+	}
+	return c.r.Read(p)
+}
+
+func TestGainTestSuite(t *testing.T) {
+	suite.Run(t, new(GainTestSuite))
+}
@@ -0,0 +1,90 @@
+package audio
+
+import "math"
+
+//imdct computes the inverse MDCT used by Vorbis:
+//  y[i] = sum(X[k] * cos(2*pi/n * (i + 1/2 + n/4) * (k + 1/2))), k = 0..n/2-1
+//It is evaluated through a DCT-IV of size n/2 which in turn uses an n/4 point complex FFT.
+type imdct struct {
+	n       int
+	pre     []complex128
+	post    []complex128
+	twiddle []complex128
+	rev     []int
+	buf     []complex128
+	dct     []float64
+}
+
+func newIMDCT(n int) *imdct {
+	m := n / 2
+	h := n / 4
+	t := &imdct{
+		n:       n,
+		pre:     make([]complex128, h),
+		post:    make([]complex128, h),
+		twiddle: make([]complex128, h/2),
+		rev:     make([]int, h),
+		buf:     make([]complex128, h),
+		dct:     make([]float64, m),
+	}
+	for i := 0; i < h; i++ {
+		a := -math.Pi * float64(i) / float64(m)
+		t.pre[i] = complex(math.Cos(a), math.Sin(a))
+		a = -math.Pi * (float64(i) + .25) / float64(m)
+		t.post[i] = complex(math.Cos(a), math.Sin(a))
+	}
+	for i := range t.twiddle {
+		a := -2 * math.Pi * float64(i) / float64(h)
+		t.twiddle[i] = complex(math.Cos(a), math.Sin(a))
+	}
+	bits := ilog(h - 1)
+	for i := range t.rev {
+		r := 0
+		for b := uint(0); b < bits; b++ {
+			if i&(1<<b) != 0 {
+				r |= 1 << (bits - 1 - b)
+			}
+		}
+		t.rev[i] = r
+	}
+	return t
+}
+
+//inverse transforms n/2 spectral coefficients from 'in' into n samples written to 'out'
+func (t *imdct) inverse(in []float32, out []float32) {
+	m := t.n / 2
+	h := t.n / 4
+
+	//DCT-IV of size m via an h point complex FFT
+	for i := 0; i < h; i++ {
+		t.buf[t.rev[i]] = complex(float64(in[2*i]), float64(in[m-1-2*i])) * t.pre[i]
+	}
+	for size := 2; size <= h; size <<= 1 {
+		step := h / size
+		for start := 0; start < h; start += size {
+			for k := 0; k < size/2; k++ {
+				w := t.twiddle[k*step]
+				a := t.buf[start+k]
+				b := t.buf[start+k+size/2] * w
+				t.buf[start+k] = a + b
+				t.buf[start+k+size/2] = a - b
+			}
+		}
+	}
+	for i := 0; i < h; i++ {
+		v := t.buf[i] * t.post[i]
+		t.dct[2*i] = real(v)
+		t.dct[m-1-2*i] = -imag(v)
+	}
+
+	//unfold the DCT-IV output using its symmetries
+	for i := 0; i < m/2; i++ {
+		out[i] = float32(t.dct[i+m/2])
+	}
+	for i := m / 2; i < 3*m/2; i++ {
+		out[i] = float32(-t.dct[3*m/2-1-i])
+	}
+	for i := 3 * m / 2; i < 2*m; i++ {
+		out[i] = float32(-t.dct[i-3*m/2])
+	}
+}
@@ -0,0 +1,337 @@
+package audio
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJitterTarget = 100 //ms
+	defaultJitterMax    = 500 //ms
+	//jitterFactor scales the measured arrival jitter into the playout target
+	jitterFactor = 4
+	//jitterGain is the smoothing factor of the jitter estimate (as in RFC 3550)
+	jitterGain = 1.0 / 16
+	//concealPeriods is the number of periods over which a repeated period fades out to silence
+	concealPeriods = 3
+)
+
+//JitterStats is a snapshot of the jitter buffer state; durations are in milliseconds
+type JitterStats struct {
+	Depth      int     `json:"depth"`
+	Target     int     `json:"target"`
+	Max        int     `json:"max"`
+	Jitter     float64 `json:"jitter"`
+	Buffering  bool    `json:"buffering"` //true while waiting for the buffer to reach the target
+	Underruns  int     `json:"underruns"`
+	Overruns   int     `json:"overruns"`
+	Dropped    int     `json:"droppedFrames"`   //frames discarded on overruns or beyond the limit held while suspended
+	Concealed  int     `json:"concealedFrames"` //frames of concealment and silence played during underruns
+	Drift      float64 `json:"drift"`           //estimated sender clock offset in ppm
+	Correction float64 `json:"correction"`      //resampling ratio correction in ppm
+}
+
+/*jitterBuffer decouples audio arriving from the network from the pace of the device.
+Playout starts (and restarts after an underrun) once the buffer holds the target amount of audio.
+The target follows the measured arrival jitter between the configured target and the maximum depth.
+When the depth exceeds the maximum the oldest audio is dropped down to the target.
+While the buffer builds up again after an underrun the device is kept running with the last period
+repeated and faded out, followed by silence.
+Audio arriving before playout may start (e.g. during an intro) is prebuffered beyond the maximum depth (see prebuffer).
+A suspended stream is held (see hold) with up to the suspend limit of the latest audio which is played out on resume.
+The fill level observed by the device drives the drift estimator; the resulting ratio (see ratio)
+is meant for the resampler of the stream so that the level stays at the target.
+*/
+type jitterBuffer struct {
+	mutex    sync.Mutex
+	rate     int
+	channels int
+	//minTarget, target and max are in frames
+	minTarget int
+	target    int
+	max       int
+	//baseMax is the configured maximum depth; max is raised above it to play out audio prebuffered
+	//or held during a suspension
+	baseMax int
+	//held limits the depth while the stream is suspended (0 when it is not)
+	held int
+	//fadeOut is the number of frames faded out at the end of the stream
+	fadeOut int
+	samples []int16
+	playing bool
+	started bool
+	closed  bool
+	stopped bool
+	//last is the last period played; fade counts the concealment periods played since
+	last []int16
+	fade int
+
+	now          func() time.Time
+	lastArrival  time.Time
+	lastDuration time.Duration
+	jitter       float64 //seconds
+	drift        *driftEstimator
+	driftRatio   float64
+
+	underruns int
+	overruns  int
+	dropped   int
+	concealed int
+}
+
+//newJitterBuffer creates a buffer for audio at 'rate' with 'channels' channels; 'target' and 'max' are in ms,
+//'maxDrift' is the largest clock drift compensated in ppm
+func newJitterBuffer(rate int, channels int, target int, max int, maxDrift int) *jitterBuffer {
+	if rate <= 0 {
+		rate = defaultSampleRate
+	}
+	if channels < 1 {
+		channels = 1
+	}
+	if target <= 0 {
+		target = defaultJitterTarget
+	}
+	if max <= 0 {
+		max = defaultJitterMax
+	}
+	if max < target {
+		max = target
+	}
+	j := &jitterBuffer{
+		rate:       rate,
+		channels:   channels,
+		minTarget:  msToFrames(target, rate),
+		max:        msToFrames(max, rate),
+		now:        time.Now,
+		drift:      newDriftEstimator(rate, maxDrift),
+		driftRatio: 1,
+	}
+	j.target = j.minTarget
+	j.baseMax = j.max
+	return j
+}
+
+func msToFrames(ms int, rate int) int {
+	f := ms * rate / 1000
+	if f < 1 {
+		f = 1
+	}
+	return f
+}
+
+func (j *jitterBuffer) framesToMs(frames int) int {
+	return frames * 1000 / j.rate
+}
+
+//push appends whole frames received from the network
+func (j *jitterBuffer) push(samples []int16) {
+	if len(samples) == 0 {
+		return
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.closed || j.stopped {
+		return
+	}
+	frames := len(samples) / j.channels
+	j.measure(time.Duration(frames) * time.Second / time.Duration(j.rate))
+	j.samples = append(j.samples, samples...)
+	depth := len(j.samples) / j.channels
+	if j.held > 0 {
+		//a suspended stream keeps the latest audio up to the limit
+		if depth > j.held {
+			drop := depth - j.held
+			j.samples = append(j.samples[:0], j.samples[drop*j.channels:]...)
+			j.dropped += drop
+		}
+		return
+	}
+	if depth > j.max {
+		drop := depth - j.target
+		j.samples = append(j.samples[:0], j.samples[drop*j.channels:]...)
+		j.overruns++
+		j.dropped += drop
+		j.drift.reset()
+	}
+	if !j.playing && len(j.samples)/j.channels >= j.target {
+		j.playing = true
+	}
+}
+
+//measure updates the interarrival jitter estimate and the playout target; it must be called with the mutex held
+func (j *jitterBuffer) measure(duration time.Duration) {
+	now := j.now()
+	if !j.lastArrival.IsZero() {
+		//difference between the time that passed and the audio that arrived in the meantime
+		d := now.Sub(j.lastArrival) - j.lastDuration
+		j.jitter += (math.Abs(d.Seconds()) - j.jitter) * jitterGain
+		target := int(jitterFactor * j.jitter * float64(j.rate))
+		if target < j.minTarget {
+			target = j.minTarget
+		}
+		if target > j.max {
+			target = j.max
+		}
+		j.target = target
+	}
+	j.lastArrival = now
+	j.lastDuration = duration
+}
+
+/*read returns up to 'max' samples (whole frames) for the bus without blocking. Before playout starts
+it returns nil (silence) until the buffer reaches the target. During playout an empty buffer underruns and
+concealment is returned until the target is reached again. After close the remaining audio is returned
+regardless of the target. 'ok' is false at the end of the stream or once the buffer is aborted.
+*/
+func (j *jitterBuffer) read(max int) (samples []int16, ok bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	max -= max % j.channels
+	if max < j.channels {
+		max = j.channels
+	}
+	if j.stopped {
+		return nil, false
+	}
+	if len(j.samples) > 0 && (j.playing || j.closed) {
+		samples = j.take(max)
+		if j.playing {
+			j.driftRatio = j.drift.update(j.now(), len(j.samples)/j.channels, j.target)
+		}
+		return samples, true
+	}
+	if j.closed {
+		return nil, false
+	}
+	if !j.started {
+		return nil, true
+	}
+	if j.playing {
+		j.underruns++
+		j.playing = false
+		j.drift.reset()
+	}
+	return j.conceal(max), true
+}
+
+//take removes up to 'max' samples from the buffer; it must be called with the mutex held
+func (j *jitterBuffer) take(max int) []int16 {
+	n := max
+	if n > len(j.samples) {
+		n = len(j.samples)
+	}
+	samples := make([]int16, n)
+	copy(samples, j.samples)
+	if j.closed {
+		fadeTail(samples, j.channels, len(j.samples)/j.channels, j.fadeOut)
+	}
+	j.samples = append(j.samples[:0], j.samples[n:]...)
+	if j.max > j.baseMax && len(j.samples)/j.channels <= j.baseMax {
+		j.max = j.baseMax
+	}
+	j.last = append(j.last[:0], samples...)
+	if j.fade > 0 {
+		//fade in after concealment to avoid a click
+		frames := n / j.channels
+		for i := range samples {
+			samples[i] = int16(float64(samples[i]) * float64(i/j.channels) / float64(frames))
+		}
+	}
+	j.fade = 0
+	j.started = true
+	return samples
+}
+
+//conceal returns the last period faded out a step further on every call and silence once the fade is over;
+//it must be called with the mutex held
+func (j *jitterBuffer) conceal(max int) []int16 {
+	if len(j.last) == 0 || j.fade >= concealPeriods {
+		j.fade++
+		j.concealed += max / j.channels
+		return make([]int16, max)
+	}
+	frames := len(j.last) / j.channels
+	out := make([]int16, len(j.last))
+	for i, s := range j.last {
+		g := 1 - (float64(j.fade)+float64(i/j.channels)/float64(frames))/concealPeriods
+		out[i] = int16(float64(s) * g)
+	}
+	j.fade++
+	j.concealed += frames
+	return out
+}
+
+//prebuffer raises the maximum depth by 'ms' until the audio queued meanwhile has been played out
+func (j *jitterBuffer) prebuffer(ms int) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.max = j.baseMax + msToFrames(ms, j.rate)
+}
+
+//hold makes the buffer keep up to 'ms' of audio (the most recent) while the stream is suspended
+func (j *jitterBuffer) hold(ms int) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.held = msToFrames(ms, j.rate)
+}
+
+//resume ends holding; the audio held is played out before what arrives next
+func (j *jitterBuffer) resume() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.held == 0 {
+		return
+	}
+	j.held = 0
+	if depth := len(j.samples) / j.channels; depth > j.baseMax {
+		j.max = depth + j.baseMax
+	}
+	if !j.playing && len(j.samples)/j.channels >= j.target {
+		j.playing = true
+	}
+	j.lastArrival = time.Time{}
+	j.drift.reset()
+}
+
+//close marks the end of input; what is buffered is still played out
+func (j *jitterBuffer) close() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.closed = true
+}
+
+//abort discards buffered audio and ends the stream
+func (j *jitterBuffer) abort() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.stopped = true
+	j.samples = nil
+}
+
+//ratio returns the resampling ratio compensating the clock drift between the sender and the device
+func (j *jitterBuffer) ratio() float64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.driftRatio
+}
+
+//stats returns the current jitter buffer statistics
+func (j *jitterBuffer) stats() JitterStats {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	drift, correction := j.drift.ppm()
+	return JitterStats{
+		Depth:      j.framesToMs(len(j.samples) / j.channels),
+		Target:     j.framesToMs(j.target),
+		Max:        j.framesToMs(j.max),
+		Jitter:     j.jitter * 1000,
+		Buffering:  !j.playing && !j.closed,
+		Underruns:  j.underruns,
+		Overruns:   j.overruns,
+		Dropped:    j.dropped,
+		Concealed:  j.concealed,
+		Drift:      drift,
+		Correction: correction,
+	}
+}
@@ -0,0 +1,205 @@
+package audio
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type JitterTestSuite struct {
+	suite.Suite
+}
+
+func (suite *JitterTestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func (suite *JitterTestSuite) TestPlayout() {
+	a := assert.New(suite.T())
+	//10 ms target at 1 kHz stereo is 10 frames
+	j := newJitterBuffer(1000, 2, 10, 50, 0)
+	j.push(make([]int16, 18))
+	s, ok := j.read(8)
+	a.True(ok)
+	a.Nil(s, "playout started below the target")
+	a.True(j.stats().Buffering)
+	j.push(make([]int16, 2))
+	s, _ = j.read(8)
+	a.Len(s, 8)
+	st := j.stats()
+	a.False(st.Buffering)
+	a.Equal(6, st.Depth)
+	a.Equal(10, st.Target)
+	a.Equal(50, st.Max)
+
+	//reads are frame aligned
+	s, _ = j.read(5)
+	a.Len(s, 4)
+	s, _ = j.read(100)
+	a.Len(s, 8)
+	a.Equal(0, j.stats().Underruns)
+}
+
+func (suite *JitterTestSuite) TestConcealment() {
+	a := assert.New(suite.T())
+	j := newJitterBuffer(1000, 1, 4, 50, 0)
+	j.push([]int16{1000, 1000, 1000, 1000})
+	s, _ := j.read(4)
+	a.Equal([]int16{1000, 1000, 1000, 1000}, s)
+
+	//nothing arrives: the last period is repeated while fading out, then silence follows
+	s, ok := j.read(4)
+	a.True(ok)
+	a.Equal([]int16{1000, 916, 833, 750}, s)
+	s, _ = j.read(4)
+	a.Equal([]int16{666, 583, 500, 416}, s)
+	s, _ = j.read(4)
+	a.Equal([]int16{333, 250, 166, 83}, s)
+	s, _ = j.read(4)
+	a.Equal([]int16{0, 0, 0, 0}, s)
+	st := j.stats()
+	a.Equal(1, st.Underruns)
+	a.Equal(16, st.Concealed)
+	a.True(st.Buffering)
+
+	//below the target concealment goes on; once it is reached audio fades back in
+	j.push([]int16{1000, 1000})
+	s, _ = j.read(4)
+	a.Equal([]int16{0, 0, 0, 0}, s)
+	j.push([]int16{1000, 1000})
+	s, _ = j.read(4)
+	a.Equal([]int16{0, 250, 500, 750}, s)
+	//the next underrun conceals with the audio received last
+	s, _ = j.read(4)
+	a.Equal([]int16{1000, 916, 833, 750}, s)
+	a.Equal(2, j.stats().Underruns)
+}
+
+func (suite *JitterTestSuite) TestOverrun() {
+	a := assert.New(suite.T())
+	j := newJitterBuffer(1000, 1, 10, 50, 0)
+	//a stalled reader with perfectly regular arrivals
+	now := time.Now()
+	j.now = func() time.Time {
+		now = now.Add(10 * time.Millisecond)
+		return now
+	}
+	for i := 0; i < 6; i++ {
+		buf := make([]int16, 10)
+		for k := range buf {
+			buf[k] = int16(i*10 + k)
+		}
+		j.push(buf)
+	}
+	//60 frames exceed the maximum of 50; the oldest are dropped down to the target
+	st := j.stats()
+	a.Equal(1, st.Overruns)
+	a.Equal(50, st.Dropped)
+	a.Equal(10, st.Depth)
+	s, _ := j.read(100)
+	a.Equal(int16(50), s[0])
+}
+
+func (suite *JitterTestSuite) TestAdaptiveTarget() {
+	a := assert.New(suite.T())
+	j := newJitterBuffer(1000, 1, 20, 200, 0)
+	now := time.Now()
+	j.now = func() time.Time { return now }
+	//regular 10 ms packets do not move the target
+	for i := 0; i < 10; i++ {
+		j.push(make([]int16, 10))
+		now = now.Add(10 * time.Millisecond)
+	}
+	a.Equal(20, j.stats().Target)
+	a.InDelta(0, j.stats().Jitter, 1e-9)
+	//packets arriving in bursts raise it up to the maximum
+	for i := 0; i < 100; i++ {
+		j.push(make([]int16, 10))
+		if i%2 == 1 {
+			now = now.Add(100 * time.Millisecond)
+		}
+	}
+	st := j.stats()
+	a.True(st.Jitter > 40, "jitter not measured: %v", st.Jitter)
+	a.InDelta(jitterFactor*st.Jitter, st.Target, 1)
+	//the target never exceeds the maximum depth
+	for i := 0; i < 10; i++ {
+		j.push(make([]int16, 10))
+		now = now.Add(time.Second)
+	}
+	a.Equal(200, j.stats().Target)
+}
+
+func (suite *JitterTestSuite) TestHold() {
+	a := assert.New(suite.T())
+	j := newJitterBuffer(1000, 1, 10, 20, 0)
+	j.push(make([]int16, 10))
+	j.read(10)
+	//a suspended stream keeps the latest audio beyond the maximum depth up to the limit
+	j.hold(50)
+	for i := 0; i < 6; i++ {
+		buf := make([]int16, 10)
+		for k := range buf {
+			buf[k] = int16(i*10 + k)
+		}
+		j.push(buf)
+	}
+	st := j.stats()
+	a.Equal(50, st.Depth)
+	a.Equal(10, st.Dropped)
+	a.Equal(0, st.Overruns)
+	//on resume the held audio is played out before anything is dropped
+	j.resume()
+	j.push(make([]int16, 10))
+	a.Equal(60, j.stats().Depth)
+	s, _ := j.read(10)
+	a.Equal(int16(10), s[0])
+	a.Equal(0, j.stats().Overruns)
+}
+
+func (suite *JitterTestSuite) TestPrebuffer() {
+	a := assert.New(suite.T())
+	j := newJitterBuffer(1000, 1, 10, 20, 0)
+	//audio arriving during an intro is kept beyond the maximum depth
+	j.prebuffer(100)
+	for i := 0; i < 5; i++ {
+		j.push(make([]int16, 10))
+	}
+	a.Equal(50, j.stats().Depth)
+	a.Equal(0, j.stats().Overruns)
+	//the maximum is restored once the backlog has been played out
+	j.read(40)
+	a.Equal(20, j.stats().Max)
+	j.push(make([]int16, 20))
+	a.Equal(1, j.stats().Overruns)
+}
+
+func (suite *JitterTestSuite) TestEnd() {
+	a := assert.New(suite.T())
+	j := newJitterBuffer(1000, 1, 100, 200, 0)
+	j.push([]int16{1, 2, 3, 4, 5})
+	//the end of stream plays out what is left even below the target
+	j.close()
+	s, ok := j.read(4)
+	a.True(ok)
+	a.Equal([]int16{1, 2, 3, 4}, s)
+	s, _ = j.read(4)
+	a.Equal([]int16{5}, s)
+	_, ok = j.read(4)
+	a.False(ok)
+
+	//an aborted buffer ends the stream and discards the rest
+	j = newJitterBuffer(1000, 1, 100, 200, 0)
+	j.push([]int16{1, 2, 3})
+	j.abort()
+	_, ok = j.read(4)
+	a.False(ok)
+	a.Equal(0, j.stats().Depth)
+}
+
+func TestJitterTestSuite(t *testing.T) {
+	suite.Run(t, new(JitterTestSuite))
+}
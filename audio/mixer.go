@@ -0,0 +1,34 @@
+package audio
+
+import "errors"
+
+var (
+	//ErrNoVolumeControl is returned when the mixer element has no playback volume
+	ErrNoVolumeControl = errors.New("mixer element has no playback volume control")
+	//ErrNoSwitchControl is returned when the mixer element has no playback (mute) switch
+	ErrNoSwitchControl = errors.New("mixer element has no playback switch")
+)
+
+//MixerElement describes a simple mixer control exposed by the sound card
+type MixerElement struct {
+	Name      string `json:"name"`
+	Index     int    `json:"index"`
+	HasVolume bool   `json:"hasVolume"`
+	HasSwitch bool   `json:"hasSwitch"`
+}
+
+//Mixer is an interface wrapper over the hardware mixer element configured in AudioConf.Mixer.
+//It is defined for testing convienience (mocking and cgo independence).
+//Volume in percent is linear over the raw range of the control (the same scale amixer uses);
+//dB values are the ones reported by the driver.
+type Mixer interface {
+	Elements() ([]MixerElement, error)
+	Volume() (int, error)
+	SetVolume(percent int) error
+	VolumeDB() (float64, error)
+	SetVolumeDB(db float64) error
+	DBRange() (min float64, max float64, err error)
+	Muted() (bool, error)
+	SetMuted(muted bool) error
+	Close()
+}
@@ -18,6 +18,12 @@ func (m *RawDeviceMock) Write(buffer interface{}) (samples int, err error) {
 	return args.Int(0), args.Error(1)
 }
 
+//Drop is a mocked method
+func (m *RawDeviceMock) Drop() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
 //Close is a mocked method
 func (m *RawDeviceMock) Close() {
 	m.Called()
@@ -34,6 +40,12 @@ func (m *DeviceMock) FramesWrote() int {
 	return args.Int(0)
 }
 
+//Xruns is a mocked method
+func (m *DeviceMock) Xruns() int {
+	args := m.Called()
+	return args.Int(0)
+}
+
 //WriteAsync is a mocked method
 func (m *DeviceMock) WriteAsync(buffer chan []int16) chan error {
 	args := m.Called(buffer)
@@ -49,6 +61,16 @@ func (m *DeviceMock) WriteSync(reader io.Reader) error {
 	return args.Error(0)
 }
 
+//Drain is a mocked method
+func (m *DeviceMock) Drain() {
+	m.Called()
+}
+
+//Abort is a mocked method
+func (m *DeviceMock) Abort() {
+	m.Called()
+}
+
 //Close is a mocked method
 func (m *DeviceMock) Close() {
 	m.Called()
@@ -79,6 +101,24 @@ func (p *PlaybackMock) PlayFromWsConnection(c websocket.Connection) {
 	p.Called(c)
 }
 
+//PlayClip is a mocked method
+func (p *PlaybackMock) PlayClip(r io.ReadCloser, context *StreamContext) error {
+	args := p.Called(r, context)
+	return args.Error(0)
+}
+
+//PlayAlarm is a mocked method
+func (p *PlaybackMock) PlayAlarm(pattern AlarmPattern, context *StreamContext) error {
+	args := p.Called(pattern, context)
+	return args.Error(0)
+}
+
+//PlaySignal is a mocked method
+func (p *PlaybackMock) PlaySignal(signal TestSignal, context *StreamContext) error {
+	args := p.Called(signal, context)
+	return args.Error(0)
+}
+
 //FactoryMock is a mock of the DeficeFactory interface
 type FactoryMock struct {
 	mock.Mock
@@ -92,3 +132,88 @@ func (f *FactoryMock) New(sampleRate int, channels int, bp *BufferParams) (Playb
 	}
 	return args.Get(0).(PlaybackDevice), args.Error(1)
 }
+
+//Stop is a mocked method
+func (m *PlaybackMock) Stop(priority int, requester string) (*StreamContext, error) {
+	args := m.Called(priority, requester)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*StreamContext), args.Error(1)
+}
+
+//Master is a mocked method
+func (m *PlaybackMock) Master() *Master {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(*Master)
+}
+
+//Status is a mocked method
+func (m *PlaybackMock) Status() *Status {
+	args := m.Called()
+	return args.Get(0).(*Status)
+}
+
+//MixerMock is a mock of the Mixer interface
+type MixerMock struct {
+	mock.Mock
+}
+
+//Elements is a mocked method
+func (m *MixerMock) Elements() ([]MixerElement, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]MixerElement), args.Error(1)
+}
+
+//Volume is a mocked method
+func (m *MixerMock) Volume() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+//SetVolume is a mocked method
+func (m *MixerMock) SetVolume(percent int) error {
+	args := m.Called(percent)
+	return args.Error(0)
+}
+
+//VolumeDB is a mocked method
+func (m *MixerMock) VolumeDB() (float64, error) {
+	args := m.Called()
+	return args.Get(0).(float64), args.Error(1)
+}
+
+//SetVolumeDB is a mocked method
+func (m *MixerMock) SetVolumeDB(db float64) error {
+	args := m.Called(db)
+	return args.Error(0)
+}
+
+//DBRange is a mocked method
+func (m *MixerMock) DBRange() (float64, float64, error) {
+	args := m.Called()
+	return args.Get(0).(float64), args.Get(1).(float64), args.Error(2)
+}
+
+//Muted is a mocked method
+func (m *MixerMock) Muted() (bool, error) {
+	args := m.Called()
+	return args.Bool(0), args.Error(1)
+}
+
+//SetMuted is a mocked method
+func (m *MixerMock) SetMuted(muted bool) error {
+	args := m.Called(muted)
+	return args.Error(0)
+}
+
+//Close is a mocked method
+func (m *MixerMock) Close() {
+	m.Called()
+}
@@ -0,0 +1,160 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	oggHeaderSize = 27
+	oggContinued  = 0x01
+	oggBOS        = 0x02
+	oggEOS        = 0x04
+)
+
+var (
+	//ErrNotOgg is returned when the stream does not start with an Ogg page
+	ErrNotOgg = errors.New("not an Ogg stream")
+	//errOggCRC is returned when a page checksum does not match its content
+	errOggCRC = errors.New("Ogg page checksum mismatch")
+)
+
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return
+}()
+
+func oggCRC(crc uint32, b []byte) uint32 {
+	for _, v := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+//oggPacketReader reassembles packets of the first logical bitstream found in an Ogg physical stream
+type oggPacketReader struct {
+	r       io.Reader
+	serial  uint32
+	started bool
+	eos     bool
+	//segment table and payload of the current page
+	lacing  []byte
+	payload []byte
+	seg     int
+	off     int
+	granule int64
+	partial []byte
+}
+
+func newOggPacketReader(r io.Reader) *oggPacketReader {
+	return &oggPacketReader{r: r}
+}
+
+//nextPage reads pages until one belonging to the selected logical stream is found
+func (o *oggPacketReader) nextPage() error {
+	hdr := make([]byte, oggHeaderSize)
+	for {
+		if _, err := io.ReadFull(o.r, hdr); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return io.EOF
+			}
+			if err == io.EOF && !o.started {
+				return ErrNotOgg
+			}
+			return err
+		}
+		if string(hdr[0:4]) != "OggS" {
+			if !o.started {
+				return ErrNotOgg
+			}
+			return fmt.Errorf("lost Ogg page synchronization")
+		}
+		if hdr[4] != 0 {
+			return fmt.Errorf("unsupported Ogg stream structure version %d", hdr[4])
+		}
+		flags := hdr[5]
+		granule := int64(binary.LittleEndian.Uint64(hdr[6:14]))
+		serial := binary.LittleEndian.Uint32(hdr[14:18])
+		crc := binary.LittleEndian.Uint32(hdr[22:26])
+		lacing := make([]byte, hdr[26])
+		if _, err := io.ReadFull(o.r, lacing); err != nil {
+			return io.EOF
+		}
+		size := 0
+		for _, l := range lacing {
+			size += int(l)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(o.r, payload); err != nil {
+			return io.EOF
+		}
+
+		//the checksum is computed with the CRC field set to zero
+		for i := 22; i < 26; i++ {
+			hdr[i] = 0
+		}
+		sum := oggCRC(0, hdr)
+		sum = oggCRC(sum, lacing)
+		sum = oggCRC(sum, payload)
+		if sum != crc {
+			return errOggCRC
+		}
+
+		if !o.started {
+			if flags&oggBOS == 0 {
+				return fmt.Errorf("first Ogg page lacks the beginning of stream flag")
+			}
+			o.started = true
+			o.serial = serial
+		} else if serial != o.serial {
+			//other multiplexed logical streams are ignored
+			continue
+		}
+		if flags&oggContinued == 0 {
+			o.partial = o.partial[:0]
+		}
+		o.lacing = lacing
+		o.payload = payload
+		o.seg = 0
+		o.off = 0
+		o.granule = granule
+		o.eos = flags&oggEOS != 0
+		return nil
+	}
+}
+
+//next returns the following complete packet and whether it is the last one ending on an EOS page
+func (o *oggPacketReader) next() ([]byte, bool, error) {
+	for {
+		for o.seg < len(o.lacing) {
+			l := int(o.lacing[o.seg])
+			o.partial = append(o.partial, o.payload[o.off:o.off+l]...)
+			o.off += l
+			o.seg++
+			if l < 255 {
+				p := make([]byte, len(o.partial))
+				copy(p, o.partial)
+				o.partial = o.partial[:0]
+				return p, o.eos && o.seg == len(o.lacing), nil
+			}
+		}
+		if o.eos && o.started {
+			return nil, false, io.EOF
+		}
+		if err := o.nextPage(); err != nil {
+			return nil, false, err
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	pipelineReadBuffer = 4096
+	//readerChunks is the number of chunks of pipelineReadBuffer bytes a readerInput decodes ahead
+	readerChunks = 16
+)
+
+//pipeline converts the audio of a single source into what the device expects:
+//the stream gain is applied first, then channels are mapped to the device layout
+//and finally the sample rate is converted to the device rate
+type pipeline struct {
+	inRate    int
+	outRate   int
+	channels  int
+	gain      *Gain
+	mapper    *ChannelMapper
+	resampler *Resampler
+	//partial holds samples of an incomplete frame until the rest of the frame arrives
+	partial []int16
+}
+
+//newPipeline creates the processing chain for a source of 'channels' channels; 'gain' and 'mapper' are optional
+func newPipeline(inRate int, outRate int, channels int, gain *Gain, mapper *ChannelMapper) *pipeline {
+	if channels < 1 {
+		channels = 1
+	}
+	p := &pipeline{inRate: inRate, outRate: outRate, channels: channels, gain: gain, mapper: mapper}
+	if inRate > 0 && outRate > 0 && inRate != outRate {
+		p.resampler = NewResampler(inRate, outRate, p.outChannels())
+	}
+	return p
+}
+
+func (p *pipeline) outChannels() int {
+	if p.mapper != nil {
+		return p.mapper.Channels()
+	}
+	return p.channels
+}
+
+//adjustable makes sure the pipeline resamples so that its ratio can be fine tuned; it must be called before processing
+func (p *pipeline) adjustable() {
+	if p.resampler != nil {
+		return
+	}
+	rate := p.outRate
+	if rate <= 0 {
+		rate = defaultSampleRate
+	}
+	p.resampler = NewResampler(rate, rate, p.outChannels())
+}
+
+//setRatio fine tunes the resampling ratio (see Resampler.SetRatio); it has no effect on pipelines that do not resample
+func (p *pipeline) setRatio(ratio float64) {
+	if p.resampler != nil {
+		p.resampler.SetRatio(ratio)
+	}
+}
+
+//process runs 'in' through the pipeline; the result may be shorter or longer than the input (or empty)
+//and 'in' may get modified
+func (p *pipeline) process(in []int16) []int16 {
+	if len(p.partial) > 0 {
+		in = append(p.partial, in...)
+		p.partial = nil
+	}
+	if rest := len(in) % p.channels; rest > 0 {
+		p.partial = append([]int16(nil), in[len(in)-rest:]...)
+		in = in[:len(in)-rest]
+	}
+	if p.gain != nil {
+		p.gain.Process(in)
+	}
+	if p.mapper != nil {
+		in = p.mapper.Process(in)
+	}
+	if p.resampler != nil {
+		return p.resampler.Process(in)
+	}
+	return in
+}
+
+//flush returns what is left in the pipeline at the end of the source
+func (p *pipeline) flush() []int16 {
+	p.partial = nil
+	if p.resampler != nil {
+		return p.resampler.Flush()
+	}
+	return nil
+}
+
+//pipelineReader runs a S16LE byte stream through a pipeline
+type pipelineReader struct {
+	r    io.Reader
+	p    *pipeline
+	conv *sampleConverter
+	in   []byte
+	out  []byte
+	eof  bool
+}
+
+func newPipelineReader(r io.Reader, p *pipeline) io.Reader {
+	conv, _ := newSampleConverter(FormatS16LE)
+	return &pipelineReader{r: r, p: p, conv: conv, in: make([]byte, pipelineReadBuffer)}
+}
+
+func (r *pipelineReader) Read(b []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		n, err := r.r.Read(r.in)
+		if err == io.EOF {
+			r.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+		//an odd byte waits in the converter for the rest of its sample
+		out := r.p.process(r.conv.convert(r.in[:n]))
+		if r.eof {
+			out = append(out, r.p.flush()...)
+		}
+		r.out = appendS16LE(r.out[:0], out)
+	}
+	n := copy(b, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func appendS16LE(b []byte, samples []int16) []byte {
+	for _, s := range samples {
+		b = append(b, 0, 0)
+		binary.LittleEndian.PutUint16(b[len(b)-2:], uint16(s))
+	}
+	return b
+}
+
+/*readerInput feeds the bus with S16LE audio read from 'r' (e.g. a pipelineReader). 'r' is read and decoded ahead
+by a goroutine of the input so that the bus mixes without waiting for I/O or decoding; the bus gets silence
+while the input has fallen behind. The first chunk is waited for by the constructor so that the source starts
+without a gap. The input keeps its last 'fade' frames until the end so that they can be
+faded out. The goroutine ends with 'r' or once the input is closed (see source.release).
+*/
+type readerInput struct {
+	chunks    chan readerChunk
+	quit      chan bool
+	closeOnce sync.Once
+	channels  int
+	fade      int
+	ahead     []int16
+	eof       bool
+}
+
+func newReaderInput(r io.Reader, channels int, fade int) *readerInput {
+	if channels < 1 {
+		channels = 1
+	}
+	in := &readerInput{chunks: make(chan readerChunk, readerChunks), quit: make(chan bool), channels: channels, fade: fade}
+	go in.decode(r)
+	c, ok := <-in.chunks
+	in.take(c, ok)
+	return in
+}
+
+//take appends the chunk received from the decoding goroutine ('ok' is false once it has ended)
+func (in *readerInput) take(c readerChunk, ok bool) {
+	in.ahead = append(in.ahead, c.samples...)
+	if !ok || c.last {
+		in.eof = true
+		fadeTail(in.ahead, in.channels, len(in.ahead)/in.channels, in.fade)
+	}
+}
+
+//readerChunk is a piece of the audio decoded by a readerInput; 'last' marks the end of the audio
+type readerChunk struct {
+	samples []int16
+	last    bool
+}
+
+//decode reads 'r' into chunks of samples until its end or until the input is closed. A chunk is sent once
+//the next one has been read so that the end arrives along with the last audio.
+func (in *readerInput) decode(r io.Reader) {
+	defer close(in.chunks)
+	conv, _ := newSampleConverter(FormatS16LE)
+	buf := make([]byte, pipelineReadBuffer)
+	var next []int16
+	for {
+		n, err := io.ReadFull(r, buf)
+		samples := conv.convert(buf[:n])
+		if err != nil {
+			select {
+			case <-in.quit:
+				//'r' may have been closed along with the source
+				return
+			default:
+			}
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "decode"}).
+					WithError(err).Error("Could not read source audio")
+			}
+			in.send(readerChunk{append(next, samples...), true})
+			return
+		}
+		if next != nil && !in.send(readerChunk{next, false}) {
+			return
+		}
+		next = samples
+	}
+}
+
+//send passes 'c' to the bus; it returns false when the input has been closed
+func (in *readerInput) send(c readerChunk) bool {
+	select {
+	case in.chunks <- c:
+		return true
+	case <-in.quit:
+		return false
+	}
+}
+
+func (in *readerInput) read(max int) ([]int16, bool) {
+	want := max + in.fade*in.channels
+	for behind := false; !in.eof && !behind && len(in.ahead) < want; {
+		select {
+		case c, ok := <-in.chunks:
+			in.take(c, ok)
+		default:
+			behind = true
+		}
+	}
+	n := len(in.ahead)
+	if !in.eof {
+		//the frames that may turn out to be the last ones wait for the fade
+		n -= in.fade * in.channels
+		n -= n % in.channels
+	}
+	if n > max {
+		n = max
+	}
+	if n <= 0 {
+		return nil, !in.eof
+	}
+	samples := make([]int16, n)
+	copy(samples, in.ahead)
+	in.ahead = append(in.ahead[:0], in.ahead[n:]...)
+	return samples, !in.eof || len(in.ahead) > 0
+}
+
+//Close stops the decoding goroutine
+func (in *readerInput) Close() error {
+	in.closeOnce.Do(func() {
+		close(in.quit)
+	})
+	return nil
+}
@@ -4,9 +4,14 @@ import (
 	"bufio"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/mklimuk/test-alsa/config"
@@ -14,15 +19,57 @@ import (
 )
 
 const textMessage = 1
+
+var (
+	//ErrDeviceBusy is returned when a stream with a higher priority is playing
+	ErrDeviceBusy = errors.New("device busy")
+	//ErrNotPlaying is returned when there is no stream to stop
+	ErrNotPlaying = errors.New("nothing is playing")
+)
+
+//AnyPriority allows Stop to end streams of any priority
+const AnyPriority = int(^uint(0) >> 1)
+
+//defaultSampleRate is assumed when a stream context does not specify the sample rate
 const defaultSampleRate = 22050
-const defaultChannels = 1
+
+//DeviceError wraps errors reported by the playback device so that they can be told apart from decoding errors
+type DeviceError struct {
+	Err error
+}
+
+func (e DeviceError) Error() string {
+	return fmt.Sprintf("audio device error: %v", e.Err)
+}
+
+//DecodeError wraps errors of the decoder so that a stream in an unsupported format can be told apart from other failures
+type DecodeError struct {
+	Err error
+}
+
+func (e DecodeError) Error() string {
+	return fmt.Sprintf("could not decode audio: %v", e.Err)
+}
+
+//defaultFadeMs is the duration of fades at the start and stop of streams when not configured
+const defaultFadeMs = 10
+
+//defaultDeviceIdle is the time the device stays open after the last stream when not configured, in ms
+const defaultDeviceIdle = 5000
+
+//defaultSuspendBuffer is the websocket audio kept for a suspended stream when not configured, in ms
+const defaultSuspendBuffer = 60000
 
 var introEndMsg []byte
 var introStartMsg []byte
+var suspendMsg []byte
+var resumeMsg []byte
 
 func init() {
 	introStartMsg, _ = json.Marshal(SignallingMsg{"playback:intro:start", ""})
 	introEndMsg, _ = json.Marshal(SignallingMsg{"playback:intro:end", ""})
+	suspendMsg, _ = json.Marshal(SignallingMsg{"playback:suspend", ""})
+	resumeMsg, _ = json.Marshal(SignallingMsg{"playback:resume", ""})
 }
 
 //SignallingMsg is a message exchanged with the peer during websocket playback
@@ -36,49 +83,316 @@ type Playback interface {
 	DeviceBusy() (bool, int)
 	PlaybackContext() *StreamContext
 	PlayFromWsConnection(c websocket.Connection)
+	PlayClip(r io.ReadCloser, context *StreamContext) error
+	PlayAlarm(pattern AlarmPattern, context *StreamContext) error
+	PlaySignal(signal TestSignal, context *StreamContext) error
+	Stop(priority int, requester string) (*StreamContext, error)
+	Master() *Master
+	Status() *Status
 }
 
 //StreamContext contains information about currently playing stream
 type StreamContext struct {
-	Description string `json:"description"`
-	Priority    int    `json:"priority"`
-	Volume      int    `json:"volume"`
-	Type        string `json:"type"`
-	PlayIntro   bool   `json:"playIntro"`
-	SampleRate  int    `json:"sampleRate"`
-	Channels    int    `json:"channels"`
-	BufferSize  int    `json:"bufferSize"`
-	bytesRead   int
+	Description string  `json:"description"`
+	Priority    int     `json:"priority"`
+	Volume      *int    `json:"volume"` //0-100 mapped to dB (see VolumeToDB), 0 mutes; unity gain when not set
+	Gain        float64 `json:"gain"`   //in dB; overrides Volume when not zero
+	Type        string  `json:"type"`
+	PlayIntro   bool    `json:"playIntro"`
+	Chime       string  `json:"chime"` //intro chime by name (see config.ChimeConf); the intro is played when set
+	Outro       string  `json:"outro"` //chime played after the end of the stream
+	SampleRate  int     `json:"sampleRate"`
+	Channels    int     `json:"channels"`
+	BufferSize  int     `json:"bufferSize"`
+	Format      Format  `json:"format"`      //sample encoding of binary messages; S16_LE when empty
+	Resumable   bool    `json:"resumable"`   //suspended instead of stopped by a higher priority stream and resumed after it
+	ReplayIntro bool    `json:"replayIntro"` //the intro is played again when a resumable stream resumes
+	bytesRead   int64
 	framesWrote int
+	started     time.Time
+	intro       int32
+}
+
+//Status is a snapshot of the playback state
+type Status struct {
+	Busy        bool           `json:"busy"`
+	Stream      *StreamContext `json:"stream,omitempty"`
+	Started     *time.Time     `json:"started,omitempty"`
+	BytesRead   int64          `json:"bytesRead"`
+	FramesWrote int            `json:"framesWrote"`      //frames of the stream mixed into the output
+	Xruns       int            `json:"xruns"`            //underruns recovered while the stream played
+	Intro       bool           `json:"intro"`            //true while the intro is playing
+	Ducked      bool           `json:"ducked"`           //true while attenuated under a higher priority stream
+	Suspended   bool           `json:"suspended"`        //true while a resumable stream waits for a higher priority one to end
+	Jitter      *JitterStats   `json:"jitter,omitempty"` //websocket streams only
+	Others      []Status       `json:"others,omitempty"` //streams mixed along with this one
 }
 
 type play struct {
-	connMutex         sync.Mutex
-	context           *StreamContext
-	connection        websocket.Connection
-	introFile         string
-	samplesBufferSize int
-	bufParams         *BufferParams
-	factory           DeviceFactory
-	dev               PlaybackDevice
+	connMutex sync.Mutex
+	//context is the stream with the highest priority (the latest one among equal priorities)
+	context *StreamContext
+	//sessions are the streams admitted to playback in the order they started
+	sessions      []*session
+	mix           bool
+	bus           *bus
+	chimes        *chimes
+	bufParams     *BufferParams
+	volumeRamp    int
+	master        *Master
+	jitterTarget  int
+	jitterMax     int
+	maxDrift      int
+	suspendBuffer int
+	monoChannels  []int
+	channelMaps   []config.ChannelMapConf
+}
+
+//session is a stream admitted to playback with its source on the bus and the ones of its intro and outro while they play
+type session struct {
+	//mutex guards the fields of the context changed while the stream plays (its volume and gain)
+	mutex      sync.Mutex
+	context    *StreamContext
+	connection websocket.Connection
+	source     *source
+	jitter     *jitterBuffer
+	chimes     []*source
+	suspended  bool
+	//xruns are the device underruns counted when the stream started
+	xruns int
 }
 
 //New is the playback interface constructor
 func New(conf *config.AudioConf, factory DeviceFactory, introFile string) Playback {
 	p := play{
-		factory:           factory,
-		bufParams:         &BufferParams{BufferFrames: conf.DeviceBuffer, PeriodFrames: conf.PeriodFrames, Periods: conf.Periods},
-		introFile:         introFile,
-		samplesBufferSize: conf.ReadBuffer,
+		bufParams:    &BufferParams{BufferFrames: conf.DeviceBuffer, PeriodFrames: conf.PeriodFrames, Periods: conf.Periods},
+		chimes:       newChimes(conf.Chimes, conf.ChimeDefaults, introFile),
+		volumeRamp:   conf.VolumeRamp,
+		master:       NewMaster(100),
+		mix:          conf.Mix,
+		monoChannels: conf.MonoChannels,
+		channelMaps:  conf.ChannelMaps,
+		jitterTarget: conf.JitterTarget,
+		jitterMax:    conf.JitterMax,
+		maxDrift:     conf.MaxDrift,
+	}
+	if p.suspendBuffer = conf.SuspendBuffer; p.suspendBuffer <= 0 {
+		p.suspendBuffer = defaultSuspendBuffer
+	}
+	p.bus = newBus(factory, p.bufParams, conf.DeviceRate, conf.DeviceChannels, conf.VolumeRamp)
+	if conf.LowerPriorityGain != nil {
+		p.bus.lowerDB = *conf.LowerPriorityGain
+	}
+	p.bus.ducking = conf.Ducking
+	p.bus.fadeIn = fadeMs(conf.FadeIn)
+	p.bus.fadeOut = fadeMs(conf.FadeOut)
+	p.bus.crossfade = conf.Crossfade
+	if conf.DeviceIdle == 0 {
+		p.bus.idle = defaultDeviceIdle * time.Millisecond
+	} else if conf.DeviceIdle > 0 {
+		p.bus.idle = time.Duration(conf.DeviceIdle) * time.Millisecond
 	}
 	if log.GetLevel() >= log.DebugLevel {
-		log.WithFields(log.Fields{"logger": "ws.audio-endpoint.audio", "method": "New", "introFile": p.introFile, "bufParams": fmt.Sprintf("%+v", &(p.bufParams))}).
+		log.WithFields(log.Fields{"logger": "ws.audio-endpoint.audio", "method": "New", "introFile": p.chimes.fallback, "bufParams": fmt.Sprintf("%+v", &(p.bufParams)), "mix": p.mix}).
 			Debug("Playback configuration")
 	}
 	return &p
 }
 
+//fadeMs returns the configured fade duration in ms: the default one when not set and 0 (no fade) when negative
+func fadeMs(ms int) int {
+	if ms == 0 {
+		return defaultFadeMs
+	}
+	if ms < 0 {
+		return 0
+	}
+	return ms
+}
+
+//Master returns the software master gain applied to every stream
+func (p *play) Master() *Master {
+	return p.master
+}
+
+//newMapper returns the channel mapper converting 'in' source channels to 'out' device channels or nil if no mapping is needed
+func (p *play) newMapper(in int, out int) *ChannelMapper {
+	if in < 1 {
+		in = 1
+	}
+	for _, c := range p.channelMaps {
+		if c.In != in || c.Out != out {
+			continue
+		}
+		m, err := NewChannelMapper(in, out, c.Matrix)
+		if err == nil {
+			return m
+		}
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "newMapper", "in": in, "out": out}).
+			WithError(err).Warn("Invalid channel map configuration; using the default one")
+	}
+	if out == in {
+		return nil
+	}
+	m, _ := NewChannelMapper(in, out, DefaultChannelMatrix(in, out, p.monoChannels))
+	return m
+}
+
+//newPipeline creates the processing chain converting a source described by 'context' to the format of the device 'd'
+func (p *play) newPipeline(context *StreamContext, d *busDevice) *pipeline {
+	rate := context.SampleRate
+	if rate <= 0 {
+		rate = defaultSampleRate
+	}
+	return newPipeline(rate, d.rate, context.Channels, p.newGain(context), p.newMapper(context.Channels, d.channels))
+}
+
+//newGain creates the gain stage of a stream following the master gain
+func (p *play) newGain(context *StreamContext) *Gain {
+	return p.masterGain(context.GainDB(), context.Channels, context.SampleRate)
+}
+
+//masterGain creates a gain stage at 'db' following the master gain; every source plays through one
+func (p *play) masterGain(db float64, channels int, sampleRate int) *Gain {
+	g := NewGain(db, channels, rampFrames(p.volumeRamp, sampleRate))
+	g.follow(p.master)
+	return g
+}
+
+//Status returns a snapshot of the stream with the highest priority; other streams being mixed are listed in Others
+func (p *play) Status() *Status {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	top := p.top()
+	if top == nil {
+		return &Status{}
+	}
+	xruns := p.bus.xruns()
+	s := top.status(xruns)
+	for _, o := range p.sessions {
+		if o != top {
+			s.Others = append(s.Others, *o.status(xruns))
+		}
+	}
+	return s
+}
+
+//status returns a snapshot of the session given the device underruns so far; it must be called with connMutex held
+func (s *session) status(xruns int) *Status {
+	s.mutex.Lock()
+	c := StreamContext{
+		Description: s.context.Description,
+		Priority:    s.context.Priority,
+		Volume:      s.context.Volume,
+		Gain:        s.context.Gain,
+		Type:        s.context.Type,
+		PlayIntro:   s.context.PlayIntro,
+		Chime:       s.context.Chime,
+		Outro:       s.context.Outro,
+		SampleRate:  s.context.SampleRate,
+		Channels:    s.context.Channels,
+		BufferSize:  s.context.BufferSize,
+		Format:      s.context.Format,
+		Resumable:   s.context.Resumable,
+		ReplayIntro: s.context.ReplayIntro,
+		started:     s.context.started,
+	}
+	s.mutex.Unlock()
+	st := &Status{Busy: true, Stream: &c, Started: &c.started}
+	st.BytesRead = atomic.LoadInt64(&s.context.bytesRead)
+	st.Intro = atomic.LoadInt32(&s.context.intro) == 1
+	st.Suspended = s.suspended
+	//the count starts over with a device opened after the stream started
+	if st.Xruns = xruns - s.xruns; st.Xruns < 0 {
+		st.Xruns = xruns
+	}
+	if s.source != nil {
+		st.FramesWrote = s.source.framesMixed()
+		st.Ducked = s.source.attenuated()
+	}
+	if s.jitter != nil {
+		j := s.jitter.stats()
+		st.Jitter = &j
+	}
+	return st
+}
+
+/*Stop ends the streams with a priority up to 'priority' and discards their audio. Streams with a higher priority
+are left playing; ErrDeviceBusy is returned when only such streams play. Use AnyPriority to stop regardless.
+The context of the highest priority stream stopped is returned. 'requester' identifies the caller in logs.
+*/
+func (p *play) Stop(priority int, requester string) (*StreamContext, error) {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	clog := log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "Stop", "requester": requester})
+	if len(p.sessions) == 0 {
+		return nil, ErrNotPlaying
+	}
+	var stopped *StreamContext
+	for _, s := range append([]*session(nil), p.sessions...) {
+		if s.context.Priority > priority {
+			continue
+		}
+		clog.WithFields(log.Fields{"description": s.context.Description, "streamPriority": s.context.Priority}).
+			Warn("Stopping stream on request")
+		p.interrupt(s, websocket.CloseNormalClosure, "Stream stopped by "+requester)
+		if stopped == nil || s.context.Priority >= stopped.Priority {
+			stopped = s.context
+		}
+	}
+	p.wake()
+	if stopped == nil {
+		clog.WithFields(log.Fields{"priority": priority, "streamPriority": p.context.Priority}).
+			Warn("Refusing to stop a stream with higher priority")
+		return nil, ErrDeviceBusy
+	}
+	return stopped, nil
+}
+
+//attach makes 'src' the source played by the session (with 'jitter' as its buffer) unless the session
+//has been stopped meanwhile in which case the source is aborted; a suspended session holds the source
+func (p *play) attach(s *session, src *source, jitter *jitterBuffer) {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	if !p.active(s) {
+		src.abort()
+		return
+	}
+	s.source = src
+	s.jitter = jitter
+	if s.suspended {
+		src.suspend()
+		jitter.hold(p.suspendBuffer)
+	}
+}
+
+//attachChime makes 'src' a chime of the session unless the session has been stopped or suspended
+//in which case the chime is aborted
+func (p *play) attachChime(s *session, src *source) {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	if !p.active(s) || s.suspended {
+		src.abort()
+		return
+	}
+	s.chimes = append(s.chimes, src)
+}
+
+//detachChime forgets the chime of the session once it has ended
+func (p *play) detachChime(s *session, src *source) {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	for i, c := range s.chimes {
+		if c == src {
+			s.chimes = append(s.chimes[:i], s.chimes[i+1:]...)
+			return
+		}
+	}
+}
+
 func (p *play) PlaybackContext() *StreamContext {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
 	return p.context
 }
 
@@ -91,6 +405,14 @@ func (p *play) DeviceBusy() (bool, int) {
 	return true, p.context.Priority
 }
 
+/*admit tells if a stream with 'context' may start. Without mixing a stream with a lower priority than the current one
+is rejected unless a ducking rule lets it play attenuated; with mixing every stream is admitted and the bus decides
+its gain from the priorities. It must be called with connMutex held.
+*/
+func (p *play) admit(context *StreamContext) bool {
+	return p.mix || p.context == nil || p.context.Priority <= context.Priority || p.bus.ducks(context, p.context.Priority)
+}
+
 /*PlayFromWsConnection streams audio data from a websocket connection into an audio device.
 The first message received must be a text message containing stream context (see StreamContext type) in a JSON format.
 */
@@ -125,148 +447,590 @@ func (p *play) PlayFromWsConnection(c websocket.Connection) {
 		return
 	}
 
+	if !context.Format.Valid() {
+		c.CloseWithReason(websocket.CloseInvalidFramePayloadData, "Unsupported sample format")
+		return
+	}
+
 	//stream with lower priority will get rejected
-	if p.context != nil && p.context.Priority > context.Priority {
+	if !p.admit(context) {
 		c.CloseWithReason(websocket.CloseTryAgainLater, "Device busy")
 		return
 	}
 
-	//if there is an existing connection we have to stop it
-	if p.connection != nil {
-		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "registerStream"}).
-			Info("Stopping currently open connection")
-		p.connection.CloseWithCode(websocket.CloseGoingAway)
-	}
-	p.connection = c
-	p.context = context
+	//unless streams are mixed or ducked the existing ones have to be stopped
+	p.preempt(context)
+	s := &session{context: context, connection: c}
+	context.started = time.Now()
+	p.start(s)
 	//we continue in a separate goroutine
-	go p.doPlayFromWsConnection()
+	go p.doPlayFromWsConnection(s)
 	return
 }
 
-func (p *play) doPlayFromWsConnection() {
-	defer p.cleanup()
+/*PlayClip plays audio read from 'r' (a WAV or Ogg Vorbis container) with the given stream context.
+The priority check is the same as for websocket streams. The device is opened synchronously
+so that the caller learns about device errors; the playback itself continues in the background
+and 'r' is closed once it ends.
+*/
+func (p *play) PlayClip(r io.ReadCloser, context *StreamContext) error {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+
+	if !p.admit(context) {
+		r.Close()
+		return ErrDeviceBusy
+	}
 
+	var w Decoder
 	var err error
-	//play intro (ding-dong) if requested
-	if p.context.PlayIntro == true {
-		if log.GetLevel() >= log.DebugLevel {
-			log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": p.connection.ID()}).
-				Debug("Playing intro file")
+	in := &readErrors{r: r}
+	if w, err = NewDecoder(bufio.NewReader(in)); err != nil {
+		r.Close()
+		if in.err != nil {
+			return in.err
 		}
-		p.connection.WriteMessage(websocket.TextMessage, introStartMsg)
-		if err = p.PlayFile(p.introFile); err != nil {
-			log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": p.connection.ID()}).
-				WithError(err).Warn("Could not play intro file")
-			msg, _ := json.Marshal(SignallingMsg{"playback:intro:warn", "Could not play intro"})
-			p.connection.WriteMessage(websocket.TextMessage, msg)
-			//we continue anyway
-		}
-		p.connection.WriteMessage(websocket.TextMessage, introEndMsg)
+		return DecodeError{err}
+	}
+	context.SampleRate = w.SampleRate()
+	context.Channels = w.Channels()
+
+	var d *busDevice
+	if d, err = p.bus.acquire(context.SampleRate, context.Channels); err != nil {
+		r.Close()
+		return err
+	}
+
+	p.preempt(context)
+	src := newSource(context, newReaderInput(newPipelineReader(w, p.newPipeline(context, d)), d.channels, fadeFrames(p.bus.fadeOut, d.rate)))
+	if err = p.bus.add(src, d); err != nil {
+		r.Close()
+		return err
+	}
+	s := &session{context: context, source: src}
+	context.started = time.Now()
+	p.start(s)
+	go p.doPlayClip(r, s)
+	return nil
+}
+
+func (p *play) doPlayClip(f io.Closer, s *session) {
+	defer f.Close()
+	defer p.cleanup(nil, s)
+	if log.GetLevel() >= log.InfoLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayClip", "description": s.context.Description}).
+			Info("Starting clip playback")
+	}
+	if err := s.source.wait(); err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayClip", "description": s.context.Description}).
+			WithError(err).Error("Could not play clip")
+	}
+}
+
+func (p *play) doPlayFromWsConnection(s *session) {
+	var err error
+	c, context := s.connection, s.context
+	defer p.cleanup(c, s)
+
+	//the device is acquired first so that the intro is played in the format of the stream
+	var d *busDevice
+	if d, err = p.bus.acquire(context.SampleRate, context.Channels); err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": c.ID()}).
+			WithError(err).Error("Could not initialize audio device")
+		c.CloseWithReason(websocket.CloseInternalServerErr, "Could not initialize audio device")
+		return
 	}
 
-	//initialize playback device
-	if p.dev, err = p.factory.New(p.context.SampleRate, p.context.Channels, p.bufParams); err != nil {
-		p.connection.CloseWithReason(websocket.CloseInternalServerErr, "Could not initialize audio device")
+	//the bus pulls audio from the jitter buffer which holds it back until the playout target is reached
+	jitter := newJitterBuffer(d.rate, d.channels, p.jitterTarget, p.jitterMax, p.maxDrift)
+	jitter.fadeOut = fadeFrames(p.bus.fadeOut, d.rate)
+	src := newSource(context, jitter)
+
+	//play intro (ding-dong) if requested; the stream is buffered while it plays (up to the suspend buffer)
+	//and follows right after it
+	if _, ok := p.chimes.intro(context); ok {
+		intro, ierr := p.openIntro(s)
+		if ierr == nil {
+			src.follow(intro)
+			jitter.prebuffer(p.suspendBuffer)
+		}
+		go p.signalIntro(s, intro, ierr)
+	}
+	if err = p.bus.add(src, d); err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": c.ID()}).
+			WithError(err).Error("Could not initialize audio device")
+		c.CloseWithReason(websocket.CloseInternalServerErr, "Could not initialize audio device")
 		return
 	}
-	devbuf := make(chan []int16, p.samplesBufferSize)
-	var deverr chan error
-	defer close(devbuf)
+	p.attach(s, src, jitter)
+	if log.GetLevel() >= log.InfoLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": c.ID()}).
+			Info("Stream added to the output")
+	}
 
-	//prepare connection read buffers
+	//prepare connection read buffer and the stream gain stage
 	var buf []byte
-	buf16 := make([]int16, p.context.BufferSize)
+	var msg string
+	pipe := p.newPipeline(context, d)
+	pipe.adjustable()
+	conv, _ := newSampleConverter(context.Format)
+
+	//push converts a message from the stream format to int16, runs it through the pipeline and queues the result;
+	//the resampler follows the drift between the sender and the device clocks measured by the jitter buffer
+	push := func(buf []byte) {
+		atomic.AddInt64(&context.bytesRead, int64(len(buf)))
+		jitter.push(pipe.process(conv.convert(buf)))
+		pipe.setRatio(jitter.ratio())
+	}
+	//finish plays out everything queued on a natural end of stream followed by the outro and discards it otherwise
+	//once the bus has faded the stream out
+	finish := func(drain bool) {
+		if !drain {
+			src.abort()
+			src.wait()
+			jitter.abort()
+			return
+		}
+		jitter.push(pipe.flush())
+		jitter.close()
+		outro := p.openOutro(s, src)
+		if err := src.wait(); err != nil {
+			log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": c.ID()}).
+				WithError(err).Error("Could not play out the end of stream")
+		}
+		if outro != nil {
+			<-outro.played
+			p.detachChime(s, outro)
+		}
+	}
 
 	//start the connection read routine
 	if log.GetLevel() >= log.DebugLevel {
-		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": p.connection.ID()}).
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": c.ID()}).
 			Debug("Starting read loop")
 	}
-	go p.connection.ReadLoop()
-	bin, _ := p.connection.In()
+	go c.ReadLoop()
+	bin, txt := c.In()
 
 	var ok bool
-	var writing bool
 	for {
 		select {
 		case buf, ok = <-bin: //binary audio data from the websocket
 			if !ok {
 				if log.GetLevel() >= log.InfoLevel {
-					log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": p.connection.ID()}).
+					log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": c.ID()}).
 						Info("Binary input channel is closed; aborting read loop")
 				}
+				finish(p.current(s))
 				return
 			}
 			if log.GetLevel() >= log.DebugLevel {
 				log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "readBytes": len(buf)}).
 					Debug("Read bytes from connection")
 			}
-			p.context.bytesRead += len(buf)
-
-			//convert to int16 and push to the output buffer
-			convertBuffers(buf, buf16)
-			devbuf <- buf16
-
-			//if the buffer is full we start sending audio to the audio device
-			if !writing && len(devbuf) == cap(devbuf) {
-				if log.GetLevel() >= log.InfoLevel {
-					log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection", "Connection": p.connection.ID()}).
-						Info("Starting audio device write routine")
-				}
-				deverr = p.dev.WriteAsync(devbuf)
-				writing = true
+			push(buf)
+		case msg, ok = <-txt: //signalling messages from the peer
+			if !ok {
+				txt = nil
+				continue
 			}
-		case <-p.connection.Control(): //connection control chanel
+			p.handleSignalling(s, pipe.gain, msg)
+		case <-c.Control(): //connection control chanel
 			if log.GetLevel() >= log.DebugLevel {
 				log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection"}).
 					Debug("Received connection close signal")
 			}
+			//the connection is closed either by the peer (end of stream) or by us when the stream is preempted or stopped
+			if !p.current(s) {
+				finish(false)
+				return
+			}
+			//audio received before the peer closed the connection may still wait in the input channel
+			for pending := true; pending; {
+				select {
+				case buf, pending = <-bin:
+					if pending {
+						push(buf)
+					}
+				default:
+					pending = false
+				}
+			}
+			finish(true)
+			return
+		case <-src.done: //the stream left the bus before its end: it has been stopped or the device failed
+			if err = src.err; err != nil {
+				log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection"}).
+					WithError(err).Error("Could not write buffer content to device")
+				jitter.abort()
+				c.CloseWithReason(websocket.CloseInternalServerErr, "Could not write buffer content to audio device")
+			}
+			return
+		}
+	}
+}
+
+//playIntro plays the intro of the session and signals its start and end to the peer
+func (p *play) playIntro(s *session) {
+	src, err := p.openIntro(s)
+	p.signalIntro(s, src, err)
+}
+
+//openIntro adds the intro of the session to the bus; it is played on the device acquired for the stream
+func (p *play) openIntro(s *session) (*source, error) {
+	if log.GetLevel() >= log.DebugLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "openIntro", "Connection": s.connection.ID()}).
+			Debug("Playing intro file")
+	}
+	atomic.StoreInt32(&s.context.intro, 1)
+	name, _ := p.chimes.intro(s.context)
+	src, err := p.openChime(name, s.context, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.attachChime(s, src)
+	return src, nil
+}
+
+//openOutro adds the outro of the session to the bus to be played after 'after'; it returns nil when there is none
+func (p *play) openOutro(s *session, after *source) *source {
+	name := p.chimes.outro(s.context)
+	if name == "" {
+		return nil
+	}
+	src, err := p.openChime(name, s.context, after)
+	if err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "openOutro", "Connection": s.connection.ID()}).
+			WithError(err).Warn("Could not play outro")
+		return nil
+	}
+	p.attachChime(s, src)
+	return src
+}
+
+/*signalIntro tells the peer when the intro 'src' starts and ends being audible and returns once it has been
+played out; 'err' is the error that kept the intro from being played, in which case the peer is warned.
+*/
+func (p *play) signalIntro(s *session, src *source, err error) {
+	c := s.connection
+	if err == nil {
+		select {
+		case <-src.audible:
+		case <-src.played:
+		}
+	}
+	c.WriteMessage(websocket.TextMessage, introStartMsg)
+	if err == nil {
+		<-src.played
+		p.detachChime(s, src)
+		err = src.err
+	}
+	atomic.StoreInt32(&s.context.intro, 0)
+	if err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "signalIntro", "Connection": c.ID()}).
+			WithError(err).Warn("Could not play intro file")
+		msg, _ := json.Marshal(SignallingMsg{"playback:intro:warn", "Could not play intro"})
+		c.WriteMessage(websocket.TextMessage, msg)
+		//we continue anyway
+	}
+	c.WriteMessage(websocket.TextMessage, introEndMsg)
+}
+
+//current tells if the session is still playing (it has not been preempted or stopped)
+func (p *play) current(s *session) bool {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	return p.active(s)
+}
+
+//active is current for callers holding connMutex
+func (p *play) active(s *session) bool {
+	for _, a := range p.sessions {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
+
+/*handleSignalling processes text messages received during the stream. Supported messages:
+
+	{"type": "playback:volume", "payload": "<0-100>"}
+	{"type": "playback:gain", "payload": "<dB>"}
+
+Both change the stream gain with a ramp; unknown or malformed messages are logged and ignored.
+*/
+func (p *play) handleSignalling(s *session, gain *Gain, msg string) {
+	c, context := s.connection, s.context
+	var m SignallingMsg
+	var err error
+	if err = json.Unmarshal([]byte(msg), &m); err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "handleSignalling", "Connection": c.ID()}).
+			WithError(err).Warn("Could not decode signalling message")
+		return
+	}
+	var db float64
+	switch m.Type {
+	case "playback:volume":
+		var v int
+		if v, err = strconv.Atoi(m.Payload); err != nil || v < 0 || v > 100 {
+			log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "handleSignalling", "payload": m.Payload}).
+				Warn("Invalid volume requested")
 			return
-		case err = <-deverr: //errors from audio device
-			log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlayFromWsConnection"}).
-				WithError(err).Error("Could not write buffer content to device")
-			p.connection.CloseWithReason(websocket.CloseInternalServerErr, "Could not write buffer content to audio device")
+		}
+		s.mutex.Lock()
+		context.Volume = &v
+		context.Gain = 0
+		db = context.GainDB()
+		s.mutex.Unlock()
+	case "playback:gain":
+		if db, err = strconv.ParseFloat(m.Payload, 64); err != nil {
+			log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "handleSignalling", "payload": m.Payload}).
+				Warn("Invalid gain requested")
 			return
 		}
+		s.mutex.Lock()
+		context.Gain = db
+		db = context.GainDB()
+		s.mutex.Unlock()
+	default:
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "handleSignalling", "type": m.Type}).
+			Warn("Unknown signalling message")
+		return
+	}
+	if log.GetLevel() >= log.DebugLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "handleSignalling", "Connection": c.ID(), "gain": db}).
+			Debug("Changing stream gain")
 	}
+	gain.SetDB(db)
 }
 
-//PlayFile sends contents of the file represented by 'filepath' to Alsa audio device.
-//This method uses default sample rate and channels number.
+//PlayFile sends contents of the WAV or Ogg Vorbis file represented by 'filepath' to Alsa audio device
+//and returns once it has been played out. The file is converted to the format of the output and mixed
+//with the streams playing; the device is opened with the format of the file when nothing plays.
 func (p *play) PlayFile(filepath string) error {
+	src, err := p.openFile(filepath, &StreamContext{Description: filepath, Type: "file"}, 0, nil)
+	if err != nil {
+		return err
+	}
+	return src.wait()
+}
+
+//openFile adds the file at 'filepath' to the bus as a source with 'context' amplified by 'db' and starting
+//after 'after' (when not nil); the file is closed once the source has ended
+func (p *play) openFile(filepath string, context *StreamContext, db float64, after *source) (*source, error) {
 	var f *os.File
 	var err error
 	if f, err = os.Open(filepath); err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
 
-	r := bufio.NewReader(f)
+	var w Decoder
+	if w, err = NewDecoder(bufio.NewReader(f)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not read %s: %v", filepath, err)
+	}
+	if log.GetLevel() >= log.DebugLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "openFile", "file": filepath, "sampleRate": w.SampleRate(), "channels": w.Channels()}).
+			Debug("Parsed file header")
+	}
 
-	//initialize the device and buffers
-	var dev PlaybackDevice
-	if dev, err = p.factory.New(defaultSampleRate, defaultChannels, p.bufParams); err != nil {
-		return err
+	var d *busDevice
+	if d, err = p.bus.acquire(w.SampleRate(), w.Channels()); err != nil {
+		f.Close()
+		return nil, err
 	}
-	defer dev.Close()
-	dev.WriteSync(r)
-	return nil
+	gain := p.masterGain(db, w.Channels(), w.SampleRate())
+	src := newSource(context, newReaderInput(newPipelineReader(w, newPipeline(w.SampleRate(), d.rate, w.Channels(), gain, p.newMapper(w.Channels(), d.channels))), d.channels, fadeFrames(p.bus.fadeOut, d.rate)))
+	src.follow(after)
+	if err = p.bus.add(src, d); err != nil {
+		f.Close()
+		return nil, err
+	}
+	go func() {
+		<-src.done
+		f.Close()
+	}()
+	return src, nil
 }
 
-func (p *play) cleanup() {
-	var wrote int
-	if p.dev != nil {
-		wrote = p.dev.FramesWrote()
-		p.dev.Close()
-	}
+func (p *play) cleanup(c websocket.Connection, s *session) {
+	mixed := p.release(s)
 	if log.GetLevel() >= log.InfoLevel {
-		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "cleanup", "bytesRead": p.context.bytesRead, "framesWrote": wrote}).
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "cleanup", "bytesRead": atomic.LoadInt64(&s.context.bytesRead), "framesWrote": mixed}).
 			Info("Audio device read, write summary")
 	}
-	p.connection.CloseWithCode(websocket.CloseNormalClosure)
-	p.connection = nil
+	if c != nil {
+		c.CloseWithCode(websocket.CloseNormalClosure)
+	}
+}
+
+//start adds the session to the ones playing; it must be called with connMutex held
+func (p *play) start(s *session) {
+	s.xruns = p.bus.xruns()
+	p.sessions = append(p.sessions, s)
+	p.update()
+}
+
+//release removes the session unless it has already been stopped and returns the number of frames it played
+func (p *play) release(s *session) int {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	p.remove(s)
+	p.wake()
+	if s.source == nil {
+		return 0
+	}
+	return s.source.framesMixed()
+}
+
+//remove drops the session from the ones playing; it must be called with connMutex held
+func (p *play) remove(s *session) {
+	for i, a := range p.sessions {
+		if a == s {
+			p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+			break
+		}
+	}
+	p.update()
+}
+
+//top returns the playing session with the highest priority, the latest one among equals; it must be called with connMutex held
+func (p *play) top() *session {
+	var top *session
+	for _, s := range p.sessions {
+		if s.suspended {
+			continue
+		}
+		if top == nil || s.context.Priority >= top.context.Priority {
+			top = s
+		}
+	}
+	return top
+}
+
+//update points the current context to the top session; it must be called with connMutex held
+func (p *play) update() {
 	p.context = nil
+	if top := p.top(); top != nil {
+		p.context = top.context
+	}
+}
+
+//preempt stops the streams playing below 'context' unless streams are mixed or ducking rules keep them;
+//resumable streams are suspended instead. It must be called with connMutex held.
+func (p *play) preempt(context *StreamContext) {
+	if p.mix {
+		return
+	}
+	for _, s := range append([]*session(nil), p.sessions...) {
+		if s.suspended || p.bus.ducks(s.context, context.Priority) {
+			continue
+		}
+		if s.context.Resumable {
+			p.suspend(s)
+			continue
+		}
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "preempt", "description": s.context.Description}).
+			Info("Stopping currently playing stream")
+		p.interrupt(s, websocket.CloseGoingAway, "")
+	}
+}
+
+//interrupt closes the connection of the session with 'code' and 'reason', stops its audio (with a fade out)
+//and removes it; it must be called with connMutex held
+func (p *play) interrupt(s *session, code int, reason string) {
+	if s.connection != nil {
+		s.connection.CloseWithReason(code, reason)
+	}
+	for _, c := range s.chimes {
+		c.abort()
+	}
+	if s.source != nil {
+		s.source.abort()
+	}
+	p.remove(s)
+}
+
+//suspend pauses a resumable session; websocket audio keeps being buffered up to the suspend limit
+//and the position of clips is kept. It must be called with connMutex held.
+func (p *play) suspend(s *session) {
+	log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "suspend", "description": s.context.Description}).
+		Info("Suspending resumable stream")
+	s.suspended = true
+	for _, c := range s.chimes {
+		c.abort()
+	}
+	s.chimes = nil
+	if s.source != nil {
+		s.source.suspend()
+	}
+	if s.jitter != nil {
+		s.jitter.hold(p.suspendBuffer)
+	}
+	if s.connection != nil {
+		s.connection.WriteMessage(websocket.TextMessage, suspendMsg)
+	}
+	p.update()
+}
+
+/*wake resumes suspended sessions, the highest priority first (the earliest among equals), as long as
+no playing session has a priority as high as theirs. It must be called with connMutex held.
+*/
+func (p *play) wake() {
+	for {
+		var next *session
+		for _, s := range p.sessions {
+			if s.suspended && (next == nil || s.context.Priority > next.context.Priority) {
+				next = s
+			}
+		}
+		if next == nil {
+			return
+		}
+		for _, s := range p.sessions {
+			if !s.suspended && s.context.Priority >= next.context.Priority {
+				return
+			}
+		}
+		p.resume(next)
+	}
+}
+
+//resume continues a suspended session, after replaying its intro if requested; it must be called with connMutex held
+func (p *play) resume(s *session) {
+	log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "resume", "description": s.context.Description}).
+		Info("Resuming stream")
+	s.suspended = false
+	p.update()
+	if s.connection != nil {
+		s.connection.WriteMessage(websocket.TextMessage, resumeMsg)
+		if _, ok := p.chimes.intro(s.context); ok && s.context.ReplayIntro {
+			go p.replayIntro(s)
+			return
+		}
+	}
+	p.unhold(s)
+}
+
+//replayIntro plays the intro of a resumed session before its stream continues
+func (p *play) replayIntro(s *session) {
+	p.playIntro(s)
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+	if p.active(s) && !s.suspended {
+		p.unhold(s)
+	}
+}
+
+//unhold lets the bus play the source of the session again; it must be called with connMutex held
+func (p *play) unhold(s *session) {
+	if s.source != nil {
+		s.source.resume()
+	}
+	if s.jitter != nil {
+		s.jitter.resume()
+	}
 }
 
 func convertBuffers(buf []byte, buf16 []int16) {
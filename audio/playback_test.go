@@ -1,7 +1,14 @@
 package audio
 
 import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -31,6 +38,11 @@ func (suite *PlaybackTestSuite) TestConstructor() {
 	a.Equal(1024, p.bufParams.BufferFrames)
 	a.Equal(512, p.bufParams.PeriodFrames)
 	a.Equal(2, p.bufParams.Periods)
+	a.Equal(defaultDeviceIdle*time.Millisecond, p.bus.idle)
+	a.Equal(defaultFadeMs, p.bus.fadeIn)
+	p = New(&config.AudioConf{DeviceIdle: -1, FadeOut: -1}, &FactoryMock{}, "").(*play)
+	a.Equal(time.Duration(0), p.bus.idle)
+	a.Equal(0, p.bus.fadeOut)
 }
 
 func (suite *PlaybackTestSuite) TestDeviceBusy() {
@@ -56,15 +68,78 @@ func (suite *PlaybackTestSuite) TestPlaybackOngoing() {
 	c := websocket.ConnectionMock{}
 	c.On("ReadMessage").Return(textMessage, []byte(`{"priority": 2}`), nil)
 	c.On("CloseWithReason", websocket.CloseTryAgainLater, mock.AnythingOfType("string")).Return().Once()
-	pl := play{context: &StreamContext{Priority: 3}}
+	pl := play{context: &StreamContext{Priority: 3}, bus: &bus{}}
 	pl.PlayFromWsConnection(&c)
 	c.AssertExpectations(suite.T())
 }
 
+func (suite *PlaybackTestSuite) TestDuckedStreams() {
+	a := assert.New(suite.T())
+	p := New(&config.AudioConf{Ducking: []config.DuckConf{{MinPriority: 1, MaxPriority: 2, Types: []string{"music"}, Gain: -20}}}, &FactoryMock{}, "").(*play)
+	music := &websocket.ConnectionMock{}
+	news := &websocket.ConnectionMock{}
+	news.On("CloseWithReason", websocket.CloseGoingAway, "").Return().Once()
+	p.start(&session{context: &StreamContext{Priority: 1, Type: "music"}, connection: music})
+	p.start(&session{context: &StreamContext{Priority: 2, Type: "news"}, connection: news})
+	//streams covered by a ducking rule may start under a higher priority and are not preempted by one
+	a.True(p.admit(&StreamContext{Priority: 1, Type: "music"}))
+	a.False(p.admit(&StreamContext{Priority: 1, Type: "news"}))
+	p.preempt(&StreamContext{Priority: 5})
+	a.Len(p.sessions, 1)
+	a.Equal("music", p.PlaybackContext().Type)
+	music.AssertExpectations(suite.T())
+	news.AssertExpectations(suite.T())
+}
+
+func (suite *PlaybackTestSuite) TestResumableStreams() {
+	a := assert.New(suite.T())
+	p := New(&config.AudioConf{SuspendBuffer: 1000}, &FactoryMock{}, "").(*play)
+	loop := &websocket.ConnectionMock{}
+	loop.On("WriteMessage", websocket.TextMessage, suspendMsg).Return(nil).Once()
+	loop.On("WriteMessage", websocket.TextMessage, resumeMsg).Return(nil).Once()
+	news := &websocket.ConnectionMock{}
+	news.On("CloseWithReason", websocket.CloseGoingAway, "").Return().Once()
+	src := newSource(&StreamContext{Priority: 1, Resumable: true}, nil)
+	jitter := newJitterBuffer(1000, 1, 10, 20, 0)
+	suspended := &session{context: src.context, connection: loop, source: src, jitter: jitter}
+	p.start(suspended)
+	p.start(&session{context: &StreamContext{Priority: 1}, connection: news})
+
+	//a resumable stream is suspended by a higher priority one instead of being stopped
+	announcement := &session{context: &StreamContext{Priority: 5}}
+	p.preempt(announcement.context)
+	p.start(announcement)
+	a.Len(p.sessions, 2)
+	a.True(src.isSuspended())
+	jitter.push(make([]int16, 500))
+	a.Equal(500, jitter.stats().Depth)
+	s := p.Status()
+	a.Equal(5, s.Stream.Priority)
+	if a.Len(s.Others, 1) {
+		a.True(s.Others[0].Suspended)
+	}
+
+	//it resumes once the announcement ends
+	p.release(announcement)
+	a.False(src.isSuspended())
+	a.Equal(1, p.PlaybackContext().Priority)
+	loop.AssertExpectations(suite.T())
+	news.AssertExpectations(suite.T())
+}
+
+func (suite *PlaybackTestSuite) TestUnsupportedFormat() {
+	c := websocket.ConnectionMock{}
+	c.On("ReadMessage").Return(textMessage, []byte(`{"priority": 2, "format": "MU_LAW"}`), nil)
+	c.On("CloseWithReason", websocket.CloseInvalidFramePayloadData, mock.AnythingOfType("string")).Return().Once()
+	pl := play{}
+	pl.PlayFromWsConnection(&c)
+	c.AssertExpectations(suite.T())
+	assert.False(suite.T(), pl.Status().Busy)
+}
+
 func (suite *PlaybackTestSuite) TestInterruptBeforeBufferFull() {
 	d := &DeviceMock{}
 	d.On("Close").Return().Once()
-	d.On("FramesWrote").Return(0)
 	f := &FactoryMock{}
 	f.On("New", mock.Anything, mock.Anything, mock.Anything).Return(d, nil).Once()
 	c := &websocket.ConnectionMock{}
@@ -77,28 +152,135 @@ func (suite *PlaybackTestSuite) TestInterruptBeforeBufferFull() {
 	str := make(chan string)
 	c.On("Control").Return(ctrl)
 	c.On("In").Return(bin, str).Once()
-	p := New(&config.AudioConf{DeviceBuffer: 2, PeriodFrames: 1, Periods: 2, ReadBuffer: 6}, f, "").(*play)
+	c.On("CloseWithReason", websocket.CloseNormalClosure, mock.AnythingOfType("string")).Run(func(args mock.Arguments) {
+		ctrl <- true
+	}).Return().Once()
+	d.On("Drain").Return().Once()
+	frames := consumeFrames(d)
+	p := New(&config.AudioConf{DeviceBuffer: 2, PeriodFrames: 1, Periods: 2, JitterTarget: 100, DeviceIdle: -1}, f, "").(*play)
 	p.PlayFromWsConnection(c)
 	time.Sleep(time.Duration(100 * time.Millisecond))
 	bin <- []byte{0x0A, 0x00, 0x01, 0x02}
-	ctrl <- true
+	//a stopped stream discards what has been buffered; none of it reached the device which is not dropped then
+	p.Stop(AnyPriority, "test")
 	time.Sleep(time.Duration(100 * time.Millisecond))
-	d.AssertNotCalled(suite.T(), "WriteAsync", mock.AnythingOfType("chan []int16"))
+	assert.Empty(suite.T(), frames.get())
+	d.AssertNotCalled(suite.T(), "Abort")
+	d.AssertExpectations(suite.T())
 	c.AssertExpectations(suite.T())
-	assert.Nil(suite.T(), p.context)
+	assert.False(suite.T(), p.Status().Busy)
 }
 
-func (suite *PlaybackTestSuite) TestDevicePlayback() {
+func (suite *PlaybackTestSuite) TestShortStream() {
 	d := &DeviceMock{}
 	d.On("Close").Return().Once()
-	d.On("FramesWrote").Return(10)
-	e := make(chan error)
+	d.On("Drain").Return().Once()
+	frames := consumeFrames(d)
+	f := &FactoryMock{}
+	f.On("New", mock.Anything, mock.Anything, mock.Anything).Return(d, nil).Once()
+	c := &websocket.ConnectionMock{}
+	c.On("ID").Return("ABCD")
+	c.On("ReadMessage").Return(textMessage, []byte(`{"priority": 2}`), nil).Once()
+	c.On("ReadLoop").Return().Once()
+	c.On("CloseWithCode", websocket.CloseNormalClosure).Return()
+	ctrl := make(chan bool, 1)
+	bin := make(chan []byte, 2)
+	str := make(chan string)
+	c.On("Control").Return(ctrl)
+	c.On("In").Return(bin, str).Once()
+	p := New(&config.AudioConf{JitterTarget: 100, FadeIn: -1, FadeOut: -1, DeviceIdle: -1}, f, "").(*play)
+	p.PlayFromWsConnection(c)
+	//the peer closes the connection right after sending a stream shorter than the playout target
+	bin <- []byte{0x0A, 0x00}
+	bin <- []byte{0x0B, 0x00}
+	ctrl <- true
+	time.Sleep(time.Duration(100 * time.Millisecond))
+	d.AssertExpectations(suite.T())
+	assert.Equal(suite.T(), [][]int16{{10, 11}}, frames.get())
+	assert.False(suite.T(), p.Status().Busy)
+}
+
+func (suite *PlaybackTestSuite) TestIntro() {
+	a := assert.New(suite.T())
+	intro, err := ioutil.TempFile("", "intro")
+	a.NoError(err)
+	defer os.Remove(intro.Name())
+	chime := make([]byte, 0, 24)
+	for i := 0; i < 12; i++ {
+		chime = append(chime, 0xE8, 0x03) //1000
+	}
+	intro.Write(wavFile(wavChunk("fmt ", wavFmt(wavFormatPCM, 1, 8000, 16)), wavChunk("data", chime)))
+	intro.Close()
+
+	//the device takes audio only once the gate is opened
+	d := &DeviceMock{}
+	frames := &frameLog{}
+	gate := make(chan bool)
 	d.On("WriteAsync", mock.AnythingOfType("chan []int16")).Run(func(args mock.Arguments) {
 		in := args.Get(0).(chan []int16)
-		for i := 0; i < 2; i++ {
-			<-in
+		go func() {
+			<-gate
+			for f := range in {
+				frames.mutex.Lock()
+				frames.frames = append(frames.frames, f)
+				frames.mutex.Unlock()
+			}
+		}()
+	}).Return(make(chan error))
+	d.On("Drain").Return().Once()
+	d.On("Close").Return().Once()
+	d.On("Xruns").Return(0)
+	f := &FactoryMock{}
+	f.On("New", 8000, 1, mock.Anything).Return(d, nil).Once()
+	c := &websocket.ConnectionMock{}
+	c.On("ID").Return("ABCD")
+	c.On("ReadMessage").Return(textMessage, []byte(`{"priority": 2, "playIntro": true, "sampleRate": 8000, "channels": 1}`), nil).Once()
+	c.On("ReadLoop").Return().Once()
+	c.On("CloseWithCode", websocket.CloseNormalClosure).Return()
+	c.On("WriteMessage", websocket.TextMessage, introStartMsg).Return(nil).Once()
+	c.On("WriteMessage", websocket.TextMessage, introEndMsg).Return(nil).Once()
+	ctrl := make(chan bool, 1)
+	bin := make(chan []byte, 1)
+	str := make(chan string)
+	c.On("Control").Return(ctrl)
+	c.On("In").Return(bin, str).Once()
+	p := New(&config.AudioConf{DeviceBuffer: 8, PeriodFrames: 4, JitterTarget: 1, FadeIn: -1, FadeOut: -1, DeviceIdle: -1}, f, intro.Name()).(*play)
+	p.PlayFromWsConnection(c)
+	//the stream is received while the intro is waiting for the device
+	stream := make([]byte, 0, 64)
+	for i := 0; i < 32; i++ {
+		stream = append(stream, 0xE8, 0x03) //1000
+	}
+	bin <- stream
+	time.Sleep(20 * time.Millisecond)
+	a.Equal(int64(64), p.Status().BytesRead)
+	a.True(p.Status().Intro)
+	c.AssertNotCalled(suite.T(), "WriteMessage", websocket.TextMessage, introStartMsg)
+	close(gate)
+	time.Sleep(20 * time.Millisecond)
+	ctrl <- true
+	time.Sleep(100 * time.Millisecond)
+	//the stream follows the intro in the same device session without a gap
+	played := frames.get()
+	if a.True(len(played) > 4) {
+		for _, fr := range played[:5] {
+			for _, v := range fr {
+				a.InDelta(1000, v, 50)
+			}
 		}
-	}).Return(e)
+	}
+	c.AssertExpectations(suite.T())
+	d.AssertExpectations(suite.T())
+	f.AssertExpectations(suite.T())
+	a.False(p.Status().Busy)
+}
+
+func (suite *PlaybackTestSuite) TestDevicePlayback() {
+	d := &DeviceMock{}
+	d.On("Close").Return().Once()
+	d.On("Drain").Return().Once()
+	d.On("Xruns").Return(1)
+	consumeFrames(d)
 	f := &FactoryMock{}
 	f.On("New", mock.Anything, mock.Anything, mock.Anything).Return(d, nil).Once()
 	c := &websocket.ConnectionMock{}
@@ -111,21 +293,241 @@ func (suite *PlaybackTestSuite) TestDevicePlayback() {
 	str := make(chan string)
 	c.On("Control").Return(ctrl)
 	c.On("In").Return(bin, str).Once()
-	p := New(&config.AudioConf{DeviceBuffer: 2, PeriodFrames: 1, Periods: 2, ReadBuffer: 2}, f, "").(*play)
+	p := New(&config.AudioConf{DeviceBuffer: 2, PeriodFrames: 1, Periods: 2, JitterTarget: 100, DeviceIdle: -1}, f, "").(*play)
 	p.PlayFromWsConnection(c)
 	bin <- []byte{0x00, 0x01}
 	bin <- []byte{0x02, 0x03}
 	bin <- []byte{0x04, 0x05}
 	bin <- []byte{0x06, 0x07}
 	time.Sleep(time.Duration(100 * time.Millisecond))
+	s := p.Status()
+	assert.True(suite.T(), s.Busy)
+	assert.Equal(suite.T(), 2, s.Stream.Priority)
+	assert.Equal(suite.T(), int64(8), s.BytesRead)
+	assert.Equal(suite.T(), 0, s.FramesWrote)
+	assert.Equal(suite.T(), 1, s.Xruns)
+	assert.False(suite.T(), s.Intro)
+	assert.WithinDuration(suite.T(), time.Now(), *s.Started, time.Second)
+	if assert.NotNil(suite.T(), s.Jitter) {
+		assert.True(suite.T(), s.Jitter.Buffering)
+		assert.Equal(suite.T(), 100, s.Jitter.Target)
+	}
 	ctrl <- true
 	time.Sleep(time.Duration(10 * time.Millisecond))
 	c.AssertExpectations(suite.T())
-	assert.Nil(suite.T(), p.context)
+	assert.False(suite.T(), p.Status().Busy)
+}
+
+func (suite *PlaybackTestSuite) TestStreamXruns() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	consumeFrames(d)
+	d.On("Drain").Return()
+	d.On("Close").Return()
+	//the device has recovered from two underruns before the stream starts
+	d.On("Xruns").Return(2).Once()
+	d.On("Xruns").Return(3)
+	f := &FactoryMock{}
+	f.On("New", 8000, 1, mock.Anything).Return(d, nil).Once()
+	p := New(&config.AudioConf{DeviceRate: 8000, DeviceChannels: 1, DeviceIdle: -1}, f, "").(*play)
+	a.NoError(p.PlaySignal(TestSignal{Type: SignalSine, Duration: 50}, &StreamContext{Priority: 1}))
+	if s := p.Status(); a.True(s.Busy) {
+		a.Equal(1, s.Xruns)
+	}
+	time.Sleep(100 * time.Millisecond)
+	a.False(p.Status().Busy)
+	f.AssertExpectations(suite.T())
+}
+
+func (suite *PlaybackTestSuite) TestVolumeChange() {
+	d := &DeviceMock{}
+	d.On("Close").Return().Once()
+	d.On("Drain").Return().Once()
+	d.On("Xruns").Return(0)
+	e := make(chan error)
+	frames := make(chan []int16, 1)
+	d.On("WriteAsync", mock.AnythingOfType("chan []int16")).Run(func(args mock.Arguments) {
+		in := args.Get(0).(chan []int16)
+		go func() {
+			//skip the resampler delay
+			for i := 0; i < 3; i++ {
+				<-in
+			}
+			frames <- <-in
+			for range in {
+			}
+		}()
+	}).Return(e)
+	f := &FactoryMock{}
+	f.On("New", 1000, 1, mock.Anything).Return(d, nil).Once()
+	c := &websocket.ConnectionMock{}
+	c.On("ID").Return("ABCD")
+	c.On("ReadMessage").Return(textMessage, []byte(`{"priority": 2, "volume": 100, "sampleRate": 1000, "channels": 1}`), nil).Once()
+	c.On("ReadLoop").Return().Once()
+	c.On("CloseWithCode", websocket.CloseNormalClosure).Return()
+	ctrl := make(chan bool)
+	bin := make(chan []byte)
+	str := make(chan string)
+	c.On("Control").Return(ctrl)
+	c.On("In").Return(bin, str).Once()
+	p := New(&config.AudioConf{JitterTarget: 1, VolumeRamp: 1, DeviceIdle: -1}, f, "").(*play)
+	p.PlayFromWsConnection(c)
+	str <- `{"type": "playback:volume", "payload": "loud"}`
+	str <- `{"type": "playback:gain", "payload": "-6.0206"}`
+	bin <- bytes.Repeat([]byte{0x10, 0x27}, 100)
+	a := assert.New(suite.T())
+	select {
+	case frame := <-frames:
+		a.Len(frame, 10)
+		for _, s := range frame {
+			a.InDelta(5000, s, 50)
+		}
+	case <-time.After(time.Second):
+		a.Fail("no frame reached the device")
+	}
+	if st := p.Status(); a.NotNil(st.Stream) {
+		a.Equal(-6.0206, st.Stream.Gain)
+		a.Equal(volume(100), st.Stream.Volume)
+	}
+	//volume 0 mutes the stream instead of resetting it to unity gain
+	str <- `{"type": "playback:volume", "payload": "0"}`
+	//the stream handles messages in order: once the next one is taken the volume has been applied
+	str <- `{"type": "playback:volume", "payload": "loud"}`
+	if st := p.Status(); a.NotNil(st.Stream) {
+		a.Equal(volume(0), st.Stream.Volume)
+		a.True(math.IsInf(st.Stream.GainDB(), -1))
+	}
+	ctrl <- true
+	time.Sleep(time.Duration(10 * time.Millisecond))
+	assert.False(suite.T(), p.Status().Busy)
+}
+
+func (suite *PlaybackTestSuite) TestPlayFile() {
+	f, err := ioutil.TempFile("", "intro")
+	a := assert.New(suite.T())
+	a.NoError(err)
+	defer os.Remove(f.Name())
+	f.Write(wavFile(wavChunk("fmt ", wavFmt(wavFormatPCM, 2, 44100, 16)), wavChunk("data", []byte{0x01, 0x00, 0x02, 0x00})))
+	f.Close()
+
+	r := &RawDeviceMock{}
+	r.On("Write", []int16{1, 2}).Return(1, nil).Once()
+	r.On("Close").Return().Once()
+	fm := &FactoryMock{}
+	fm.On("New", 44100, 2, mock.Anything).Return(NewPlaybackDevice(r, 64), nil).Once()
+	p := New(&config.AudioConf{DeviceBuffer: 64, FadeIn: -1, FadeOut: -1, DeviceIdle: -1}, fm, f.Name()).(*play)
+	a.NoError(p.PlayFile(f.Name()))
+	//the device is closed once the file has been played out
+	time.Sleep(time.Duration(10 * time.Millisecond))
+	fm.AssertExpectations(suite.T())
+	r.AssertExpectations(suite.T())
+
+	a.Error(p.PlayFile("/nonexistent/intro.wav"))
+}
+
+func (suite *PlaybackTestSuite) TestPlayClip() {
+	a := assert.New(suite.T())
+	clip := wavFile(wavChunk("fmt ", wavFmt(wavFormatPCM, 1, 8000, 16)), wavChunk("data", []byte{0x01, 0x00, 0x02, 0x00}))
+	r := &RawDeviceMock{}
+	done := make(chan bool)
+	r.On("Write", []int16{1, 2}).Return(2, nil).Once()
+	r.On("Close").Return().Run(func(args mock.Arguments) { done <- true }).Once()
+	fm := &FactoryMock{}
+	fm.On("New", 8000, 1, mock.Anything).Return(NewPlaybackDevice(r, 64), nil).Once()
+	fm.On("New", 8000, 1, mock.Anything).Return(nil, errors.New("mock error")).Once()
+	p := New(&config.AudioConf{DeviceBuffer: 64, FadeIn: -1, FadeOut: -1, DeviceIdle: -1}, fm, "").(*play)
+
+	//device busy with a higher priority stream
+	p.context = &StreamContext{Priority: 5}
+	a.Equal(ErrDeviceBusy, p.PlayClip(ioutil.NopCloser(bytes.NewReader(clip)), &StreamContext{Priority: 2}))
+	p.context = nil
+
+	a.NoError(p.PlayClip(ioutil.NopCloser(bytes.NewReader(clip)), &StreamContext{Priority: 2}))
+	<-done
+	time.Sleep(time.Duration(10 * time.Millisecond))
+	busy, _ := p.DeviceBusy()
+	a.False(busy)
+
+	err := p.PlayClip(ioutil.NopCloser(bytes.NewReader(clip)), &StreamContext{Priority: 2})
+	_, ok := err.(DeviceError)
+	a.True(ok)
+
+	err = p.PlayClip(ioutil.NopCloser(bytes.NewReader([]byte("OggS"))), &StreamContext{Priority: 2})
+	a.IsType(DecodeError{}, err)
+	//failures to read the clip are not decoding errors
+	err = p.PlayClip(ioutil.NopCloser(iotest.TimeoutReader(bytes.NewReader(clip[:20]))), &StreamContext{Priority: 2})
+	a.Equal(iotest.ErrTimeout, err)
+	fm.AssertExpectations(suite.T())
+	r.AssertExpectations(suite.T())
 }
 
 func (suite *PlaybackTestSuite) TestPlaybackInterrupt() {
+	d := &DeviceMock{}
+	d.On("Close").Return().Once()
+	//the stream is stopped before any of its audio reached the device
+	d.On("Drain").Return()
+	d.On("Xruns").Return(0)
+	consumeFrames(d)
+	f := &FactoryMock{}
+	f.On("New", mock.Anything, mock.Anything, mock.Anything).Return(d, nil).Once()
+	c := &websocket.ConnectionMock{}
+	c.On("ID").Return("ABCD")
+	c.On("ReadMessage").Return(textMessage, []byte(`{"priority": 3, "description": "Emergency"}`), nil).Once()
+	c.On("ReadLoop").Return().Once()
+	ctrl := make(chan bool, 1)
+	c.On("CloseWithReason", websocket.CloseNormalClosure, "Stream stopped by 10.0.0.1").Run(func(args mock.Arguments) {
+		ctrl <- true
+	}).Return().Once()
+	c.On("CloseWithCode", websocket.CloseNormalClosure).Return()
+	bin := make(chan []byte)
+	str := make(chan string)
+	c.On("Control").Return(ctrl)
+	c.On("In").Return(bin, str).Once()
+	p := New(&config.AudioConf{DeviceIdle: -1}, f, "").(*play)
+	a := assert.New(suite.T())
+	_, err := p.Stop(AnyPriority, "10.0.0.1")
+	a.Equal(ErrNotPlaying, err)
+
+	p.PlayFromWsConnection(c)
+	time.Sleep(time.Duration(50 * time.Millisecond))
+	_, err = p.Stop(2, "10.0.0.1")
+	a.Equal(ErrDeviceBusy, err)
+	a.True(p.Status().Busy)
+	stopped, err := p.Stop(3, "10.0.0.1")
+	a.NoError(err)
+	a.Equal("Emergency", stopped.Description)
+	a.False(p.Status().Busy)
+	time.Sleep(time.Duration(50 * time.Millisecond))
+	c.AssertExpectations(suite.T())
+	d.AssertExpectations(suite.T())
+}
+
+//frameLog collects frames written to a mocked device
+type frameLog struct {
+	mutex  sync.Mutex
+	frames [][]int16
+}
 
+func (l *frameLog) get() [][]int16 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.frames
+}
+
+//consumeFrames makes the mocked device read frames written asynchronously until the buffer is closed
+func consumeFrames(d *DeviceMock) *frameLog {
+	l := &frameLog{}
+	d.On("WriteAsync", mock.AnythingOfType("chan []int16")).Run(func(args mock.Arguments) {
+		in := args.Get(0).(chan []int16)
+		go func() {
+			for f := range in {
+				l.mutex.Lock()
+				l.frames = append(l.frames, f)
+				l.mutex.Unlock()
+			}
+		}()
+	}).Return(make(chan error))
+	return l
 }
 
 func TestPlaybackTestSuite(t *testing.T) {
@@ -0,0 +1,179 @@
+package audio
+
+import "math"
+
+const (
+	//resamplerZeros is the number of sinc zero crossings on each side of the kernel
+	resamplerZeros = 16
+	//resamplerPhases is the number of precomputed kernel phases; intermediate phases are interpolated linearly
+	resamplerPhases = 256
+	//resamplerBeta is the Kaiser window parameter (about 80 dB of stopband attenuation)
+	resamplerBeta = 8.6
+	//resamplerRolloff moves the cutoff slightly below Nyquist so that the transition band does not alias
+	resamplerRolloff = 0.95
+)
+
+/*Resampler is a streaming windowed-sinc sample rate converter for interleaved S16 samples.
+The kernel is a Kaiser windowed sinc stored as a polyphase table. When downsampling the cutoff
+follows the output Nyquist frequency. The conversion ratio may be adjusted slightly while running
+(see SetRatio) without discontinuities.
+*/
+type Resampler struct {
+	channels int
+	inRate   int
+	outRate  int
+	//step is the distance between output frames in input frames
+	step float64
+	//half is the kernel half width in input frames
+	half  int
+	table []float64
+	//buf holds input frames still needed by the kernel
+	buf []float64
+	//pos is the position of the next output frame in buf (in frames)
+	pos float64
+}
+
+//NewResampler creates a converter from 'inRate' to 'outRate' for 'channels' interleaved channels
+func NewResampler(inRate int, outRate int, channels int) *Resampler {
+	if channels < 1 {
+		channels = 1
+	}
+	r := &Resampler{channels: channels, inRate: inRate, outRate: outRate}
+	r.step = float64(inRate) / float64(outRate)
+	cutoff := resamplerRolloff
+	if outRate < inRate {
+		cutoff *= float64(outRate) / float64(inRate)
+	}
+	r.half = int(math.Ceil(resamplerZeros / cutoff))
+	r.table = resamplerKernel(r.half, cutoff)
+	//the history starts with silence so that the first output frame is centered on the first input frame
+	r.buf = make([]float64, r.half*channels)
+	r.pos = float64(r.half)
+	return r
+}
+
+//resamplerKernel tabulates h(x) = cutoff*sinc(cutoff*x)*kaiser(x/half) for every phase p/resamplerPhases
+//and tap j at x = p/resamplerPhases + half - 1 - j
+func resamplerKernel(half int, cutoff float64) []float64 {
+	taps := 2 * half
+	table := make([]float64, (resamplerPhases+1)*taps)
+	norm := besselI0(resamplerBeta)
+	for p := 0; p <= resamplerPhases; p++ {
+		for j := 0; j < taps; j++ {
+			x := float64(p)/resamplerPhases + float64(half-1-j)
+			var v float64
+			if x == 0 {
+				v = cutoff
+			} else {
+				v = math.Sin(math.Pi*cutoff*x) / (math.Pi * x)
+			}
+			w := x / float64(half)
+			if w <= -1 || w >= 1 {
+				v = 0
+			} else {
+				v *= besselI0(resamplerBeta*math.Sqrt(1-w*w)) / norm
+			}
+			table[p*taps+j] = v
+		}
+	}
+	return table
+}
+
+//besselI0 is the zeroth order modified Bessel function of the first kind
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 50; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+//SetRatio fine tunes the conversion ratio; 'ratio' multiplies the nominal output rate (e.g. 1.0001 produces 0.01% more frames)
+func (r *Resampler) SetRatio(ratio float64) {
+	r.step = float64(r.inRate) / (float64(r.outRate) * ratio)
+}
+
+//Delay returns the number of input frames buffered in the converter
+func (r *Resampler) Delay() int {
+	return len(r.buf)/r.channels - int(r.pos)
+}
+
+//Process converts 'in' and returns the output frames produced so far; partial frames in 'in' are not supported
+func (r *Resampler) Process(in []int16) []int16 {
+	for _, s := range in {
+		r.buf = append(r.buf, float64(s))
+	}
+	return r.render()
+}
+
+//Flush returns the remaining output as if the input was followed by silence
+func (r *Resampler) Flush() []int16 {
+	//enough silence to push the last input frame through the kernel
+	r.buf = append(r.buf, make([]float64, r.half*r.channels)...)
+	end := len(r.buf)/r.channels - r.half
+	out := r.renderUntil(float64(end))
+	r.buf = r.buf[:0]
+	r.buf = append(r.buf, make([]float64, r.half*r.channels)...)
+	r.pos = float64(r.half)
+	return out
+}
+
+func (r *Resampler) render() []int16 {
+	return r.renderUntil(math.Inf(1))
+}
+
+//renderUntil produces output frames for positions below 'limit' for which the whole kernel is available
+func (r *Resampler) renderUntil(limit float64) []int16 {
+	frames := len(r.buf) / r.channels
+	taps := 2 * r.half
+	var out []int16
+	if n := int((float64(frames-r.half) - r.pos) / r.step); n > 0 {
+		out = make([]int16, 0, (n+1)*r.channels)
+	}
+	for r.pos < limit {
+		i := int(r.pos)
+		if i+r.half >= frames {
+			break
+		}
+		frac := (r.pos - float64(i)) * resamplerPhases
+		p := int(frac)
+		f := frac - float64(p)
+		k0 := r.table[p*taps : (p+1)*taps]
+		k1 := r.table[(p+1)*taps : (p+2)*taps]
+		first := (i - r.half + 1) * r.channels
+		for c := 0; c < r.channels; c++ {
+			var acc float64
+			idx := first + c
+			for j := 0; j < taps; j++ {
+				acc += r.buf[idx] * (k0[j] + f*(k1[j]-k0[j]))
+				idx += r.channels
+			}
+			out = append(out, clampS16(acc))
+		}
+		r.pos += r.step
+	}
+	//drop history the kernel will not reach anymore
+	if drop := int(r.pos) - r.half + 1; drop > 0 {
+		if drop > frames {
+			drop = frames
+		}
+		r.buf = append(r.buf[:0], r.buf[drop*r.channels:]...)
+		r.pos -= float64(drop)
+	}
+	return out
+}
+
+func clampS16(v float64) int16 {
+	v = math.Floor(v + .5)
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
@@ -0,0 +1,116 @@
+package audio
+
+import (
+	"math"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ResampleTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ResampleTestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func sine(freq float64, rate int, frames int, channels int, amp float64) []int16 {
+	out := make([]int16, frames*channels)
+	for i := 0; i < frames; i++ {
+		v := int16(math.Floor(amp*math.Sin(2*math.Pi*freq*float64(i)/float64(rate)) + .5))
+		for c := 0; c < channels; c++ {
+			out[i*channels+c] = v
+		}
+	}
+	return out
+}
+
+//sineError returns the RMS difference (relative to full scale) between 'got' (channel 'ch') and the ideal sine;
+//edges affected by the kernel start up are skipped
+func sineError(got []int16, freq float64, rate int, channels int, ch int, amp float64, skip int) float64 {
+	var sum float64
+	frames := len(got) / channels
+	n := 0
+	for i := skip; i < frames-skip; i++ {
+		ideal := amp * math.Sin(2*math.Pi*freq*float64(i)/float64(rate))
+		d := float64(got[i*channels+ch]) - ideal
+		sum += d * d
+		n++
+	}
+	return math.Sqrt(sum/float64(n)) / 32768
+}
+
+func (suite *ResampleTestSuite) TestUpsample() {
+	a := assert.New(suite.T())
+	in := sine(1000, 22050, 22050, 2, 16000)
+	r := NewResampler(22050, 48000, 2)
+	var out []int16
+	//feed in uneven chunks as the websocket would
+	for i := 0; i < len(in); {
+		n := 2 * (100 + i%317)
+		if i+n > len(in) {
+			n = len(in) - i
+		}
+		out = append(out, r.Process(in[i:i+n])...)
+		i += n
+	}
+	out = append(out, r.Flush()...)
+	a.InDelta(48000*2, len(out), 2)
+	for ch := 0; ch < 2; ch++ {
+		e := sineError(out, 1000, 48000, 2, ch, 16000, 100)
+		a.True(e < 1e-3, "channel %d error %f", ch, e)
+	}
+}
+
+func (suite *ResampleTestSuite) TestDownsample() {
+	a := assert.New(suite.T())
+	r := NewResampler(48000, 22050, 1)
+	//a tone above the output Nyquist frequency must be removed
+	out := append(r.Process(sine(15000, 48000, 48000, 1, 16000)), r.Flush()...)
+	a.InDelta(22050, len(out), 1)
+	var peak float64
+	for _, v := range out[200 : len(out)-200] {
+		peak = math.Max(peak, math.Abs(float64(v)))
+	}
+	a.True(peak < 16, "aliased tone with peak %f", peak)
+
+	r = NewResampler(48000, 22050, 1)
+	out = append(r.Process(sine(440, 48000, 48000, 1, 16000)), r.Flush()...)
+	e := sineError(out, 440, 22050, 1, 0, 16000, 100)
+	a.True(e < 1e-3, "error %f", e)
+}
+
+func (suite *ResampleTestSuite) TestRatio() {
+	a := assert.New(suite.T())
+	r := NewResampler(48000, 48000, 1)
+	r.SetRatio(1.001)
+	out := append(r.Process(make([]int16, 48000)), r.Flush()...)
+	a.InDelta(48048, len(out), 1)
+}
+
+func (suite *ResampleTestSuite) TestPipeline() {
+	a := assert.New(suite.T())
+	p := newPipeline(22050, 22050, 2, nil, nil)
+	//partial frames wait for the rest of the frame
+	a.Equal([]int16{1, 2}, p.process([]int16{1, 2, 3}))
+	a.Equal([]int16{3, 4}, p.process([]int16{4}))
+	a.Nil(p.flush())
+	p = newPipeline(0, 48000, 1, nil, nil)
+	a.Nil(p.resampler)
+	p.setRatio(1.1)
+
+	//an adjustable pipeline resamples even at the same rate so that drift can be compensated
+	p = newPipeline(48000, 48000, 2, nil, nil)
+	p.adjustable()
+	p.setRatio(1.001)
+	out := p.process(make([]int16, 2*48000))
+	out = append(out, p.flush()...)
+	a.InDelta(2*48048, len(out), 4)
+}
+
+func TestResampleTestSuite(t *testing.T) {
+	suite.Run(t, new(ResampleTestSuite))
+}
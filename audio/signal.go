@@ -0,0 +1,292 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//SignalType names one of the test signals generated by PlaySignal
+type SignalType string
+
+//Supported test signals
+const (
+	SignalSine     SignalType = "sine"     //steady tone at Frequency
+	SignalSweep    SignalType = "sweep"    //logarithmic sweep from Frequency to To
+	SignalPink     SignalType = "pink"     //pink noise (-3 dB per octave)
+	SignalWhite    SignalType = "white"    //white noise
+	SignalChannels SignalType = "channels" //beeps at Frequency on every device channel in turn: one on the first, two on the second...
+)
+
+const (
+	//MaxSignalDuration is the longest test signal in ms
+	MaxSignalDuration = 60000
+	//MaxSignalLevel is the highest peak level of test signals in dBFS; it protects speakers from full scale noise
+	MaxSignalLevel = -6.0
+	//defaultSignalDuration is in ms
+	defaultSignalDuration = 5000
+	//defaultSignalLevel is in dBFS
+	defaultSignalLevel     = -20.0
+	defaultSignalFrequency = 1000
+	defaultSweepFrom       = 20
+	defaultSweepTo         = 20000
+	//signalEdge is the ramp at the start and end of test signals (and of identification beeps) in ms
+	signalEdge = 10
+	//identSlot is the shortest time given to a channel by the channel identification in ms; identBeep and
+	//identGap are the durations of its beeps and of the pauses between them
+	identSlot = 1500
+	identBeep = 150
+	identGap  = 100
+)
+
+//TestSignal describes a generated test signal; zero values are replaced by defaults so callers taking the fields
+//from users have to refuse an explicit 0 (a duration of 0 plays for 5 s)
+type TestSignal struct {
+	Type      SignalType `json:"type"`
+	Frequency float64    `json:"frequency"` //in Hz; the start of sweeps; 1000 by default (20 for sweeps)
+	To        float64    `json:"to"`        //end of sweeps in Hz; 20000 by default
+	Level     float64    `json:"level"`     //peak level in dBFS up to MaxSignalLevel; -20 by default
+	Duration  int        `json:"duration"`  //in ms up to MaxSignalDuration; 5000 by default
+}
+
+//withDefaults returns the signal with zero values replaced by defaults
+func (s TestSignal) withDefaults() TestSignal {
+	if s.Frequency == 0 {
+		s.Frequency = defaultSignalFrequency
+		if s.Type == SignalSweep {
+			s.Frequency = defaultSweepFrom
+		}
+	}
+	if s.To == 0 {
+		s.To = defaultSweepTo
+	}
+	if s.Level == 0 {
+		s.Level = defaultSignalLevel
+	}
+	if s.Duration == 0 {
+		s.Duration = defaultSignalDuration
+	}
+	return s
+}
+
+//Validate checks the signal against the supported types and the duration, level and frequency limits
+func (s TestSignal) Validate() error {
+	s = s.withDefaults()
+	switch s.Type {
+	case SignalSine, SignalSweep, SignalPink, SignalWhite, SignalChannels:
+	default:
+		return fmt.Errorf("unknown test signal %s", s.Type)
+	}
+	if s.Duration < 0 || s.Duration > MaxSignalDuration {
+		return fmt.Errorf("duration %d ms out of range; expected up to %d ms", s.Duration, MaxSignalDuration)
+	}
+	if s.Level > MaxSignalLevel {
+		return fmt.Errorf("level %.1f dBFS out of range; expected up to %.1f dBFS", s.Level, MaxSignalLevel)
+	}
+	if s.Frequency < 20 || s.Frequency > 20000 || s.To < 20 || s.To > 20000 {
+		return fmt.Errorf("frequency out of range; expected 20-20000 Hz")
+	}
+	return nil
+}
+
+func (s TestSignal) String() string {
+	s = s.withDefaults()
+	switch s.Type {
+	case SignalSine, SignalChannels:
+		return fmt.Sprintf("%s %.0f Hz at %.1f dBFS", s.Type, s.Frequency, s.Level)
+	case SignalSweep:
+		return fmt.Sprintf("%s %.0f-%.0f Hz at %.1f dBFS", s.Type, s.Frequency, s.To, s.Level)
+	}
+	return fmt.Sprintf("%s noise at %.1f dBFS", s.Type, s.Level)
+}
+
+/*PlaySignal plays the test signal with the given stream context. The priority check is the same as for clips;
+the signal is generated in the format of the device and playback continues in the background until the end
+of the signal or until it is stopped (see Stop).
+*/
+func (p *play) PlaySignal(signal TestSignal, context *StreamContext) error {
+	p.connMutex.Lock()
+	defer p.connMutex.Unlock()
+
+	if err := signal.Validate(); err != nil {
+		return err
+	}
+	if !p.admit(context) {
+		return ErrDeviceBusy
+	}
+	d, err := p.bus.acquire(0, 0)
+	if err != nil {
+		return err
+	}
+	src, err := p.newSignalSource(signal, context, d)
+	if err != nil {
+		p.bus.cancel(d)
+		return err
+	}
+	p.preempt(context)
+	if err = p.bus.add(src, d); err != nil {
+		return err
+	}
+	s := &session{context: context, source: src}
+	context.started = time.Now()
+	p.start(s)
+	go p.doPlaySignal(s)
+	return nil
+}
+
+func (p *play) doPlaySignal(s *session) {
+	defer p.cleanup(nil, s)
+	if log.GetLevel() >= log.InfoLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlaySignal", "description": s.context.Description}).
+			Info("Starting test signal playback")
+	}
+	if err := s.source.wait(); err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "doPlaySignal", "description": s.context.Description}).
+			WithError(err).Error("Could not play test signal")
+	}
+}
+
+//newSignalSource creates the source of the test signal with 'context' to be played on the device 'd'
+func (p *play) newSignalSource(signal TestSignal, context *StreamContext, d *busDevice) (*source, error) {
+	signal = signal.withDefaults()
+	if nyquist := float64(d.rate) / 2; signal.Frequency >= nyquist || (signal.Type == SignalSweep && signal.To >= nyquist) {
+		return nil, fmt.Errorf("frequency out of range; the device plays up to %.0f Hz", nyquist)
+	}
+	//signals other than the channel identification are mono until the pipeline maps them to the device channels
+	context.SampleRate = d.rate
+	context.Channels = 1
+	mapper := p.newMapper(1, d.channels)
+	if signal.Type == SignalChannels {
+		context.Channels = d.channels
+		mapper = nil
+	}
+	return newSource(context, newSignalInput(signal, d.rate, newPipeline(d.rate, d.rate, context.Channels, p.newGain(context), mapper))), nil
+}
+
+/*signalInput generates a test signal of a fixed length at the rate of the bus and runs it through 'pipe' which
+applies the stream gain. Signals other than the channel identification are mono and mapped to the device
+channels by the pipeline.
+*/
+type signalInput struct {
+	signal   TestSignal
+	rate     int
+	channels int
+	pipe     *pipeline
+	//amp is the peak sample value
+	amp float64
+	//frames is the length of the signal, pos the next frame and edge the length of ramps in frames
+	frames int
+	pos    int
+	edge   int
+	phase  float64
+	noise  *rand.Rand
+	//pink holds the state of the pink noise filter
+	pink [7]float64
+}
+
+func newSignalInput(signal TestSignal, rate int, pipe *pipeline) *signalInput {
+	return &signalInput{
+		signal:   signal,
+		rate:     rate,
+		channels: pipe.channels,
+		pipe:     pipe,
+		amp:      32767 * DBToLinear(signal.Level),
+		frames:   msToFrames(signal.Duration, rate),
+		edge:     fadeFrames(signalEdge, rate),
+		noise:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (g *signalInput) read(max int) ([]int16, bool) {
+	n := max / g.pipe.outChannels()
+	if n > g.frames-g.pos {
+		n = g.frames - g.pos
+	}
+	samples := make([]int16, n*g.channels)
+	for i := 0; i < n; i++ {
+		g.frame(samples[i*g.channels : (i+1)*g.channels])
+		g.pos++
+	}
+	return g.pipe.process(samples), g.pos < g.frames
+}
+
+//frame fills 'out' with the frame at the current position
+func (g *signalInput) frame(out []int16) {
+	env := 1.0
+	if g.pos < g.edge {
+		env = float64(g.pos) / float64(g.edge)
+	}
+	if left := g.frames - g.pos - 1; left < g.edge {
+		env *= float64(left) / float64(g.edge)
+	}
+	switch g.signal.Type {
+	case SignalSine:
+		out[0] = int16(g.amp * env * g.tone(g.signal.Frequency))
+	case SignalSweep:
+		t := float64(g.pos) / float64(g.frames)
+		out[0] = int16(g.amp * env * g.tone(g.signal.Frequency*math.Pow(g.signal.To/g.signal.Frequency, t)))
+	case SignalWhite:
+		out[0] = int16(g.amp * env * (2*g.noise.Float64() - 1))
+	case SignalPink:
+		out[0] = int16(g.amp * env * g.pinkNoise())
+	case SignalChannels:
+		g.ident(out, env)
+	}
+}
+
+//tone returns the next sample of a sine at 'f' Hz; the phase is accumulated so that sweeps stay continuous
+func (g *signalInput) tone(f float64) float64 {
+	v := math.Sin(g.phase)
+	if g.phase += 2 * math.Pi * f / float64(g.rate); g.phase >= 2*math.Pi {
+		g.phase -= 2 * math.Pi
+	}
+	return v
+}
+
+//pinkNoise filters white noise with Paul Kellet's refined filter; the result is kept within [-1, 1]
+func (g *signalInput) pinkNoise() float64 {
+	w := 2*g.noise.Float64() - 1
+	b := &g.pink
+	b[0] = 0.99886*b[0] + w*0.0555179
+	b[1] = 0.99332*b[1] + w*0.0750759
+	b[2] = 0.96900*b[2] + w*0.1538520
+	b[3] = 0.86650*b[3] + w*0.3104856
+	b[4] = 0.55000*b[4] + w*0.5329522
+	b[5] = -0.7616*b[5] - w*0.0168980
+	v := (b[0] + b[1] + b[2] + b[3] + b[4] + b[5] + b[6] + w*0.5362) * 0.11
+	b[6] = w * 0.115926
+	return math.Max(-1, math.Min(1, v))
+}
+
+//ident fills 'out' with the channel identification: the channel of the current slot beeps as many times
+//as its number while the others are silent
+func (g *signalInput) ident(out []int16, env float64) {
+	period := msToFrames(identBeep+identGap, g.rate)
+	slot := identSlotFrames(period, g.channels, g.rate)
+	ch := (g.pos / slot) % g.channels
+	pos := g.pos % slot
+	beep := msToFrames(identBeep, g.rate)
+	v := g.tone(g.signal.Frequency)
+	if pos/period > ch || pos%period >= beep {
+		return
+	}
+	if at := pos % period; at < g.edge {
+		env *= float64(at) / float64(g.edge)
+	} else if beep-at-1 < g.edge {
+		env *= float64(beep-at-1) / float64(g.edge)
+	}
+	out[ch] = int16(g.amp * env * v)
+}
+
+//identSlotFrames returns the length of the channel identification slots: the last of 'channels' channels
+//has to fit all its beeps (of 'period' frames with the pause) and a pause of one period before the next slot
+func identSlotFrames(period int, channels int, rate int) int {
+	slot := msToFrames(identSlot, rate)
+	if n := (channels + 1) * period; n > slot {
+		return n
+	}
+	return slot
+}
@@ -0,0 +1,154 @@
+package audio
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type SignalTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SignalTestSuite) SetupSuite() {
+	log.SetLevel(log.ErrorLevel)
+}
+
+func (suite *SignalTestSuite) TearDownSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+//readSignal reads the whole signal at 8 kHz with 'channels' channels
+func readSignal(signal TestSignal, channels int) []int16 {
+	g := newSignalInput(signal.withDefaults(), 8000, newPipeline(8000, 8000, channels, nil, nil))
+	var out []int16
+	for {
+		s, ok := g.read(128)
+		out = append(out, s...)
+		if !ok {
+			return out
+		}
+	}
+}
+
+func (suite *SignalTestSuite) TestValidate() {
+	a := assert.New(suite.T())
+	a.NoError(TestSignal{Type: SignalSine}.Validate())
+	a.NoError(TestSignal{Type: SignalSweep, Frequency: 100, To: 10000, Level: -6, Duration: MaxSignalDuration}.Validate())
+	a.Error(TestSignal{Type: "square"}.Validate())
+	a.Error(TestSignal{Type: SignalPink, Duration: MaxSignalDuration + 1}.Validate())
+	a.Error(TestSignal{Type: SignalPink, Level: -3}.Validate())
+	a.Error(TestSignal{Type: SignalSine, Frequency: 10}.Validate())
+	a.Error(TestSignal{Type: SignalSweep, To: 30000}.Validate())
+	a.Equal("sweep 20-20000 Hz at -20.0 dBFS", TestSignal{Type: SignalSweep}.String())
+	a.Equal("pink noise at -12.0 dBFS", TestSignal{Type: SignalPink, Level: -12}.String())
+}
+
+func (suite *SignalTestSuite) TestGenerators() {
+	a := assert.New(suite.T())
+	peak := 32767 * DBToLinear(-12)
+
+	out := readSignal(TestSignal{Type: SignalSine, Frequency: 500, Level: -12, Duration: 1000}, 1)
+	a.Len(out, 8000)
+	a.InDelta(1000, crossings(out), 2)
+	//the signal ramps in and out
+	a.Equal(int16(0), out[0])
+	a.Equal(int16(0), out[7999])
+
+	out = readSignal(TestSignal{Type: SignalSweep, Frequency: 100, To: 3200, Level: -12, Duration: 1000}, 1)
+	low, high := crossings(out[:1600]), crossings(out[6400:])
+	a.True(low*8 < high, "no sweep: %d crossings at the start and %d at the end", low, high)
+
+	for _, t := range []SignalType{SignalWhite, SignalPink} {
+		out = readSignal(TestSignal{Type: t, Level: -12, Duration: 1000}, 1)
+		a.Len(out, 8000)
+		var max int16
+		for _, s := range out {
+			if s > max {
+				max = s
+			}
+			if s < -max {
+				max = -s
+			}
+		}
+		a.True(float64(max) <= peak+1, "%s noise above the level: %d", t, max)
+		a.True(float64(max) > peak/4, "%s noise too weak: %d", t, max)
+	}
+	//pink noise has less energy in high frequencies than white noise
+	a.True(crossings(readSignal(TestSignal{Type: SignalPink, Duration: 1000}, 1)) < crossings(readSignal(TestSignal{Type: SignalWhite, Duration: 1000}, 1))/2)
+}
+
+func (suite *SignalTestSuite) TestChannelIdentification() {
+	a := assert.New(suite.T())
+	channels := 2
+	out := readSignal(TestSignal{Type: SignalChannels, Duration: 3000}, channels)
+	a.Len(out, 2*24000)
+	//beeps counts the beeps of channel 'ch' between frames 'from' and 'to'
+	beeps := func(ch int, from int, to int) int {
+		n := 0
+		on := false
+		for i := from; i < to; i++ {
+			s := out[channels*i+ch]
+			if s != 0 && !on {
+				n++
+			}
+			//a single zero sample within a beep does not end it
+			on = s != 0 || (on && out[channels*(i+1)+ch] != 0)
+		}
+		return n
+	}
+	a.Equal(1, beeps(0, 0, 12000))
+	a.Equal(0, beeps(1, 0, 12000))
+	a.Equal(0, beeps(0, 12000, 23999))
+	a.Equal(2, beeps(1, 12000, 23999))
+
+	//the slots grow so that every channel of a large layout beeps its number of times
+	channels = 8
+	out = readSignal(TestSignal{Type: SignalChannels, Duration: 20000}, channels)
+	slot := identSlotFrames(msToFrames(identBeep+identGap, 8000), 8, 8000)
+	a.Equal(9*2000, slot)
+	a.Equal(8, beeps(7, 7*slot, 8*slot))
+	a.Equal(0, beeps(6, 7*slot, 8*slot))
+}
+
+func (suite *SignalTestSuite) TestPlaySignal() {
+	a := assert.New(suite.T())
+	d := &DeviceMock{}
+	frames := consumeFrames(d)
+	d.On("Drain").Return()
+	d.On("Abort").Return()
+	d.On("Close").Return()
+	d.On("Xruns").Return(0)
+	fm := &FactoryMock{}
+	fm.On("New", 8000, 1, mock.Anything).Return(d, nil).Once()
+	conf := &config.AudioConf{DeviceRate: 8000, DeviceChannels: 1, FadeIn: -1, FadeOut: -1, DeviceIdle: -1}
+	p := New(conf, fm, "").(*play)
+
+	//the signal respects the priority of the stream playing
+	p.context = &StreamContext{Priority: 5}
+	a.Equal(ErrDeviceBusy, p.PlaySignal(TestSignal{Type: SignalSine}, &StreamContext{Priority: 2}))
+	p.context = nil
+	a.Error(p.PlaySignal(TestSignal{Type: SignalSine, Level: 0.5}, &StreamContext{}))
+	//frequencies the device cannot play are refused
+	a.Error(p.PlaySignal(TestSignal{Type: SignalSine, Frequency: 5000}, &StreamContext{}))
+
+	a.NoError(p.PlaySignal(TestSignal{Type: SignalSine, Duration: 20}, &StreamContext{Description: "Test", Type: "signal"}))
+	a.True(p.Status().Busy)
+	time.Sleep(50 * time.Millisecond)
+	a.False(p.Status().Busy)
+	n := 0
+	for _, f := range frames.get() {
+		n += len(f)
+	}
+	a.Equal(160, n)
+	fm.AssertExpectations(suite.T())
+}
+
+func TestSignalTestSuite(t *testing.T) {
+	suite.Run(t, new(SignalTestSuite))
+}
@@ -0,0 +1,154 @@
+package audio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const defaultVolumeFile = "/var/lib/husar/volume.json"
+
+//VolumeControl sets the overall output level of the device on the 0-100 scale.
+//It is implemented by hardware mixers (see Mixer) and by the software Master gain.
+type VolumeControl interface {
+	Volume() (int, error)
+	SetVolume(volume int) error
+}
+
+//Master is a software master gain applied on top of stream gains. It is used when no hardware mixer is available.
+type Master struct {
+	mutex   sync.RWMutex
+	volume  int
+	version uint64
+}
+
+//NewMaster creates a software master gain at 'volume' (0-100)
+func NewMaster(volume int) *Master {
+	return &Master{volume: volume}
+}
+
+//Volume returns the current master volume
+func (m *Master) Volume() (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.volume, nil
+}
+
+//SetVolume changes the master volume; playing streams ramp to the new level
+func (m *Master) SetVolume(volume int) error {
+	if volume < 0 || volume > 100 {
+		return fmt.Errorf("volume %d out of range 0-100", volume)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.volume = volume
+	m.version++
+	return nil
+}
+
+//state returns the master gain in dB along with a counter that changes on every update
+func (m *Master) state() (float64, uint64) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return VolumeToDB(m.volume), m.version
+}
+
+/*NewVolumeControl picks the control of the output level: the hardware mixer returned by 'open' is preferred;
+the software 'master' gain is used when there is no mixer to open ('open' is nil), when it cannot be opened
+or when its element has no playback volume.
+*/
+func NewVolumeControl(open func() (Mixer, error), master *Master) VolumeControl {
+	if open == nil {
+		return master
+	}
+	m, err := open()
+	if err == nil {
+		if _, err = m.Volume(); err != nil {
+			m.Close()
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "NewVolumeControl"}).
+			WithError(err).Warn("Could not use hardware mixer. Fallback to software volume control.")
+		return master
+	}
+	return m
+}
+
+//persistentVolume stores every level change so that it can be restored after a restart
+type persistentVolume struct {
+	mutex sync.Mutex
+	v     VolumeControl
+	path  string
+}
+
+type volumeState struct {
+	Volume int `json:"volume"`
+}
+
+/*NewPersistentVolume wraps 'v' so that the level is saved in 'path' on every change.
+The level saved previously (if any) is applied straight away. Problems with the state file are logged
+and do not prevent volume control from working.
+*/
+func NewPersistentVolume(v VolumeControl, path string) VolumeControl {
+	if path == "" {
+		path = defaultVolumeFile
+	}
+	p := &persistentVolume{v: v, path: path}
+	clog := log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "NewPersistentVolume", "path": path})
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		clog.Info("No saved volume level found")
+		return p
+	}
+	if err != nil {
+		clog.WithError(err).Warn("Could not read saved volume level")
+		return p
+	}
+	var s volumeState
+	if err = json.Unmarshal(data, &s); err != nil {
+		clog.WithError(err).Warn("Could not decode saved volume level")
+		return p
+	}
+	if err = v.SetVolume(s.Volume); err != nil {
+		clog.WithError(err).Warn("Could not restore saved volume level")
+		return p
+	}
+	clog.WithField("volume", s.Volume).Info("Restored saved volume level")
+	return p
+}
+
+func (p *persistentVolume) Volume() (int, error) {
+	return p.v.Volume()
+}
+
+func (p *persistentVolume) SetVolume(volume int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err := p.v.SetVolume(volume); err != nil {
+		return err
+	}
+	if err := p.save(volume); err != nil {
+		//the level is already applied; losing it on restart is not worth failing the request
+		log.WithFields(log.Fields{"logger": "audio-endpoint.audio", "method": "SetVolume", "path": p.path}).
+			WithError(err).Warn("Could not save volume level")
+	}
+	return nil
+}
+
+func (p *persistentVolume) save(volume int) error {
+	data, _ := json.Marshal(volumeState{volume})
+	if err := os.MkdirAll(filepath.Dir(p.path), 0755); err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
@@ -0,0 +1,103 @@
+package audio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type VolumeTestSuite struct {
+	suite.Suite
+}
+
+func (suite *VolumeTestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func (suite *VolumeTestSuite) TestMasterGain() {
+	a := assert.New(suite.T())
+	m := NewMaster(100)
+	a.Error(m.SetVolume(101))
+	g := NewGain(-6.0206, 1, 10)
+	g.follow(m)
+	buf := []int16{10000}
+	g.Process(buf)
+	a.Equal(int16(5000), buf[0])
+
+	//master changes are picked up by playing streams and ramped
+	a.NoError(m.SetVolume(0))
+	buf = make([]int16, 20)
+	for i := range buf {
+		buf[i] = 10000
+	}
+	g.Process(buf)
+	a.Equal(int16(4500), buf[0])
+	a.Equal(int16(0), buf[19])
+
+	a.NoError(m.SetVolume(100))
+	g.SetDB(0)
+	buf = make([]int16, 20)
+	for i := range buf {
+		buf[i] = 10000
+	}
+	g.Process(buf)
+	a.Equal(int16(10000), buf[19])
+}
+
+func (suite *VolumeTestSuite) TestPersistentVolume() {
+	dir, err := ioutil.TempDir("", "volume")
+	a := assert.New(suite.T())
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "volume.json")
+
+	//nothing saved yet; the control is left untouched
+	m := &MixerMock{}
+	v := NewPersistentVolume(m, path)
+	m.On("SetVolume", 30).Return(nil).Once()
+	m.On("SetVolume", 90).Return(fmt.Errorf("mock error")).Once()
+	a.NoError(v.SetVolume(30))
+	a.Error(v.SetVolume(90))
+	m.AssertExpectations(suite.T())
+
+	//the last level that was applied successfully is restored
+	master := NewMaster(100)
+	v = NewPersistentVolume(master, path)
+	vol, err := v.Volume()
+	a.NoError(err)
+	a.Equal(30, vol)
+
+	ioutil.WriteFile(path, []byte("garbage"), 0644)
+	master = NewMaster(100)
+	NewPersistentVolume(master, path)
+	vol, _ = master.Volume()
+	a.Equal(100, vol)
+}
+
+func (suite *VolumeTestSuite) TestVolumeControlFallback() {
+	a := assert.New(suite.T())
+	master := NewMaster(100)
+	//without a mixer (e.g. a non ALSA backend) the software gain is used
+	a.Equal(master, NewVolumeControl(nil, master))
+
+	m := &MixerMock{}
+	open := func() (Mixer, error) { return m, nil }
+	a.Equal(master, NewVolumeControl(func() (Mixer, error) { return nil, fmt.Errorf("mock error") }, master))
+	//an element without playback volume is closed and replaced by the software gain
+	m.On("Volume").Return(0, ErrNoVolumeControl).Once()
+	m.On("Close").Return().Once()
+	a.Equal(master, NewVolumeControl(open, master))
+	m.On("Volume").Return(40, nil).Once()
+	a.Equal(m, NewVolumeControl(open, master))
+	m.AssertExpectations(suite.T())
+}
+
+func TestVolumeTestSuite(t *testing.T) {
+	suite.Run(t, new(VolumeTestSuite))
+}
@@ -0,0 +1,766 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+//ErrNotVorbis is returned when the Ogg stream does not carry Vorbis audio
+var ErrNotVorbis = errors.New("Ogg stream does not contain Vorbis audio")
+
+//floor1InverseDB is the floor1_inverse_dB_table from the specification; its entries form a geometric series
+var floor1InverseDB = func() (t [256]float32) {
+	ratio := math.Pow(1/1.0649863e-07, 1.0/255)
+	v := 1.0649863e-07
+	for i := range t {
+		t[i] = float32(v)
+		v *= ratio
+	}
+	t[255] = 1
+	return
+}()
+
+//vorbisDecoder implements the Vorbis I audio decoding process
+type vorbisDecoder struct {
+	channels   int
+	sampleRate int
+	blocksize  [2]int
+	books      []*codebook
+	floors     []interface{}
+	residues   []*residue
+	mappings   []*mapping
+	modes      []mode
+	imdct      [2]*imdct
+	windows    map[int][]float32
+
+	//state carried between audio packets
+	prev      [][]float32
+	prevSize  int
+	prevValid bool
+	//scratch buffers
+	residue [][]float32
+	floor   [][]float32
+	classes [][]int
+}
+
+func (d *vorbisDecoder) readIdentification(p []byte) error {
+	if len(p) < 30 || p[0] != 1 || string(p[1:7]) != "vorbis" {
+		return ErrNotVorbis
+	}
+	b := &bitReader{data: p[7:]}
+	if v := b.read(32); v != 0 {
+		return fmt.Errorf("unsupported Vorbis version %d", v)
+	}
+	d.channels = int(b.read(8))
+	d.sampleRate = int(b.read(32))
+	b.read(32)
+	b.read(32)
+	b.read(32)
+	d.blocksize[0] = 1 << b.read(4)
+	d.blocksize[1] = 1 << b.read(4)
+	if d.channels == 0 || d.sampleRate == 0 || d.blocksize[0] < 64 || d.blocksize[1] > 8192 || d.blocksize[0] > d.blocksize[1] || !b.readFlag() {
+		return errors.New("invalid Vorbis identification header")
+	}
+	return nil
+}
+
+func (d *vorbisDecoder) readSetup(p []byte) (err error) {
+	if len(p) < 7 || p[0] != 5 || string(p[1:7]) != "vorbis" {
+		return errors.New("missing Vorbis setup header")
+	}
+	//a header running out of data or inconsistent enough to fail at runtime (e.g. an index out of range) is invalid
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(error); ok {
+				err = errVorbisSetup
+				return
+			}
+			panic(r)
+		}
+	}()
+	b := &bitReader{data: p[7:]}
+
+	d.books = make([]*codebook, b.read(8)+1)
+	for i := range d.books {
+		if d.books[i], err = readCodebook(b); err != nil {
+			return err
+		}
+	}
+	for i := b.read(6) + 1; i > 0; i-- {
+		if b.read(16) != 0 {
+			return errors.New("invalid Vorbis time domain transform")
+		}
+	}
+	d.floors = make([]interface{}, b.read(6)+1)
+	for i := range d.floors {
+		switch t := b.read(16); t {
+		case 0:
+			d.floors[i], err = readFloor0(b, len(d.books))
+		case 1:
+			d.floors[i], err = readFloor1(b, len(d.books))
+		default:
+			err = fmt.Errorf("unsupported Vorbis floor type %d", t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	d.residues = make([]*residue, b.read(6)+1)
+	for i := range d.residues {
+		t := int(b.read(16))
+		if t > 2 {
+			return fmt.Errorf("unsupported Vorbis residue type %d", t)
+		}
+		if d.residues[i], err = readResidue(b, t, len(d.books)); err != nil {
+			return err
+		}
+	}
+	d.mappings = make([]*mapping, b.read(6)+1)
+	for i := range d.mappings {
+		if d.mappings[i], err = readMapping(b, d.channels, len(d.floors), len(d.residues)); err != nil {
+			return err
+		}
+	}
+	d.modes = make([]mode, b.read(6)+1)
+	for i := range d.modes {
+		d.modes[i].blockflag = b.readFlag()
+		if b.read(16) != 0 || b.read(16) != 0 {
+			return errors.New("invalid Vorbis mode window or transform type")
+		}
+		if d.modes[i].mapping = int(b.read(8)); d.modes[i].mapping >= len(d.mappings) {
+			return errVorbisSetup
+		}
+	}
+	if !b.readFlag() {
+		return errors.New("missing Vorbis setup framing bit")
+	}
+
+	d.imdct[0] = newIMDCT(d.blocksize[0])
+	d.imdct[1] = newIMDCT(d.blocksize[1])
+	d.windows = make(map[int][]float32)
+	d.prev = make([][]float32, d.channels)
+	d.residue = make([][]float32, d.channels)
+	d.floor = make([][]float32, d.channels)
+	d.classes = make([][]int, d.channels)
+	for i := 0; i < d.channels; i++ {
+		d.prev[i] = make([]float32, d.blocksize[1])
+		d.residue[i] = make([]float32, d.blocksize[1])
+		d.floor[i] = make([]float32, d.blocksize[1]/2)
+		d.classes[i] = make([]int, d.blocksize[1])
+	}
+	return nil
+}
+
+//decode decodes a single audio packet and returns planar samples ready for output.
+//The first packet of a stream only primes the overlap buffer and yields no samples.
+func (d *vorbisDecoder) decode(p []byte) (out [][]float32, err error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if r == errEndOfPacket {
+				//a truncated packet is ignored as allowed by the specification
+				out, err = nil, nil
+				return
+			}
+			if e, ok := r.(error); ok {
+				out, err = nil, e
+				return
+			}
+			panic(r)
+		}
+	}()
+	b := &bitReader{data: p}
+	if b.readFlag() {
+		//not an audio packet
+		return nil, nil
+	}
+	m := d.modes[b.read(ilog(len(d.modes)-1))]
+	flag := 0
+	if m.blockflag {
+		flag = 1
+	}
+	n := d.blocksize[flag]
+	prevFlag, nextFlag := false, false
+	if m.blockflag {
+		prevFlag = b.readFlag()
+		nextFlag = b.readFlag()
+	}
+	mp := d.mappings[m.mapping]
+	half := n / 2
+
+	//floor curves
+	noResidue := make([]bool, d.channels)
+	for ch := 0; ch < d.channels; ch++ {
+		floor := d.floor[ch][:half]
+		switch f := d.floors[mp.floors[mp.mux[ch]]].(type) {
+		case *floor0:
+			noResidue[ch] = !d.decodeFloor0(f, b, floor)
+		case *floor1:
+			noResidue[ch] = !d.decodeFloor1(f, b, floor)
+		}
+	}
+	//coupled channels are decoded together if any of them carries energy
+	for _, c := range mp.coupling {
+		if !noResidue[c.magnitude] || !noResidue[c.angle] {
+			noResidue[c.magnitude] = false
+			noResidue[c.angle] = false
+		}
+	}
+
+	//residues
+	for i := range mp.residues {
+		var vectors [][]float32
+		var skip []bool
+		for ch := 0; ch < d.channels; ch++ {
+			if mp.mux[ch] == i {
+				vectors = append(vectors, d.residue[ch][:half])
+				skip = append(skip, noResidue[ch])
+			}
+		}
+		d.decodeResidue(d.residues[mp.residues[i]], b, vectors, skip, half)
+	}
+
+	//inverse coupling
+	for i := len(mp.coupling) - 1; i >= 0; i-- {
+		mag := d.residue[mp.coupling[i].magnitude][:half]
+		ang := d.residue[mp.coupling[i].angle][:half]
+		for j := range mag {
+			m, a := mag[j], ang[j]
+			if m > 0 {
+				if a > 0 {
+					mag[j], ang[j] = m, m-a
+				} else {
+					mag[j], ang[j] = m+a, m
+				}
+			} else {
+				if a > 0 {
+					mag[j], ang[j] = m, m+a
+				} else {
+					mag[j], ang[j] = m-a, m
+				}
+			}
+		}
+	}
+
+	//dot product, inverse MDCT and windowing
+	window := d.window(n, prevFlag, nextFlag, m.blockflag)
+	block := make([][]float32, d.channels)
+	for ch := 0; ch < d.channels; ch++ {
+		spectrum := d.residue[ch][:half]
+		if noResidue[ch] {
+			for j := range spectrum {
+				spectrum[j] = 0
+			}
+		} else {
+			floor := d.floor[ch][:half]
+			for j := range spectrum {
+				spectrum[j] *= floor[j]
+			}
+		}
+		block[ch] = make([]float32, n)
+		d.imdct[flag].inverse(spectrum, block[ch])
+		for j := range block[ch] {
+			block[ch][j] *= window[j]
+		}
+	}
+
+	//overlap-add the right half of the previous block with the left half of the current one
+	if d.prevValid {
+		pn := d.prevSize
+		count := pn/4 + n/4
+		shift := pn/4 - n/4
+		out = make([][]float32, d.channels)
+		for ch := 0; ch < d.channels; ch++ {
+			out[ch] = make([]float32, count)
+			for k := 0; k < count; k++ {
+				var v float32
+				if i := pn/2 + k; i < pn {
+					v = d.prev[ch][i]
+				}
+				if i := k - shift; i >= 0 && i < n {
+					v += block[ch][i]
+				}
+				out[ch][k] = v
+			}
+		}
+	}
+	for ch := 0; ch < d.channels; ch++ {
+		copy(d.prev[ch], block[ch])
+	}
+	d.prevSize = n
+	d.prevValid = true
+	return out, nil
+}
+
+//window returns the Vorbis power-complementary window for the given block configuration
+func (d *vorbisDecoder) window(n int, prevFlag, nextFlag, long bool) []float32 {
+	key := n
+	if long {
+		if prevFlag {
+			key |= 1 << 20
+		}
+		if nextFlag {
+			key |= 1 << 21
+		}
+	}
+	if w, ok := d.windows[key]; ok {
+		return w
+	}
+	bs0 := d.blocksize[0]
+	leftStart, leftEnd, leftN := 0, n/2, n/2
+	rightStart, rightEnd, rightN := n/2, n, n/2
+	if long && !prevFlag {
+		leftStart, leftEnd, leftN = n/4-bs0/4, n/4+bs0/4, bs0/2
+	}
+	if long && !nextFlag {
+		rightStart, rightEnd, rightN = n*3/4-bs0/4, n*3/4+bs0/4, bs0/2
+	}
+	w := make([]float32, n)
+	for i := range w {
+		switch {
+		case i < leftStart:
+			w[i] = 0
+		case i < leftEnd:
+			s := math.Sin((float64(i-leftStart) + 0.5) / float64(leftN) * math.Pi / 2)
+			w[i] = float32(math.Sin(math.Pi / 2 * s * s))
+		case i < rightStart:
+			w[i] = 1
+		case i < rightEnd:
+			s := math.Sin((float64(i-rightStart)+0.5)/float64(rightN)*math.Pi/2 + math.Pi/2)
+			w[i] = float32(math.Sin(math.Pi / 2 * s * s))
+		default:
+			w[i] = 0
+		}
+	}
+	d.windows[key] = w
+	return w
+}
+
+//decodeFloor1 returns false when the channel is unused in this packet
+func (d *vorbisDecoder) decodeFloor1(f *floor1, b *bitReader, out []float32) bool {
+	if !b.readFlag() {
+		return false
+	}
+	rng := [4]int{256, 128, 86, 64}[f.multiplier-1]
+	y := make([]int, len(f.x))
+	bits := ilog(rng - 1)
+	y[0] = int(b.read(bits))
+	y[1] = int(b.read(bits))
+	offset := 2
+	for _, class := range f.partitionClass {
+		c := &f.classes[class]
+		csub := (1 << c.subclasses) - 1
+		cval := 0
+		if c.subclasses > 0 {
+			cval = d.books[c.masterbook].decodeScalar(b)
+		}
+		for j := 0; j < c.dimensions; j++ {
+			book := c.subBooks[cval&csub]
+			cval >>= c.subclasses
+			if book >= 0 {
+				y[offset+j] = d.books[book].decodeScalar(b)
+			}
+		}
+		offset += c.dimensions
+	}
+
+	//amplitude value synthesis
+	step2 := make([]bool, len(f.x))
+	final := make([]int, len(f.x))
+	step2[0], step2[1] = true, true
+	final[0], final[1] = y[0], y[1]
+	for i := 2; i < len(f.x); i++ {
+		lo, hi := f.low[i], f.high[i]
+		predicted := renderPoint(f.x[lo], final[lo], f.x[hi], final[hi], f.x[i])
+		val := y[i]
+		highroom := rng - predicted
+		lowroom := predicted
+		room := lowroom
+		if highroom < lowroom {
+			room = highroom
+		}
+		room *= 2
+		if val == 0 {
+			final[i] = predicted
+			continue
+		}
+		step2[lo], step2[hi], step2[i] = true, true, true
+		switch {
+		case val >= room && highroom > lowroom:
+			final[i] = val - lowroom + predicted
+		case val >= room:
+			final[i] = predicted - val + highroom - 1
+		case val%2 == 1:
+			final[i] = predicted - (val+1)/2
+		default:
+			final[i] = predicted + val/2
+		}
+	}
+
+	//curve synthesis
+	n := len(out)
+	curve := make([]int, n)
+	hx, hy := 0, 0
+	lx := 0
+	ly := final[f.sorted[0]] * f.multiplier
+	for _, i := range f.sorted[1:] {
+		if !step2[i] {
+			continue
+		}
+		hy = final[i] * f.multiplier
+		hx = f.x[i]
+		renderLine(lx, ly, hx, hy, curve)
+		lx, ly = hx, hy
+	}
+	if hx < n {
+		renderLine(hx, hy, n, hy, curve)
+	}
+	for i := range out {
+		v := curve[i]
+		if v < 0 {
+			v = 0
+		} else if v > 255 {
+			v = 255
+		}
+		out[i] = floor1InverseDB[v]
+	}
+	return true
+}
+
+func renderPoint(x0, y0, x1, y1, x int) int {
+	dy := y1 - y0
+	adx := x1 - x0
+	ady := dy
+	if ady < 0 {
+		ady = -ady
+	}
+	off := ady * (x - x0) / adx
+	if dy < 0 {
+		return y0 - off
+	}
+	return y0 + off
+}
+
+func renderLine(x0, y0, x1, y1 int, v []int) {
+	dy := y1 - y0
+	adx := x1 - x0
+	ady := dy
+	if ady < 0 {
+		ady = -ady
+	}
+	base := dy / adx
+	sy := base + 1
+	if dy < 0 {
+		sy = base - 1
+	}
+	abase := base
+	if abase < 0 {
+		abase = -abase
+	}
+	ady -= abase * adx
+	y := y0
+	err := 0
+	if x0 < len(v) {
+		v[x0] = y
+	}
+	for x := x0 + 1; x < x1 && x < len(v); x++ {
+		err += ady
+		if err >= adx {
+			err -= adx
+			y += sy
+		} else {
+			y += base
+		}
+		v[x] = y
+	}
+}
+
+//decodeFloor0 returns false when the channel is unused in this packet
+func (d *vorbisDecoder) decodeFloor0(f *floor0, b *bitReader, out []float32) bool {
+	amplitude := int(b.read(f.amplitudeBits))
+	if amplitude == 0 {
+		return false
+	}
+	bookNumber := int(b.read(ilog(len(f.books))))
+	if bookNumber >= len(f.books) {
+		panic(errVorbisSetup)
+	}
+	book := d.books[f.books[bookNumber]]
+	coefficients := make([]float64, 0, f.order+book.dimensions)
+	last := 0.0
+	for len(coefficients) < f.order {
+		v := book.decodeVector(b)
+		for _, c := range v {
+			coefficients = append(coefficients, float64(c)+last)
+		}
+		last = coefficients[len(coefficients)-1]
+	}
+	coefficients = coefficients[:f.order]
+
+	n := len(out)
+	bark := func(x float64) float64 {
+		return 13.1*math.Atan(.00074*x) + 2.24*math.Atan(.0000000185*x*x) + .0001*x
+	}
+	scale := float64(f.barkMapSize) / bark(.5*float64(f.rate))
+	cosCoeff := make([]float64, f.order)
+	for i, c := range coefficients {
+		cosCoeff[i] = math.Cos(c)
+	}
+	maxAmp := float64(int(1)<<f.amplitudeBits - 1)
+	for i := 0; i < n; {
+		m := int(math.Floor(bark(float64(f.rate)*float64(i)/(2*float64(n))) * scale))
+		if m > f.barkMapSize-1 {
+			m = f.barkMapSize - 1
+		}
+		cosw := math.Cos(math.Pi * float64(m) / float64(f.barkMapSize))
+		var p, q float64
+		if f.order%2 == 1 {
+			p = 1 - cosw*cosw
+			q = .25
+			for j := 0; j <= (f.order-3)/2; j++ {
+				t := cosCoeff[2*j+1] - cosw
+				p *= 4 * t * t
+			}
+			for j := 0; j <= (f.order-1)/2; j++ {
+				t := cosCoeff[2*j] - cosw
+				q *= 4 * t * t
+			}
+		} else {
+			p = (1 - cosw) / 2
+			q = (1 + cosw) / 2
+			for j := 0; j <= (f.order-2)/2; j++ {
+				t := cosCoeff[2*j+1] - cosw
+				p *= 4 * t * t
+				t = cosCoeff[2*j] - cosw
+				q *= 4 * t * t
+			}
+		}
+		v := float32(math.Exp(.11512925 * (float64(amplitude)*float64(f.amplitudeOffset)/(maxAmp*math.Sqrt(p+q)) - float64(f.amplitudeOffset))))
+		//all positions mapping to the same bark value share the floor value
+		for ; i < n; i++ {
+			mi := int(math.Floor(bark(float64(f.rate)*float64(i)/(2*float64(n))) * scale))
+			if mi > f.barkMapSize-1 {
+				mi = f.barkMapSize - 1
+			}
+			if mi != m {
+				break
+			}
+			out[i] = v
+		}
+	}
+	return true
+}
+
+//decodeResidue decodes residue vectors of all channels in a submap; vectors are zeroed first
+func (d *vorbisDecoder) decodeResidue(r *residue, b *bitReader, vectors [][]float32, skip []bool, n int) {
+	for _, v := range vectors {
+		for i := range v {
+			v[i] = 0
+		}
+	}
+	if r.kind == 2 {
+		decode := false
+		for _, s := range skip {
+			if !s {
+				decode = true
+			}
+		}
+		if !decode {
+			return
+		}
+		ch := len(vectors)
+		flat := make([]float32, n*ch)
+		d.decodeResiduePartitions(r, b, [][]float32{flat}, []bool{false}, n*ch)
+		for i := 0; i < n; i++ {
+			for j := 0; j < ch; j++ {
+				vectors[j][i] = flat[i*ch+j]
+			}
+		}
+		return
+	}
+	d.decodeResiduePartitions(r, b, vectors, skip, n)
+}
+
+func (d *vorbisDecoder) decodeResiduePartitions(r *residue, b *bitReader, vectors [][]float32, skip []bool, size int) {
+	begin, end := r.begin, r.end
+	if begin > size {
+		begin = size
+	}
+	if end > size {
+		end = size
+	}
+	if end <= begin {
+		return
+	}
+	classbook := d.books[r.classbook]
+	perWord := classbook.dimensions
+	partitions := (end - begin) / r.partitionSize
+	classes := make([][]int, len(vectors))
+	for j := range classes {
+		classes[j] = make([]int, partitions+perWord)
+	}
+	//running out of data while decoding residues is legal and leaves the rest zeroed
+	defer func() {
+		if rec := recover(); rec != nil && rec != errEndOfPacket {
+			panic(rec)
+		}
+	}()
+	for pass := 0; pass < 8; pass++ {
+		for p := 0; p < partitions; {
+			if pass == 0 {
+				for j := range vectors {
+					if skip[j] {
+						continue
+					}
+					temp := classbook.decodeScalar(b)
+					for i := perWord - 1; i >= 0; i-- {
+						classes[j][p+i] = temp % r.classifications
+						temp /= r.classifications
+					}
+				}
+			}
+			for i := 0; i < perWord && p < partitions; i++ {
+				for j, v := range vectors {
+					if skip[j] {
+						continue
+					}
+					book := r.books[classes[j][p]][pass]
+					if book < 0 {
+						continue
+					}
+					cb := d.books[book]
+					off := begin + p*r.partitionSize
+					if r.kind == 0 {
+						step := r.partitionSize / cb.dimensions
+						for k := 0; k < step; k++ {
+							e := cb.decodeVector(b)
+							for l, val := range e {
+								v[off+k+l*step] += val
+							}
+						}
+					} else {
+						for k := 0; k < r.partitionSize; {
+							e := cb.decodeVector(b)
+							for _, val := range e {
+								if k >= r.partitionSize {
+									break
+								}
+								v[off+k] += val
+								k++
+							}
+						}
+					}
+				}
+				p++
+			}
+		}
+	}
+}
+
+//OggReader decodes an Ogg Vorbis stream into interleaved signed 16-bit little endian samples
+type OggReader struct {
+	packets *oggPacketReader
+	vorbis  vorbisDecoder
+	pending []byte
+	//total number of samples per channel declared by the last page (used to trim the final block)
+	produced int64
+	done     bool
+	//Vendor is the encoder identification found in the comment header
+	Vendor string
+}
+
+//NewOggReader reads the Vorbis identification, comment and setup headers from the stream
+func NewOggReader(r io.Reader) (*OggReader, error) {
+	o := &OggReader{packets: newOggPacketReader(r)}
+	var p []byte
+	var err error
+	if p, _, err = o.packets.next(); err != nil {
+		return nil, err
+	}
+	if err = o.vorbis.readIdentification(p); err != nil {
+		return nil, err
+	}
+	if p, _, err = o.packets.next(); err != nil {
+		return nil, fmt.Errorf("could not read Vorbis comment header: %v", err)
+	}
+	if len(p) < 11 || p[0] != 3 || string(p[1:7]) != "vorbis" {
+		return nil, errors.New("missing Vorbis comment header")
+	}
+	l := int(p[7]) | int(p[8])<<8 | int(p[9])<<16 | int(p[10])<<24
+	if l >= 0 && 11+l <= len(p) {
+		o.Vendor = string(p[11 : 11+l])
+	}
+	if p, _, err = o.packets.next(); err != nil {
+		return nil, fmt.Errorf("could not read Vorbis setup header: %v", err)
+	}
+	if err = o.vorbis.readSetup(p); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+//SampleRate returns the native sample rate of the stream
+func (o *OggReader) SampleRate() int {
+	return o.vorbis.sampleRate
+}
+
+//Channels returns the number of channels of the stream
+func (o *OggReader) Channels() int {
+	return o.vorbis.channels
+}
+
+func (o *OggReader) Read(b []byte) (int, error) {
+	for len(o.pending) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		p, last, err := o.packets.next()
+		if err == io.EOF {
+			o.done = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		var pcm [][]float32
+		if pcm, err = o.vorbis.decode(p); err != nil {
+			return 0, err
+		}
+		if len(pcm) == 0 {
+			continue
+		}
+		samples := len(pcm[0])
+		//the granule position of the last page tells how many samples the stream really holds
+		if last {
+			if total := o.packets.granule; total >= o.produced && total-o.produced < int64(samples) {
+				samples = int(total - o.produced)
+			}
+			o.done = true
+		}
+		o.produced += int64(samples)
+		o.pending = interleaveS16(pcm, samples, o.pending[:0])
+	}
+	n := copy(b, o.pending)
+	o.pending = o.pending[n:]
+	return n, nil
+}
+
+//interleaveS16 converts planar float samples in the <-1, 1> range to interleaved S16LE bytes
+func interleaveS16(pcm [][]float32, samples int, buf []byte) []byte {
+	for i := 0; i < samples; i++ {
+		for ch := range pcm {
+			v := int32(pcm[ch][i] * 32767)
+			if v > 32767 {
+				v = 32767
+			} else if v < -32768 {
+				v = -32768
+			}
+			buf = append(buf, byte(v), byte(v>>8))
+		}
+	}
+	return buf
+}
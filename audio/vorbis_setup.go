@@ -0,0 +1,498 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	//errEndOfPacket is raised by the bit reader when a packet is exhausted
+	errEndOfPacket = errors.New("end of Vorbis packet")
+	errVorbisSetup = errors.New("invalid Vorbis setup header")
+)
+
+//bitReader reads Vorbis packets LSB first as described in section 2 of the Vorbis I specification
+type bitReader struct {
+	data []byte
+	pos  int
+	bit  uint
+}
+
+func (b *bitReader) read(n uint) uint32 {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		if b.pos >= len(b.data) {
+			panic(errEndOfPacket)
+		}
+		v |= uint32(b.data[b.pos]>>b.bit&1) << i
+		b.bit++
+		if b.bit == 8 {
+			b.bit = 0
+			b.pos++
+		}
+	}
+	return v
+}
+
+func (b *bitReader) readFlag() bool {
+	return b.read(1) == 1
+}
+
+//ilog returns the position of the highest set bit (ilog(0) = 0)
+func ilog(v int) uint {
+	var n uint
+	for v > 0 {
+		n++
+		v >>= 1
+	}
+	return n
+}
+
+func float32Unpack(x uint32) float64 {
+	mantissa := float64(x & 0x1fffff)
+	exponent := int((x & 0x7fe00000) >> 21)
+	if x&0x80000000 != 0 {
+		mantissa = -mantissa
+	}
+	return math.Ldexp(mantissa, exponent-788)
+}
+
+//lookup1Values returns the greatest integer r for which r^dimensions <= entries
+func lookup1Values(entries, dimensions int) int {
+	r := int(math.Floor(math.Pow(float64(entries), 1/float64(dimensions))))
+	for pow(r+1, dimensions) <= entries {
+		r++
+	}
+	for r > 0 && pow(r, dimensions) > entries {
+		r--
+	}
+	return r
+}
+
+func pow(b, e int) int {
+	v := 1
+	for i := 0; i < e; i++ {
+		v *= b
+	}
+	return v
+}
+
+type huffNode struct {
+	children [2]int32 //negative values encode leaf entries as -(entry+1); zero means no child
+}
+
+type codebook struct {
+	dimensions int
+	entries    int
+	lengths    []uint8
+	tree       []huffNode
+	single     int //entry of single-entry codebooks, -1 otherwise
+	vectors    []float32
+}
+
+func readCodebook(b *bitReader) (*codebook, error) {
+	if b.read(24) != 0x564342 {
+		return nil, fmt.Errorf("invalid Vorbis codebook sync pattern")
+	}
+	c := &codebook{single: -1}
+	c.dimensions = int(b.read(16))
+	c.entries = int(b.read(24))
+	//residue decoding divides by the dimensions
+	if c.entries == 0 || c.dimensions == 0 {
+		return nil, errVorbisSetup
+	}
+	c.lengths = make([]uint8, c.entries)
+	if b.readFlag() {
+		//ordered
+		entry := 0
+		length := int(b.read(5)) + 1
+		for entry < c.entries {
+			n := int(b.read(ilog(c.entries - entry)))
+			if entry+n > c.entries || length > 32 {
+				return nil, errVorbisSetup
+			}
+			for i := entry; i < entry+n; i++ {
+				c.lengths[i] = uint8(length)
+			}
+			entry += n
+			length++
+		}
+	} else {
+		sparse := b.readFlag()
+		for i := range c.lengths {
+			if !sparse || b.readFlag() {
+				c.lengths[i] = uint8(b.read(5) + 1)
+			}
+		}
+	}
+	if err := c.buildTree(); err != nil {
+		return nil, err
+	}
+
+	lookup := b.read(4)
+	switch lookup {
+	case 0:
+	case 1, 2:
+		minimum := float32Unpack(b.read(32))
+		delta := float32Unpack(b.read(32))
+		valueBits := uint(b.read(4)) + 1
+		sequence := b.readFlag()
+		var count int
+		if lookup == 1 {
+			count = lookup1Values(c.entries, c.dimensions)
+		} else {
+			count = c.entries * c.dimensions
+		}
+		if count == 0 {
+			return nil, errVorbisSetup
+		}
+		mult := make([]uint32, count)
+		for i := range mult {
+			mult[i] = b.read(valueBits)
+		}
+		c.vectors = make([]float32, c.entries*c.dimensions)
+		for e := 0; e < c.entries; e++ {
+			last := 0.0
+			div := 1
+			for i := 0; i < c.dimensions; i++ {
+				var off int
+				if lookup == 1 {
+					off = (e / div) % count
+					div *= count
+				} else {
+					off = e*c.dimensions + i
+				}
+				v := float64(mult[off])*delta + minimum + last
+				if sequence {
+					last = v
+				}
+				c.vectors[e*c.dimensions+i] = float32(v)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Vorbis codebook lookup type %d", lookup)
+	}
+	return c, nil
+}
+
+//buildTree assigns codewords to entries (section 3.2.1) and builds a binary decoding tree
+func (c *codebook) buildTree() error {
+	var marker [33]uint32
+	used := 0
+	for i, l := range c.lengths {
+		if l > 0 {
+			used++
+			c.single = i
+		}
+	}
+	if used <= 1 {
+		//a single used entry needs no tree
+		if used == 0 {
+			c.single = -1
+		}
+		return nil
+	}
+	c.single = -1
+	c.tree = []huffNode{{}}
+	for i, l := range c.lengths {
+		if l == 0 {
+			continue
+		}
+		code := marker[l]
+		if l < 32 && code>>l != 0 {
+			return fmt.Errorf("overspecified Vorbis Huffman tree")
+		}
+		//walk the codeword MSB first
+		node := 0
+		for j := int(l) - 1; j >= 0; j-- {
+			bit := code >> uint(j) & 1
+			if j == 0 {
+				c.tree[node].children[bit] = -int32(i + 1)
+				break
+			}
+			next := c.tree[node].children[bit]
+			if next < 0 {
+				return fmt.Errorf("invalid Vorbis Huffman tree")
+			}
+			if next == 0 {
+				c.tree = append(c.tree, huffNode{})
+				next = int32(len(c.tree) - 1)
+				c.tree[node].children[bit] = next
+			}
+			node = int(next)
+		}
+		//update the markers of available codewords
+		for j := l; j > 0; j-- {
+			if marker[j]&1 != 0 {
+				if j == 1 {
+					marker[1]++
+				} else {
+					marker[j] = marker[j-1] << 1
+				}
+				break
+			}
+			marker[j]++
+		}
+		for j := int(l) + 1; j < 33; j++ {
+			if marker[j]>>1 == code {
+				code = marker[j]
+				marker[j] = marker[j-1] << 1
+			} else {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+//decodeScalar reads one Huffman codeword and returns the entry number
+func (c *codebook) decodeScalar(b *bitReader) int {
+	if c.tree == nil {
+		if c.single < 0 {
+			panic(errVorbisSetup)
+		}
+		//libvorbis writes the (meaningless) codeword of single-entry books anyway
+		b.read(uint(c.lengths[c.single]))
+		return c.single
+	}
+	node := int32(0)
+	for {
+		next := c.tree[node].children[b.read(1)]
+		if next < 0 {
+			return int(-next - 1)
+		}
+		if next == 0 {
+			panic(fmt.Errorf("undecodable Vorbis codeword"))
+		}
+		node = next
+	}
+}
+
+//decodeVector returns the VQ vector of the next entry
+func (c *codebook) decodeVector(b *bitReader) []float32 {
+	e := c.decodeScalar(b)
+	if c.vectors == nil {
+		panic(fmt.Errorf("Vorbis codebook used for VQ has no lookup table"))
+	}
+	return c.vectors[e*c.dimensions : (e+1)*c.dimensions]
+}
+
+type floor0 struct {
+	order           int
+	rate            int
+	barkMapSize     int
+	amplitudeBits   uint
+	amplitudeOffset int
+	books           []int
+}
+
+type floor1Class struct {
+	dimensions int
+	subclasses uint
+	masterbook int
+	subBooks   []int
+}
+
+type floor1 struct {
+	partitionClass []int
+	classes        []floor1Class
+	multiplier     int
+	x              []int
+	//precomputed neighbours and sort order of x
+	low, high []int
+	sorted    []int
+}
+
+type residue struct {
+	kind            int
+	begin, end      int
+	partitionSize   int
+	classifications int
+	classbook       int
+	books           [][8]int
+}
+
+type couplingStep struct {
+	magnitude, angle int
+}
+
+type mapping struct {
+	coupling []couplingStep
+	mux      []int
+	floors   []int
+	residues []int
+}
+
+type mode struct {
+	blockflag bool
+	mapping   int
+}
+
+func readFloor0(b *bitReader, books int) (*floor0, error) {
+	f := &floor0{
+		order:           int(b.read(8)),
+		rate:            int(b.read(16)),
+		barkMapSize:     int(b.read(16)),
+		amplitudeBits:   uint(b.read(6)),
+		amplitudeOffset: int(b.read(8)),
+	}
+	n := int(b.read(4)) + 1
+	f.books = make([]int, n)
+	for i := range f.books {
+		if f.books[i] = int(b.read(8)); f.books[i] >= books {
+			return nil, errVorbisSetup
+		}
+	}
+	if f.order < 1 || f.rate < 1 || f.barkMapSize < 1 {
+		return nil, errVorbisSetup
+	}
+	return f, nil
+}
+
+func readFloor1(b *bitReader, books int) (*floor1, error) {
+	f := &floor1{}
+	partitions := int(b.read(5))
+	f.partitionClass = make([]int, partitions)
+	maxClass := -1
+	for i := range f.partitionClass {
+		f.partitionClass[i] = int(b.read(4))
+		if f.partitionClass[i] > maxClass {
+			maxClass = f.partitionClass[i]
+		}
+	}
+	f.classes = make([]floor1Class, maxClass+1)
+	for i := range f.classes {
+		c := &f.classes[i]
+		c.dimensions = int(b.read(3)) + 1
+		c.subclasses = uint(b.read(2))
+		if c.subclasses > 0 {
+			if c.masterbook = int(b.read(8)); c.masterbook >= books {
+				return nil, errVorbisSetup
+			}
+		}
+		c.subBooks = make([]int, 1<<c.subclasses)
+		for j := range c.subBooks {
+			if c.subBooks[j] = int(b.read(8)) - 1; c.subBooks[j] >= books {
+				return nil, errVorbisSetup
+			}
+		}
+	}
+	f.multiplier = int(b.read(2)) + 1
+	rangeBits := uint(b.read(4))
+	f.x = []int{0, 1 << rangeBits}
+	for _, class := range f.partitionClass {
+		for j := 0; j < f.classes[class].dimensions; j++ {
+			f.x = append(f.x, int(b.read(rangeBits)))
+		}
+	}
+	if len(f.x) > 65 {
+		return nil, errVorbisSetup
+	}
+	f.low = make([]int, len(f.x))
+	f.high = make([]int, len(f.x))
+	for i := 2; i < len(f.x); i++ {
+		lo, hi := 0, 1
+		for j := 0; j < i; j++ {
+			if f.x[j] < f.x[i] && f.x[j] > f.x[lo] {
+				lo = j
+			}
+			if f.x[j] > f.x[i] && f.x[j] < f.x[hi] {
+				hi = j
+			}
+			if f.x[j] == f.x[i] {
+				return nil, errVorbisSetup
+			}
+		}
+		f.low[i] = lo
+		f.high[i] = hi
+	}
+	f.sorted = make([]int, len(f.x))
+	for i := range f.sorted {
+		f.sorted[i] = i
+	}
+	//insertion sort; there are at most 65 points
+	for i := 1; i < len(f.sorted); i++ {
+		for j := i; j > 0 && f.x[f.sorted[j]] < f.x[f.sorted[j-1]]; j-- {
+			f.sorted[j], f.sorted[j-1] = f.sorted[j-1], f.sorted[j]
+		}
+	}
+	return f, nil
+}
+
+func readResidue(b *bitReader, kind int, books int) (*residue, error) {
+	r := &residue{kind: kind}
+	r.begin = int(b.read(24))
+	r.end = int(b.read(24))
+	r.partitionSize = int(b.read(24)) + 1
+	r.classifications = int(b.read(6)) + 1
+	if r.classbook = int(b.read(8)); r.classbook >= books {
+		return nil, errVorbisSetup
+	}
+	cascade := make([]uint32, r.classifications)
+	for i := range cascade {
+		low := b.read(3)
+		var high uint32
+		if b.readFlag() {
+			high = b.read(5)
+		}
+		cascade[i] = high<<3 | low
+	}
+	r.books = make([][8]int, r.classifications)
+	for i := range r.books {
+		for j := uint(0); j < 8; j++ {
+			r.books[i][j] = -1
+			if cascade[i]&(1<<j) != 0 {
+				if r.books[i][j] = int(b.read(8)); r.books[i][j] >= books {
+					return nil, errVorbisSetup
+				}
+			}
+		}
+	}
+	return r, nil
+}
+
+func readMapping(b *bitReader, channels, floors, residues int) (*mapping, error) {
+	if t := b.read(16); t != 0 {
+		return nil, fmt.Errorf("unsupported Vorbis mapping type %d", t)
+	}
+	m := &mapping{}
+	submaps := 1
+	if b.readFlag() {
+		submaps = int(b.read(4)) + 1
+	}
+	if b.readFlag() {
+		steps := int(b.read(8)) + 1
+		m.coupling = make([]couplingStep, steps)
+		bits := ilog(channels - 1)
+		for i := range m.coupling {
+			m.coupling[i].magnitude = int(b.read(bits))
+			m.coupling[i].angle = int(b.read(bits))
+			if m.coupling[i].magnitude == m.coupling[i].angle || m.coupling[i].magnitude >= channels || m.coupling[i].angle >= channels {
+				return nil, errVorbisSetup
+			}
+		}
+	}
+	if b.read(2) != 0 {
+		return nil, errVorbisSetup
+	}
+	m.mux = make([]int, channels)
+	if submaps > 1 {
+		for i := range m.mux {
+			if m.mux[i] = int(b.read(4)); m.mux[i] >= submaps {
+				return nil, errVorbisSetup
+			}
+		}
+	}
+	m.floors = make([]int, submaps)
+	m.residues = make([]int, submaps)
+	for i := 0; i < submaps; i++ {
+		b.read(8)
+		m.floors[i] = int(b.read(8))
+		m.residues[i] = int(b.read(8))
+		if m.floors[i] >= floors || m.residues[i] >= residues {
+			return nil, errVorbisSetup
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,149 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type VorbisTestSuite struct {
+	suite.Suite
+}
+
+func (suite *VorbisTestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func (suite *VorbisTestSuite) TestDecodeFile() {
+	f, err := os.Open("testdata/clip.ogg")
+	a := assert.New(suite.T())
+	a.NoError(err)
+	defer f.Close()
+	d, err := NewDecoder(bufio.NewReader(f))
+	a.NoError(err)
+	a.Equal(22050, d.SampleRate())
+	a.Equal(1, d.Channels())
+	a.Equal("Xiph.Org libVorbis I 20070622", d.(*OggReader).Vendor)
+	pcm, err := ioutil.ReadAll(d)
+	a.NoError(err)
+	//length is trimmed to the granule position of the last page
+	a.Equal(197037*2, len(pcm))
+	//the clip is speech; make sure we decoded a sane signal rather than noise or silence
+	var sum float64
+	var peak int16
+	for i := 0; i < len(pcm); i += 2 {
+		v := int16(binary.LittleEndian.Uint16(pcm[i:]))
+		sum += float64(v) * float64(v)
+		if v > peak {
+			peak = v
+		}
+	}
+	rms := math.Sqrt(sum / float64(len(pcm)/2))
+	a.True(rms > 300 && rms < 8000, "unexpected RMS %f", rms)
+	a.True(peak > 4000, "unexpected peak %d", peak)
+}
+
+func (suite *VorbisTestSuite) TestIMDCT() {
+	for _, n := range []int{64, 256, 2048} {
+		in := make([]float32, n/2)
+		for i := range in {
+			in[i] = float32(math.Sin(float64(i * 7)))
+		}
+		out := make([]float32, n)
+		newIMDCT(n).inverse(in, out)
+		for i := 0; i < n; i++ {
+			var acc float64
+			for k := 0; k < n/2; k++ {
+				acc += float64(in[k]) * math.Cos(2*math.Pi/float64(n)*(float64(i)+.5+float64(n)/4)*(float64(k)+.5))
+			}
+			assert.InDelta(suite.T(), acc, out[i], 1e-3)
+		}
+	}
+}
+
+func (suite *VorbisTestSuite) TestHuffmanTree() {
+	//example from section 3.2.1 of the specification
+	c := &codebook{lengths: []uint8{2, 4, 4, 4, 4, 2, 3, 3}, entries: 8, single: -1}
+	a := assert.New(suite.T())
+	a.NoError(c.buildTree())
+	//codewords: 0:00 1:0100 2:0101 3:0110 4:0111 5:10 6:110 7:111 (read MSB first)
+	codes := map[int]string{0: "00", 1: "0100", 2: "0101", 3: "0110", 4: "0111", 5: "10", 6: "110", 7: "111"}
+	for entry, code := range codes {
+		var bits uint32
+		for i, ch := range code {
+			if ch == '1' {
+				bits |= 1 << uint(i)
+			}
+		}
+		b := &bitReader{data: []byte{byte(bits)}}
+		a.Equal(entry, c.decodeScalar(b), "codeword %s", code)
+	}
+	overspecified := &codebook{lengths: []uint8{1, 1, 1}, entries: 3, single: -1}
+	a.Error(overspecified.buildTree())
+}
+
+func (suite *VorbisTestSuite) TestFloat32Unpack() {
+	a := assert.New(suite.T())
+	//1.0 is mantissa 1 with exponent 788
+	a.Equal(1.0, float32Unpack(788<<21|1))
+	a.Equal(-2.0, float32Unpack(0x80000000|789<<21|1))
+	a.Equal(3, lookup1Values(27, 3))
+	a.Equal(2, lookup1Values(26, 3))
+}
+
+func (suite *VorbisTestSuite) TestInvalidStreams() {
+	a := assert.New(suite.T())
+	_, err := NewDecoder(bufio.NewReader(bytes.NewReader([]byte("ID3\x03\x00"))))
+	a.Equal(ErrUnknownContainer, err)
+
+	data, _ := ioutil.ReadFile("testdata/clip.ogg")
+	corrupted := append([]byte(nil), data...)
+	corrupted[40] ^= 0xFF
+	_, err = NewOggReader(bytes.NewReader(corrupted))
+	a.Equal(errOggCRC, err)
+
+	_, err = NewOggReader(bytes.NewReader(data[:100]))
+	a.Error(err)
+
+	//a valid Ogg page carrying something else than Vorbis
+	page := append([]byte(nil), data[:58]...)
+	copy(page[29:35], "theora")
+	binary.LittleEndian.PutUint32(page[22:26], 0)
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC(0, page))
+	_, err = NewOggReader(bytes.NewReader(page))
+	a.Equal(ErrNotVorbis, err)
+}
+
+func (suite *VorbisTestSuite) TestCorruptSetup() {
+	a := assert.New(suite.T())
+	data, _ := ioutil.ReadFile("testdata/clip.ogg")
+	packets := newOggPacketReader(bytes.NewReader(data))
+	id, _, _ := packets.next()
+	packets.next()
+	setup, _, err := packets.next()
+	a.NoError(err)
+	//a damaged setup header must be rejected without bringing the service down
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		p := append([]byte(nil), setup...)
+		for n := r.Intn(3) + 1; n > 0; n-- {
+			p[7+r.Intn(len(p)-7)] ^= byte(1 << uint(r.Intn(8)))
+		}
+		d := &vorbisDecoder{}
+		a.NoError(d.readIdentification(id))
+		a.NotPanics(func() { d.readSetup(p) }, "mutation %d", i)
+	}
+}
+
+func TestVorbisTestSuite(t *testing.T) {
+	suite.Run(t, new(VorbisTestSuite))
+}
@@ -0,0 +1,285 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+//WAVE format tags as defined in mmreg.h
+const (
+	wavFormatPCM        uint16 = 0x0001
+	wavFormatIEEEFloat  uint16 = 0x0003
+	wavFormatALaw       uint16 = 0x0006
+	wavFormatMuLaw      uint16 = 0x0007
+	wavFormatExtensible uint16 = 0xFFFE
+)
+
+//wavUnknownSize is used by streaming writers that do not know the data size upfront
+const wavUnknownSize = 0xFFFFFFFF
+
+const (
+	//wavMaxFormatSize is the largest fmt chunk accepted; WAVE_FORMAT_EXTENSIBLE needs 40 bytes
+	wavMaxFormatSize = 64
+	//wavMaxListSize is the largest LIST chunk parsed for metadata; larger ones are skipped
+	wavMaxListSize = 64 * 1024
+)
+
+//ksDataFormatSuffix is the common tail of KSDATAFORMAT_SUBTYPE_* GUIDs;
+//the first two bytes of the GUID carry the actual format tag
+var ksDataFormatSuffix = []byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+var (
+	//ErrNotWav is returned when the stream does not start with a RIFF/WAVE header
+	ErrNotWav = errors.New("not a RIFF/WAVE stream")
+	//ErrNoFormat is returned when the data chunk is found before the fmt chunk
+	ErrNoFormat = errors.New("WAV data chunk precedes fmt chunk")
+	//ErrNoData is returned when the container has no data chunk
+	ErrNoData = errors.New("WAV stream contains no data chunk")
+)
+
+//WavFormat describes the PCM stream stored in a WAV container
+type WavFormat struct {
+	Tag           uint16 `json:"tag"`
+	Channels      int    `json:"channels"`
+	SampleRate    int    `json:"sampleRate"`
+	ByteRate      int    `json:"byteRate"`
+	BlockAlign    int    `json:"blockAlign"`
+	BitsPerSample int    `json:"bitsPerSample"`
+	ValidBits     int    `json:"validBits"`
+	ChannelMask   uint32 `json:"channelMask"`
+}
+
+//WavReader reads raw sample data out of a RIFF/WAVE container.
+//Read returns the content of the data chunk as S16LE so that the result can be passed directly to the playback device.
+type WavReader struct {
+	Format WavFormat
+	//Info holds LIST/INFO metadata (e.g. INAM, IART) found before the data chunk
+	Info map[string]string
+	data io.Reader
+	//conv reduces samples wider or narrower than 16 bits; in and out hold the data around it
+	conv *sampleConverter
+	in   []byte
+	out  []byte
+	err  error
+}
+
+/*NewWavReader parses the RIFF/WAVE header up to the beginning of the data chunk.
+8, 16, 24 and 32-bit PCM and 32-bit IEEE float are accepted. Audio is mixed in 16 bits (the native format
+of the device only widens the output) so wider samples are dithered down to 16 bits when read.
+*/
+func NewWavReader(r io.Reader) (*WavReader, error) {
+	var err error
+	hdr := make([]byte, 12)
+	if _, err = io.ReadFull(r, hdr); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotWav
+		}
+		return nil, err
+	}
+	if string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "WAVE" {
+		return nil, ErrNotWav
+	}
+
+	w := &WavReader{Info: make(map[string]string)}
+	var haveFormat bool
+	chunk := make([]byte, 8)
+	for {
+		if _, err = io.ReadFull(r, chunk); err != nil {
+			if err == io.EOF {
+				return nil, ErrNoData
+			}
+			return nil, fmt.Errorf("could not read WAV chunk header: %v", err)
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			if err = w.parseFormat(r, size); err != nil {
+				return nil, err
+			}
+			haveFormat = true
+		case "LIST":
+			if err = w.parseList(r, size); err != nil {
+				return nil, err
+			}
+		case "data":
+			if !haveFormat {
+				return nil, ErrNoFormat
+			}
+			if size == wavUnknownSize || size == 0 {
+				//streamed file; read until the end
+				w.data = r
+			} else {
+				w.data = io.LimitReader(r, int64(size))
+			}
+			if f := w.Format.sampleFormat(); f != FormatS16LE {
+				w.conv, _ = newSampleConverter(f)
+				w.in = make([]byte, pipelineReadBuffer)
+			}
+			return w, nil
+		default:
+			if err = skipChunk(r, size); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (w *WavReader) Read(p []byte) (int, error) {
+	if w.conv == nil {
+		return w.data.Read(p)
+	}
+	for len(w.out) == 0 {
+		if w.err != nil {
+			return 0, w.err
+		}
+		var n int
+		n, w.err = w.data.Read(w.in)
+		w.out = appendS16LE(w.out[:0], w.conv.convert(w.in[:n]))
+	}
+	n := copy(p, w.out)
+	w.out = w.out[n:]
+	return n, nil
+}
+
+//SampleRate returns the sample rate declared in the fmt chunk
+func (w *WavReader) SampleRate() int {
+	return w.Format.SampleRate
+}
+
+//Channels returns the number of channels declared in the fmt chunk
+func (w *WavReader) Channels() int {
+	return w.Format.Channels
+}
+
+func (w *WavReader) parseFormat(r io.Reader, size uint32) error {
+	if size < 16 {
+		return fmt.Errorf("WAV fmt chunk too short: %d bytes", size)
+	}
+	if size > wavMaxFormatSize {
+		return fmt.Errorf("WAV fmt chunk too long: %d bytes", size)
+	}
+	buf := make([]byte, int64(size)+int64(size%2))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("could not read WAV fmt chunk: %v", err)
+	}
+	f := &w.Format
+	f.Tag = binary.LittleEndian.Uint16(buf[0:2])
+	f.Channels = int(binary.LittleEndian.Uint16(buf[2:4]))
+	f.SampleRate = int(binary.LittleEndian.Uint32(buf[4:8]))
+	f.ByteRate = int(binary.LittleEndian.Uint32(buf[8:12]))
+	f.BlockAlign = int(binary.LittleEndian.Uint16(buf[12:14]))
+	f.BitsPerSample = int(binary.LittleEndian.Uint16(buf[14:16]))
+	f.ValidBits = f.BitsPerSample
+
+	if f.Tag == wavFormatExtensible {
+		//cbSize(2) validBits(2) channelMask(4) subFormat(16)
+		if size < 40 {
+			return fmt.Errorf("WAVE_FORMAT_EXTENSIBLE fmt chunk too short: %d bytes", size)
+		}
+		if !bytes.Equal(buf[26:40], ksDataFormatSuffix) {
+			return fmt.Errorf("unsupported WAVE_FORMAT_EXTENSIBLE sub-format % x", buf[24:40])
+		}
+		if v := int(binary.LittleEndian.Uint16(buf[18:20])); v > 0 {
+			f.ValidBits = v
+		}
+		f.ChannelMask = binary.LittleEndian.Uint32(buf[20:24])
+		f.Tag = binary.LittleEndian.Uint16(buf[24:26])
+	}
+	return f.validate()
+}
+
+func (f *WavFormat) validate() error {
+	if f.Channels < 1 {
+		return fmt.Errorf("invalid WAV channel count %d", f.Channels)
+	}
+	if f.SampleRate < 1 {
+		return fmt.Errorf("invalid WAV sample rate %d", f.SampleRate)
+	}
+	switch f.Tag {
+	case wavFormatPCM:
+		if f.BitsPerSample != 8 && f.BitsPerSample != 16 && f.BitsPerSample != 24 && f.BitsPerSample != 32 {
+			return fmt.Errorf("unsupported WAV encoding: %d-bit PCM (8, 16, 24 or 32-bit PCM is supported)", f.BitsPerSample)
+		}
+	case wavFormatIEEEFloat:
+		if f.BitsPerSample != 32 {
+			return fmt.Errorf("unsupported WAV encoding: %d-bit IEEE float (only 32-bit float is supported)", f.BitsPerSample)
+		}
+	case wavFormatALaw:
+		return errors.New("unsupported WAV encoding: A-law")
+	case wavFormatMuLaw:
+		return errors.New("unsupported WAV encoding: mu-law")
+	default:
+		return fmt.Errorf("unsupported WAV encoding: format tag 0x%04x", f.Tag)
+	}
+	if f.BlockAlign != f.Channels*f.BitsPerSample/8 {
+		return fmt.Errorf("invalid WAV block align %d for %d channels of %d bits", f.BlockAlign, f.Channels, f.BitsPerSample)
+	}
+	return nil
+}
+
+//sampleFormat returns the encoding of the samples in the data chunk; the format must have been validated.
+//Samples with fewer valid bits than their container are left-justified and read as the full container.
+func (f *WavFormat) sampleFormat() Format {
+	if f.Tag == wavFormatIEEEFloat {
+		return FormatFloatLE
+	}
+	switch f.BitsPerSample {
+	case 8:
+		return FormatU8
+	case 24:
+		return FormatS243LE
+	case 32:
+		return FormatS32LE
+	}
+	return FormatS16LE
+}
+
+func (w *WavReader) parseList(r io.Reader, size uint32) error {
+	if size > wavMaxListSize {
+		return skipChunk(r, size)
+	}
+	buf := make([]byte, int64(size)+int64(size%2))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("could not read WAV LIST chunk: %v", err)
+	}
+	if size < 4 || string(buf[0:4]) != "INFO" {
+		//other list types (e.g. adtl) are not interesting for playback
+		return nil
+	}
+	buf = buf[4:size]
+	for len(buf) >= 8 {
+		id := string(buf[0:4])
+		l := int(binary.LittleEndian.Uint32(buf[4:8]))
+		buf = buf[8:]
+		if l > len(buf) {
+			l = len(buf)
+		}
+		w.Info[id] = string(bytes.TrimRight(buf[:l], "\x00"))
+		l += l % 2
+		if l > len(buf) {
+			l = len(buf)
+		}
+		buf = buf[l:]
+	}
+	return nil
+}
+
+func skipChunk(r io.Reader, size uint32) error {
+	n := int64(size) + int64(size%2)
+	if s, ok := r.(io.Seeker); ok {
+		if _, err := s.Seek(n, io.SeekCurrent); err != nil {
+			return fmt.Errorf("could not skip WAV chunk: %v", err)
+		}
+		return nil
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+		return fmt.Errorf("could not skip WAV chunk: %v", err)
+	}
+	return nil
+}
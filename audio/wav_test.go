@@ -0,0 +1,178 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type WavTestSuite struct {
+	suite.Suite
+}
+
+func (suite *WavTestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func wavChunk(id string, payload []byte) []byte {
+	b := new(bytes.Buffer)
+	b.WriteString(id)
+	binary.Write(b, binary.LittleEndian, uint32(len(payload)))
+	b.Write(payload)
+	if len(payload)%2 == 1 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+func wavFmt(tag uint16, channels, rate, bits int) []byte {
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.LittleEndian, tag)
+	binary.Write(b, binary.LittleEndian, uint16(channels))
+	binary.Write(b, binary.LittleEndian, uint32(rate))
+	binary.Write(b, binary.LittleEndian, uint32(rate*channels*bits/8))
+	binary.Write(b, binary.LittleEndian, uint16(channels*bits/8))
+	binary.Write(b, binary.LittleEndian, uint16(bits))
+	return b.Bytes()
+}
+
+func wavFile(chunks ...[]byte) []byte {
+	body := bytes.Join(chunks, nil)
+	b := new(bytes.Buffer)
+	b.WriteString("RIFF")
+	binary.Write(b, binary.LittleEndian, uint32(len(body)+4))
+	b.WriteString("WAVE")
+	b.Write(body)
+	return b.Bytes()
+}
+
+func (suite *WavTestSuite) TestPCM() {
+	data := []byte{0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04, 0x00}
+	f := wavFile(wavChunk("fmt ", wavFmt(wavFormatPCM, 2, 48000, 16)), wavChunk("data", data), wavChunk("junk", []byte{0xFF}))
+	w, err := NewWavReader(bytes.NewReader(f))
+	a := assert.New(suite.T())
+	a.NoError(err)
+	a.Equal(2, w.Format.Channels)
+	a.Equal(48000, w.Format.SampleRate)
+	a.Equal(16, w.Format.BitsPerSample)
+	read, err := ioutil.ReadAll(w)
+	a.NoError(err)
+	a.Equal(data, read)
+}
+
+func (suite *WavTestSuite) TestListAndUnknownChunks() {
+	info := new(bytes.Buffer)
+	info.WriteString("INFO")
+	info.Write(wavChunk("INAM", []byte("dong\x00")))
+	info.Write(wavChunk("IART", []byte("husar\x00")))
+	data := []byte{0x0A, 0x00}
+	f := wavFile(wavChunk("fact", []byte{0x01, 0x00, 0x00}), wavChunk("fmt ", wavFmt(wavFormatPCM, 1, 22050, 16)),
+		wavChunk("LIST", info.Bytes()), wavChunk("data", data))
+	w, err := NewWavReader(bytes.NewReader(f))
+	a := assert.New(suite.T())
+	a.NoError(err)
+	a.Equal("dong", w.Info["INAM"])
+	a.Equal("husar", w.Info["IART"])
+	read, _ := ioutil.ReadAll(w)
+	a.Equal(data, read)
+}
+
+func (suite *WavTestSuite) TestExtensible() {
+	ext := new(bytes.Buffer)
+	ext.Write(wavFmt(wavFormatExtensible, 2, 44100, 16))
+	binary.Write(ext, binary.LittleEndian, uint16(22))
+	binary.Write(ext, binary.LittleEndian, uint16(16))
+	binary.Write(ext, binary.LittleEndian, uint32(0x3))
+	binary.Write(ext, binary.LittleEndian, wavFormatPCM)
+	ext.Write(ksDataFormatSuffix)
+	f := wavFile(wavChunk("fmt ", ext.Bytes()), wavChunk("data", []byte{0, 0, 0, 0}))
+	w, err := NewWavReader(bytes.NewReader(f))
+	a := assert.New(suite.T())
+	a.NoError(err)
+	a.Equal(wavFormatPCM, w.Format.Tag)
+	a.Equal(uint32(0x3), w.Format.ChannelMask)
+	a.Equal(44100, w.Format.SampleRate)
+}
+
+func (suite *WavTestSuite) TestSampleFormats() {
+	a := assert.New(suite.T())
+	float := make([]byte, 8)
+	binary.LittleEndian.PutUint32(float, math.Float32bits(0.5))
+	binary.LittleEndian.PutUint32(float[4:], math.Float32bits(-0.25))
+	for _, f := range []struct {
+		tag  uint16
+		bits int
+		data []byte
+	}{
+		{wavFormatPCM, 8, []byte{0xC0, 0x60}},
+		{wavFormatPCM, 24, []byte{0x00, 0x00, 0x40, 0x00, 0x00, 0xE0}},
+		{wavFormatPCM, 32, []byte{0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0xE0}},
+		{wavFormatIEEEFloat, 32, float},
+	} {
+		w, err := NewWavReader(bytes.NewReader(wavFile(wavChunk("fmt ", wavFmt(f.tag, 1, 8000, f.bits)), wavChunk("data", f.data))))
+		if !a.NoError(err, "%d bits", f.bits) {
+			continue
+		}
+		//samples are read as S16LE; wider ones are dithered by at most one LSB
+		out, err := ioutil.ReadAll(w)
+		a.NoError(err)
+		if a.Len(out, 4, "%d bits", f.bits) {
+			a.InDelta(16384, int16(binary.LittleEndian.Uint16(out)), 1, "%d bits", f.bits)
+			a.InDelta(-8192, int16(binary.LittleEndian.Uint16(out[2:])), 1, "%d bits", f.bits)
+		}
+	}
+}
+
+func (suite *WavTestSuite) TestUnsupportedEncodings() {
+	a := assert.New(suite.T())
+	_, err := NewWavReader(bytes.NewReader(wavFile(wavChunk("fmt ", wavFmt(wavFormatIEEEFloat, 1, 8000, 64)), wavChunk("data", nil))))
+	a.Contains(err.Error(), "64-bit IEEE float")
+	_, err = NewWavReader(bytes.NewReader(wavFile(wavChunk("fmt ", wavFmt(wavFormatPCM, 1, 8000, 12)), wavChunk("data", nil))))
+	a.Contains(err.Error(), "12-bit PCM")
+	_, err = NewWavReader(bytes.NewReader(wavFile(wavChunk("fmt ", wavFmt(wavFormatMuLaw, 1, 8000, 8)), wavChunk("data", nil))))
+	a.Contains(err.Error(), "mu-law")
+	_, err = NewWavReader(bytes.NewReader(wavFile(wavChunk("fmt ", wavFmt(0x0055, 1, 8000, 0)), wavChunk("data", nil))))
+	a.Contains(err.Error(), "0x0055")
+}
+
+func (suite *WavTestSuite) TestMalformed() {
+	a := assert.New(suite.T())
+	_, err := NewWavReader(bytes.NewReader([]byte{0x01, 0x02, 0x03}))
+	a.Equal(ErrNotWav, err)
+	_, err = NewWavReader(bytes.NewReader([]byte("OggS\x00\x00\x00\x00\x00\x00\x00\x00")))
+	a.Equal(ErrNotWav, err)
+	_, err = NewWavReader(bytes.NewReader(wavFile(wavChunk("data", []byte{0, 0}))))
+	a.Equal(ErrNoFormat, err)
+	_, err = NewWavReader(bytes.NewReader(wavFile(wavChunk("fmt ", wavFmt(wavFormatPCM, 1, 8000, 16)))))
+	a.Equal(ErrNoData, err)
+	_, err = NewWavReader(bytes.NewReader(wavFile(wavChunk("fmt ", []byte{0x01, 0x00}))))
+	a.Error(err)
+
+	//chunk sizes are not trusted: huge ones are neither allocated nor allowed to overflow
+	fmtChunk := wavChunk("fmt ", wavFmt(wavFormatPCM, 1, 8000, 16))
+	for _, size := range []uint32{0xFFFFFFFF, 0xFFFFFFFE, 0x7FFFFFF0} {
+		hdr := make([]byte, 8)
+		binary.LittleEndian.PutUint32(hdr[4:], size)
+		copy(hdr, "fmt ")
+		_, err = NewWavReader(bytes.NewReader(wavFile(hdr, fmtChunk[8:])))
+		a.Error(err, "fmt size %x", size)
+		copy(hdr, "LIST")
+		_, err = NewWavReader(bytes.NewReader(wavFile(fmtChunk, hdr, []byte("INFO"))))
+		a.Error(err, "LIST size %x", size)
+	}
+	//a large LIST chunk is skipped
+	w, err := NewWavReader(bytes.NewReader(wavFile(fmtChunk, wavChunk("LIST", append([]byte("INFO"), make([]byte, wavMaxListSize)...)), wavChunk("data", []byte{1, 0}))))
+	if a.NoError(err) {
+		a.Empty(w.Info)
+	}
+}
+
+func TestWavTestSuite(t *testing.T) {
+	suite.Run(t, new(WavTestSuite))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	alsa "github.com/mklimuk/test-alsa/alsa"
+	"github.com/mklimuk/test-alsa/audio"
+	"github.com/mklimuk/test-alsa/config"
+	"github.com/mklimuk/test-alsa/sink"
+)
+
+//isALSA tells whether 'backend' plays on the sound card
+func isALSA(backend string) bool {
+	return backend == "" || backend == "alsa"
+}
+
+//newFactory returns the device factory of the output backend selected in 'conf'
+func newFactory(conf *config.AudioConf) (audio.DeviceFactory, error) {
+	switch {
+	case isALSA(conf.Backend):
+		return &alsa.Factory{Format: audio.Format(conf.DeviceFormat)}, nil
+	case conf.Backend == "null":
+		return &sink.NullFactory{}, nil
+	case conf.Backend == "file":
+		return &sink.FileFactory{Dir: conf.SinkDir}, nil
+	case conf.Backend == "pipe":
+		if conf.SinkCommand == "" {
+			return nil, fmt.Errorf("the pipe backend needs a command (sinkCommand)")
+		}
+		return &sink.PipeFactory{Command: conf.SinkCommand}, nil
+	}
+	return nil, fmt.Errorf("unknown audio backend %s; expected alsa, null, file or pipe", conf.Backend)
+}
@@ -0,0 +1,60 @@
+package clip
+
+import (
+	"io"
+	"os"
+
+	"github.com/stretchr/testify/mock"
+)
+
+//StoreMock is a mock of the Store interface
+type StoreMock struct {
+	mock.Mock
+}
+
+//Save is a mocked method
+func (s *StoreMock) Save(id string, description string, r io.Reader) (*Clip, error) {
+	args := s.Called(id, description, r)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Clip), args.Error(1)
+}
+
+//Get is a mocked method
+func (s *StoreMock) Get(id string) (*Clip, error) {
+	args := s.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Clip), args.Error(1)
+}
+
+//Open is a mocked method
+func (s *StoreMock) Open(id string) (*os.File, *Clip, error) {
+	args := s.Called(id)
+	var f *os.File
+	var c *Clip
+	if args.Get(0) != nil {
+		f = args.Get(0).(*os.File)
+	}
+	if args.Get(1) != nil {
+		c = args.Get(1).(*Clip)
+	}
+	return f, c, args.Error(2)
+}
+
+//List is a mocked method
+func (s *StoreMock) List() ([]*Clip, error) {
+	args := s.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*Clip), args.Error(1)
+}
+
+//Delete is a mocked method
+func (s *StoreMock) Delete(id string) error {
+	args := s.Called(id)
+	return args.Error(0)
+}
@@ -0,0 +1,283 @@
+package clip
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/husar/errors"
+	"github.com/mklimuk/test-alsa/audio"
+	"github.com/mklimuk/test-alsa/config"
+)
+
+const (
+	defaultDir     = "/var/lib/husar/clips"
+	defaultMaxSize = 32 << 20
+	metaExt        = ".json"
+	tmpPrefix      = ".upload-"
+)
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,127}$`)
+
+//Clip holds metadata of an audio clip kept on the device
+type Clip struct {
+	ID          string    `json:"id"`
+	Description string    `json:"description"`
+	File        string    `json:"file"`
+	Format      string    `json:"format"`
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum"`
+	Uploaded    time.Time `json:"uploaded"`
+}
+
+//Store manages audio clips uploaded by the central server
+type Store interface {
+	Save(id string, description string, r io.Reader) (*Clip, error)
+	Get(id string) (*Clip, error)
+	Open(id string) (*os.File, *Clip, error)
+	List() ([]*Clip, error)
+	Delete(id string) error
+}
+
+type store struct {
+	mutex   sync.RWMutex
+	dir     string
+	maxSize int64
+}
+
+//NewStore is the clip store constructor; it creates the storage directory if necessary
+func NewStore(conf *config.ClipConf) (Store, error) {
+	s := store{dir: conf.Dir, maxSize: conf.MaxSize}
+	if s.dir == "" {
+		s.dir = defaultDir
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultMaxSize
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	if log.GetLevel() >= log.DebugLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.clip", "method": "NewStore", "dir": s.dir, "maxSize": s.maxSize}).
+			Debug("Clip store configuration")
+	}
+	return &s, nil
+}
+
+//Save stores the clip content read from 'r' replacing any previous clip with the same id
+func (s *store) Save(id string, description string, r io.Reader) (*Clip, error) {
+	if !validID.MatchString(id) {
+		return nil, errors.NewWithCtx("Invalid clip id", errors.BadRequest, map[string]string{"id": id})
+	}
+
+	//the file type is recognized before anything is written to disk
+	head := make([]byte, 12)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+	var format string
+	if format = detectFormat(head); format == "" {
+		return nil, errors.NewWithCtx("Unrecognized audio file format; expected Ogg or WAV", errors.Unrecognized, map[string]string{"id": id})
+	}
+
+	var tmp *os.File
+	if tmp, err = ioutil.TempFile(s.dir, tmpPrefix); err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	var size int64
+	//we read one byte past the limit to detect oversized uploads
+	if size, err = io.Copy(io.MultiWriter(tmp, h), io.LimitReader(io.MultiReader(bytes.NewReader(head), r), s.maxSize+1)); err != nil {
+		return nil, err
+	}
+	if size > s.maxSize {
+		return nil, errors.NewWithCtx("Clip exceeds maximum allowed size", errors.BadRequest, map[string]string{"id": id})
+	}
+	if err = tmp.Sync(); err != nil {
+		return nil, err
+	}
+	//the clip has to play: its header and first packet are decoded before it replaces anything
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err = decodable(tmp); err != nil {
+		return nil, errors.NewWithCtx(fmt.Sprintf("Could not decode audio file: %v", err), errors.Unrecognized, map[string]string{"id": id})
+	}
+
+	c := &Clip{
+		ID:          id,
+		Description: description,
+		File:        id + "." + format,
+		Format:      format,
+		Size:        size,
+		Checksum:    hex.EncodeToString(h.Sum(nil)),
+		Uploaded:    time.Now().UTC(),
+	}
+	var meta []byte
+	if meta, err = json.Marshal(c); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	//a clip may be re-uploaded in a different format
+	if old, err := s.readMeta(id); err == nil && old.File != c.File {
+		os.Remove(filepath.Join(s.dir, old.File))
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(s.dir, c.File)); err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(s.metaPath(id), meta, 0644); err != nil {
+		return nil, err
+	}
+	if log.GetLevel() >= log.InfoLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.clip", "method": "Save", "id": id, "size": size, "checksum": c.Checksum}).
+			Info("Clip stored")
+	}
+	return c, nil
+}
+
+//Get returns metadata of the clip identified by 'id'
+func (s *store) Get(id string) (*Clip, error) {
+	if !validID.MatchString(id) {
+		return nil, errors.NewWithCtx("Clip not found", errors.NotFound, map[string]string{"id": id})
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.readMeta(id)
+}
+
+//Open returns the clip file opened for reading together with its metadata
+func (s *store) Open(id string) (*os.File, *Clip, error) {
+	var c *Clip
+	var err error
+	if c, err = s.Get(id); err != nil {
+		return nil, nil, err
+	}
+	var f *os.File
+	if f, err = os.Open(filepath.Join(s.dir, c.File)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, errors.NewWithCtx("Clip file is missing", errors.NotFound, map[string]string{"id": id})
+		}
+		return nil, nil, err
+	}
+	return f, c, nil
+}
+
+//List returns metadata of all stored clips ordered by id
+func (s *store) List() ([]*Clip, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var files []os.FileInfo
+	var err error
+	if files, err = ioutil.ReadDir(s.dir); err != nil {
+		return nil, err
+	}
+	clips := []*Clip{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), metaExt) {
+			continue
+		}
+		var c *Clip
+		if c, err = s.readMeta(strings.TrimSuffix(f.Name(), metaExt)); err != nil {
+			log.WithFields(log.Fields{"logger": "audio-endpoint.clip", "method": "List", "file": f.Name()}).
+				WithError(err).Warn("Skipping unreadable clip metadata")
+			continue
+		}
+		clips = append(clips, c)
+	}
+	sort.Sort(byID(clips))
+	return clips, nil
+}
+
+//Delete removes the clip and its metadata
+func (s *store) Delete(id string) error {
+	if !validID.MatchString(id) {
+		return errors.NewWithCtx("Clip not found", errors.NotFound, map[string]string{"id": id})
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var c *Clip
+	var err error
+	if c, err = s.readMeta(id); err != nil {
+		return err
+	}
+	if err = os.Remove(filepath.Join(s.dir, c.File)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.Remove(s.metaPath(id)); err != nil {
+		return err
+	}
+	if log.GetLevel() >= log.InfoLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.clip", "method": "Delete", "id": id}).
+			Info("Clip deleted")
+	}
+	return nil
+}
+
+func (s *store) metaPath(id string) string {
+	return filepath.Join(s.dir, id+metaExt)
+}
+
+func (s *store) readMeta(id string) (*Clip, error) {
+	var b []byte
+	var err error
+	if b, err = ioutil.ReadFile(s.metaPath(id)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.NewWithCtx("Clip not found", errors.NotFound, map[string]string{"id": id})
+		}
+		return nil, err
+	}
+	c := new(Clip)
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//decodable checks that 'r' opens with the decoder of the playback service and yields audio
+func decodable(r io.Reader) error {
+	d, err := audio.NewDecoder(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	if _, err = io.ReadAtLeast(d, make([]byte, 4096), 1); err == io.EOF {
+		return fmt.Errorf("no audio data")
+	}
+	return err
+}
+
+//detectFormat recognizes supported containers by their magic numbers
+func detectFormat(head []byte) string {
+	switch {
+	case len(head) >= 4 && string(head[0:4]) == "OggS":
+		return "ogg"
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return "wav"
+	}
+	return ""
+}
+
+type byID []*Clip
+
+func (c byID) Len() int           { return len(c) }
+func (c byID) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byID) Less(i, j int) bool { return c[i].ID < c[j].ID }
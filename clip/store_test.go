@@ -0,0 +1,130 @@
+package clip
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/husar/errors"
+	"github.com/mklimuk/test-alsa/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+//wavData is a mono 8 kHz 16-bit WAV file with two samples
+var wavData = []byte("RIFF\x28\x00\x00\x00WAVEfmt \x10\x00\x00\x00\x01\x00\x01\x00\x40\x1f\x00\x00\x80\x3e\x00\x00\x02\x00\x10\x00data\x04\x00\x00\x00\xe8\x03\x18\xfc")
+
+type StoreTestSuite struct {
+	suite.Suite
+	dir string
+	s   Store
+}
+
+func (suite *StoreTestSuite) SetupSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func (suite *StoreTestSuite) SetupTest() {
+	var err error
+	suite.dir, err = ioutil.TempDir("", "clips")
+	assert.NoError(suite.T(), err)
+	suite.s, err = NewStore(&config.ClipConf{Dir: filepath.Join(suite.dir, "store"), MaxSize: 64 << 10})
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *StoreTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *StoreTestSuite) TestSaveAndGet() {
+	a := assert.New(suite.T())
+	c, err := suite.s.Save("abc-1", "Train delayed", bytes.NewReader(wavData))
+	a.NoError(err)
+	a.Equal("abc-1", c.ID)
+	a.Equal("abc-1.wav", c.File)
+	a.Equal("wav", c.Format)
+	a.Equal(int64(len(wavData)), c.Size)
+	a.Equal("Train delayed", c.Description)
+	a.Len(c.Checksum, 64)
+	a.False(c.Uploaded.IsZero())
+
+	g, err := suite.s.Get("abc-1")
+	a.NoError(err)
+	a.Equal(c.Checksum, g.Checksum)
+
+	f, o, err := suite.s.Open("abc-1")
+	a.NoError(err)
+	defer f.Close()
+	content, _ := ioutil.ReadAll(f)
+	a.Equal(wavData, content)
+	a.Equal(c.ID, o.ID)
+
+	files, _ := ioutil.ReadDir(filepath.Join(suite.dir, "store"))
+	a.Len(files, 2)
+}
+
+func (suite *StoreTestSuite) TestReplace() {
+	a := assert.New(suite.T())
+	ogg, err := ioutil.ReadFile("../audio/testdata/clip.ogg")
+	a.NoError(err)
+	_, err = suite.s.Save("abc", "first", bytes.NewReader(ogg))
+	a.NoError(err)
+	c, err := suite.s.Save("abc", "second", bytes.NewReader(wavData))
+	a.NoError(err)
+	a.Equal("abc.wav", c.File)
+	l, err := suite.s.List()
+	a.NoError(err)
+	a.Len(l, 1)
+	a.Equal("second", l[0].Description)
+	_, err = os.Stat(filepath.Join(suite.dir, "store", "abc.ogg"))
+	a.True(os.IsNotExist(err))
+}
+
+func (suite *StoreTestSuite) TestRejected() {
+	a := assert.New(suite.T())
+	_, err := suite.s.Save("../etc", "", bytes.NewReader(wavData))
+	a.True(errors.IsType(err, errors.BadRequest))
+	_, err = suite.s.Save("mp3", "", bytes.NewReader([]byte("ID3\x03\x00\x00\x00")))
+	a.True(errors.IsType(err, errors.Unrecognized))
+	_, err = suite.s.Save("empty", "", bytes.NewReader(nil))
+	a.True(errors.IsType(err, errors.Unrecognized))
+	_, err = suite.s.Save("large", "", bytes.NewReader(append(wavData, make([]byte, 64<<10)...)))
+	a.True(errors.IsType(err, errors.BadRequest))
+	//files with a known magic number are decoded before they are stored
+	_, err = suite.s.Save("header", "", bytes.NewReader([]byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00vorbis")))
+	a.True(errors.IsType(err, errors.Unrecognized))
+	_, err = suite.s.Save("header", "", bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WAVEfmt ")))
+	a.True(errors.IsType(err, errors.Unrecognized))
+	_, err = suite.s.Save("silent", "", bytes.NewReader(append(wavData[:40], 0, 0, 0, 0)))
+	a.True(errors.IsType(err, errors.Unrecognized))
+	l, err := suite.s.List()
+	a.NoError(err)
+	a.Len(l, 0)
+	files, _ := ioutil.ReadDir(filepath.Join(suite.dir, "store"))
+	a.Len(files, 0)
+}
+
+func (suite *StoreTestSuite) TestListAndDelete() {
+	a := assert.New(suite.T())
+	suite.s.Save("b", "", bytes.NewReader(wavData))
+	suite.s.Save("a", "", bytes.NewReader(wavData))
+	l, err := suite.s.List()
+	a.NoError(err)
+	a.Len(l, 2)
+	a.Equal("a", l[0].ID)
+	a.Equal("b", l[1].ID)
+
+	a.NoError(suite.s.Delete("a"))
+	_, err = suite.s.Get("a")
+	a.True(errors.IsType(err, errors.NotFound))
+	a.True(errors.IsType(suite.s.Delete("a"), errors.NotFound))
+	_, _, err = suite.s.Open("a")
+	a.True(errors.IsType(err, errors.NotFound))
+}
+
+func TestStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(StoreTestSuite))
+}
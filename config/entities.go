@@ -7,6 +7,7 @@ type Conf struct {
 	GPIO  GPIOConf       `yaml:"gpio"`
 	Reg   []RegistryConf `yaml:"registries"`
 	Audio AudioConf      `yaml:"audio" json:"audio"`
+	Clips ClipConf       `yaml:"clips" json:"clips"`
 
 	APIHostname string `yaml:"apiHost"`
 	APIPort     string `yaml:"apiPort"`
@@ -32,7 +33,90 @@ type AudioConf struct {
 	DeviceBuffer  int      `yaml:"deviceBuffer"`
 	PeriodFrames  int      `yaml:"periodFrames"`
 	Periods       int      `yaml:"periods"`
-	ReadBuffer    int      `yaml:"readBuffer"` //in websocket frames
+	JitterTarget  int      `yaml:"jitterTarget"`  //initial websocket playout delay in ms; grows with the measured jitter
+	JitterMax     int      `yaml:"jitterMax"`     //websocket jitter buffer depth in ms above which audio is dropped
+	MaxDrift      int      `yaml:"maxDrift"`      //largest sender clock drift compensated on websocket streams in ppm; 1000 by default
+	VolumeRamp    int      `yaml:"volumeRamp"`    //duration of per-stream gain changes in ms
+	FadeIn        int      `yaml:"fadeIn"`        //fade in at the start of streams in ms; 10 by default, negative disables it
+	FadeOut       int      `yaml:"fadeOut"`       //fade out at the end, stop and preemption of streams in ms; 10 by default, negative disables it
+	Crossfade     bool     `yaml:"crossfade"`     //a stream replacing another one fades in while it fades out instead of after it
+	SuspendBuffer int      `yaml:"suspendBuffer"` //websocket audio in ms kept while a resumable stream is suspended; 60000 by default
+	VolumeFile    string   `yaml:"volumeFile"`    //	/var/lib/husar/volume.json
+	DeviceRate    int      `yaml:"deviceRate"`    //all sources are resampled to this rate; 0 opens the device at the source rate
+	DeviceFormat  string   `yaml:"deviceFormat"`  //S16_LE, S24_LE, S32_LE or FLOAT_LE; the best format the device accepts when empty; audio is mixed in 16 bits and widened
+	DeviceIdle    int      `yaml:"deviceIdle"`    //time in ms the device stays open, fed with silence, after the last stream; 5000 by default, negative closes it at once
+	Mix           bool     `yaml:"mix"`           //streams play together instead of the higher priority one preempting the others
+	AlarmPriority int      `yaml:"alarmPriority"` //priority of alarm signals triggered over REST; it should exceed the ones of announcements; 100 by default
+	TestPriority  int      `yaml:"testPriority"`  //priority of installer test signals when the request does not give one
+
+	LowerPriorityGain *float64   `yaml:"lowerPriorityGain"` //gain in dB of mixed streams below the highest priority; muted when not set
+	Ducking           []DuckConf `yaml:"ducking"`           //streams attenuated instead of preempted or muted under higher priorities
+
+	Chimes        []ChimeConf     `yaml:"chimes"`        //intro and outro chimes streams select by name
+	ChimeDefaults []ChimeRuleConf `yaml:"chimeDefaults"` //chimes of streams requesting an intro without naming one
+
+	DeviceChannels int              `yaml:"deviceChannels"` //all sources are mapped to this many channels; 0 keeps the source layout
+	MonoChannels   []int            `yaml:"monoChannels"`   //device channels (counted from 0) a mono source plays on; all by default
+	ChannelMaps    []ChannelMapConf `yaml:"channelMaps"`    //mixing matrices overriding the default up/down-mixing
+
+	Backend     string `yaml:"backend"`     //audio output: alsa (default), null, file or pipe; overridden by the -backend flag
+	SinkDir     string `yaml:"sinkDir"`     //directory the file backend writes WAV files to; the working directory by default
+	SinkCommand string `yaml:"sinkCommand"` //shell command the pipe backend writes raw S16_LE audio to; RATE and CHANNELS are set in its environment
+}
+
+//ChannelMapConf holds a mixing matrix converting 'In' source channels to 'Out' device channels
+type ChannelMapConf struct {
+	In     int         `yaml:"in"`
+	Out    int         `yaml:"out"`
+	Matrix [][]float64 `yaml:"matrix"` //one row per device channel with one gain per source channel
+}
+
+/*DuckConf attenuates streams with a priority between MinPriority and MaxPriority (and one of Types)
+while a stream with a priority higher than theirs and at least Trigger plays.
+The gain ramps down over Attack and back up over Release once the louder stream ends.
+*/
+type DuckConf struct {
+	MinPriority int      `yaml:"minPriority"`
+	MaxPriority int      `yaml:"maxPriority"` //no upper bound when 0
+	Types       []string `yaml:"types"`       //stream types the rule applies to; all when empty
+	Trigger     int      `yaml:"trigger"`     //lowest priority that ducks; any higher priority when 0
+	Gain        float64  `yaml:"gain"`        //attenuation in dB, e.g. -20
+	Attack      int      `yaml:"attack"`      //in ms
+	Release     int      `yaml:"release"`     //in ms
+}
+
+/*ChimeConf is a named chime played before (intro) or after (outro) a stream. It is either a WAV or Ogg Vorbis File
+or a gong synthesized from Tones struck one after the other.
+*/
+type ChimeConf struct {
+	Name  string     `yaml:"name"`
+	File  string     `yaml:"file"`
+	Tones []ToneConf `yaml:"tones"`
+	Gain  float64    `yaml:"gain"` //in dB relative to the default level of synthesized chimes (-6 dBFS) or to the file
+}
+
+//ToneConf is a single strike of a synthesized gong
+type ToneConf struct {
+	Frequencies []float64 `yaml:"frequencies"` //partials sounding together in Hz, e.g. [659, 1318]
+	Duration    int       `yaml:"duration"`    //in ms until the next tone
+	Attack      int       `yaml:"attack"`      //in ms
+	Decay       int       `yaml:"decay"`       //time constant of the exponential decay in ms; the tone does not decay when 0
+}
+
+//ChimeRuleConf selects the chimes of streams with a priority between MinPriority and MaxPriority and one of Types
+type ChimeRuleConf struct {
+	MinPriority int      `yaml:"minPriority"`
+	MaxPriority int      `yaml:"maxPriority"` //no upper bound when 0
+	Types       []string `yaml:"types"`       //all types when empty
+	Intro       string   `yaml:"intro"`       //chime name
+	Outro       string   `yaml:"outro"`       //chime name; no outro when empty
+}
+
+//ClipConf holds settings of the on-device clip store
+type ClipConf struct {
+	Dir      string `yaml:"dir" json:"dir"`           //	/var/lib/husar/clips
+	MaxSize  int64  `yaml:"maxSize" json:"maxSize"`   // in bytes
+	Priority int    `yaml:"priority" json:"priority"` // used when play request does not specify one
 }
 
 //GPIOConf holds I/O pin mappings and related info
@@ -9,6 +9,7 @@ import (
 	alsa "github.com/mklimuk/test-alsa/alsa"
 	"github.com/mklimuk/test-alsa/api"
 	"github.com/mklimuk/test-alsa/audio"
+	"github.com/mklimuk/test-alsa/clip"
 	"github.com/mklimuk/test-alsa/config"
 	"github.com/mklimuk/websocket"
 )
@@ -16,6 +17,8 @@ import (
 const (
 	defaultLogLevel   string = "warn"
 	defaultConfigPath string = "/etc/husar/playback.yml"
+	//listenAddress is the address of the REST API; the signal subcommand is its client
+	listenAddress string = ":8081"
 )
 
 func main() {
@@ -25,6 +28,7 @@ func main() {
 	var configPath string
 	flag.StringVar(&configPath, "config", defaultConfigPath, "Path to yaml configuration file")
 	level := flag.String("log", "info", "Log level")
+	backend := flag.String("backend", "", "Audio output: alsa, null, file or pipe; overrides the configuration")
 	flag.Parse()
 
 	var l log.Level
@@ -35,17 +39,50 @@ func main() {
 	}
 	log.SetLevel(l)
 
+	//the signal subcommand asks the running playback service to play a test signal and exits
+	if flag.Arg(0) == "signal" {
+		if err = playSignal(flag.Args()[1:]); err != nil {
+			clog.WithError(err).Fatal("Could not play test signal")
+		}
+		return
+	}
+
 	conf := config.Parse(configPath)
-	d := &alsa.Factory{}
+	if *backend != "" {
+		conf.Audio.Backend = *backend
+	}
+	var d audio.DeviceFactory
+	if d, err = newFactory(&(conf.Audio)); err != nil {
+		clog.WithError(err).Fatal("Could not initialize audio output")
+	}
 	p := audio.New(&(conf.Audio), d, "/etc/husar/dong.wav")
 	f := websocket.NewFactory()
+	var s clip.Store
+	if s, err = clip.NewStore(&(conf.Clips)); err != nil {
+		clog.WithError(err).Fatal("Could not initialize clip store")
+	}
+
+	//the hardware mixer is preferred; devices without one get a software master gain
+	var mixer func() (audio.Mixer, error)
+	if isALSA(conf.Audio.Backend) {
+		mixer = func() (audio.Mixer, error) { return alsa.NewMixer(conf.Audio.Mixer) }
+	}
+	v := audio.NewPersistentVolume(audio.NewVolumeControl(mixer, p.Master()), conf.Audio.VolumeFile)
 
 	clog.Info("Initializing REST router...")
 	z := api.NewPlaybackAPI(p, f)
+	c := api.NewClipAPI(s, p, &(conf.Clips))
+	vol := api.NewVolumeAPI(v)
+	al := api.NewAlarmAPI(p, &(conf.Audio))
+	sig := api.NewSignalAPI(p, &(conf.Audio))
 
 	router := gin.New()
 	z.AddRoutes(router)
+	c.AddRoutes(router)
+	vol.AddRoutes(router)
+	al.AddRoutes(router)
+	sig.AddRoutes(router)
 
-	clog.Fatal(http.ListenAndServe(":8081", router))
+	clog.Fatal(http.ListenAndServe(listenAddress, router))
 
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/audio"
+)
+
+//signalTimeout bounds the request to the playback service
+const signalTimeout = 10 * time.Second
+
+//playSignal asks the playback service to play the test signal described by the subcommand arguments 'args'
+//(POST /audio/signal) so that the signal is admitted by the busy and priority rules of the running service;
+//it returns once the service has started the signal
+func playSignal(args []string) error {
+	fs := flag.NewFlagSet("signal", flag.ExitOnError)
+	service := fs.String("service", "http://localhost"+listenAddress, "URL of the playback service")
+	kind := fs.String("type", string(audio.SignalSine), "Test signal: sine, sweep, pink, white or channels")
+	fs.Float64("frequency", 0, "Tone frequency (the start of sweeps) in Hz")
+	fs.Float64("to", 0, "End of sweeps in Hz")
+	fs.Float64("level", 0, "Peak level in dBFS")
+	fs.Int("duration", 0, "Duration in ms")
+	fs.Int("priority", 0, "Stream priority; the test priority of the service when omitted")
+	fs.Parse(args)
+	//only the parameters given are sent; a zero value would select the default instead
+	form := url.Values{"type": {*kind}}
+	var zero string
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "service", "type":
+			return
+		case "priority":
+		default:
+			if f.Value.String() == "0" {
+				zero = f.Name
+			}
+		}
+		form.Set(f.Name, f.Value.String())
+	})
+	if zero != "" {
+		return fmt.Errorf("%s must not be 0; omit it to use the default", zero)
+	}
+
+	client := &http.Client{Timeout: signalTimeout}
+	res, err := client.PostForm(*service+"/audio/signal", form)
+	if err != nil {
+		return fmt.Errorf("could not reach the playback service: %v", err)
+	}
+	defer res.Body.Close()
+	var body map[string]string
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("playback service refused the signal (%s): %s", res.Status, body["error"])
+	}
+	log.WithFields(log.Fields{"logger": "mic-receiver.main", "method": "playSignal", "signal": body["signal"]}).
+		Info("Test signal started")
+	return nil
+}
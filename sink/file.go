@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/audio"
+)
+
+const wavHeaderSize = 44
+
+//FileFactory implements audio.DeviceFactory with devices writing 16-bit PCM WAV files
+type FileFactory struct {
+	//Dir is the directory of the files; the working directory when empty
+	Dir string
+}
+
+//New creates a WAV file named after the current time; the device is paced as a sound card would be
+func (f *FileFactory) New(sampleRate int, channels int, bp *audio.BufferParams) (audio.PlaybackDevice, error) {
+	name := filepath.Join(f.Dir, time.Now().Format("20060102-150405.000")+".wav")
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	d := &fileDevice{file: file, channels: channels, rate: sampleRate, pacer: newPacer(sampleRate, bp)}
+	//the sizes are filled in when the device is closed
+	if err = d.header(0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if log.GetLevel() >= log.InfoLevel {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.sink", "method": "New", "file": name}).
+			Info("Writing playback to file")
+	}
+	return audio.NewPlaybackDevice(d, bp.BufferFrames), nil
+}
+
+type fileDevice struct {
+	file     *os.File
+	channels int
+	rate     int
+	size     uint32
+	pacer    *pacer
+}
+
+//header writes the WAV header for 'size' bytes of audio at the start of the file
+func (d *fileDevice) header(size uint32) error {
+	h := make([]byte, wavHeaderSize)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], wavHeaderSize-8+size)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1)
+	binary.LittleEndian.PutUint16(h[22:], uint16(d.channels))
+	binary.LittleEndian.PutUint32(h[24:], uint32(d.rate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(d.rate*d.channels*2))
+	binary.LittleEndian.PutUint16(h[32:], uint16(d.channels*2))
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], size)
+	_, err := d.file.WriteAt(h, 0)
+	return err
+}
+
+func (d *fileDevice) Write(buffer interface{}) (int, error) {
+	samples, n := frames(buffer, d.channels)
+	b := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(b[2*i:], uint16(s))
+	}
+	if _, err := d.file.WriteAt(b, wavHeaderSize+int64(d.size)); err != nil {
+		return 0, err
+	}
+	d.size += uint32(len(b))
+	d.pacer.wait(n)
+	return n, nil
+}
+
+//Drop keeps what has been written already; only the pacing starts over
+func (d *fileDevice) Drop() error {
+	d.pacer.reset()
+	return nil
+}
+
+func (d *fileDevice) Close() {
+	d.pacer.drain()
+	if err := d.header(d.size); err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.sink", "method": "Close", "file": d.file.Name()}).
+			WithError(err).Error("Could not write WAV header")
+	}
+	if err := d.file.Close(); err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.sink", "method": "Close", "file": d.file.Name()}).
+			WithError(err).Error("Could not close file")
+	}
+}
@@ -0,0 +1,33 @@
+package sink
+
+import (
+	"github.com/mklimuk/test-alsa/audio"
+)
+
+//NullFactory implements audio.DeviceFactory with devices discarding audio in real time
+type NullFactory struct{}
+
+//New returns a device discarding everything written to it
+func (f *NullFactory) New(sampleRate int, channels int, bp *audio.BufferParams) (audio.PlaybackDevice, error) {
+	return audio.NewPlaybackDevice(&nullDevice{channels: channels, pacer: newPacer(sampleRate, bp)}, bp.BufferFrames), nil
+}
+
+type nullDevice struct {
+	channels int
+	pacer    *pacer
+}
+
+func (d *nullDevice) Write(buffer interface{}) (int, error) {
+	_, n := frames(buffer, d.channels)
+	d.pacer.wait(n)
+	return n, nil
+}
+
+func (d *nullDevice) Drop() error {
+	d.pacer.reset()
+	return nil
+}
+
+func (d *nullDevice) Close() {
+	d.pacer.drain()
+}
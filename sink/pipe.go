@@ -0,0 +1,66 @@
+package sink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/audio"
+)
+
+//PipeFactory implements audio.DeviceFactory with devices writing raw S16_LE audio to the standard input of a command
+type PipeFactory struct {
+	//Command is run with 'sh -c' for every device; RATE and CHANNELS are set in its environment,
+	//e.g. "aplay -t raw -f S16_LE -r $RATE -c $CHANNELS"
+	Command string
+}
+
+//New starts the command; it is expected to exit once its standard input is closed
+func (f *PipeFactory) New(sampleRate int, channels int, bp *audio.BufferParams) (audio.PlaybackDevice, error) {
+	cmd := exec.Command("sh", "-c", f.Command)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("RATE=%d", sampleRate), fmt.Sprintf("CHANNELS=%d", channels))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return audio.NewPlaybackDevice(&pipeDevice{cmd: cmd, in: in, channels: channels, pacer: newPacer(sampleRate, bp)}, bp.BufferFrames), nil
+}
+
+type pipeDevice struct {
+	cmd      *exec.Cmd
+	in       io.WriteCloser
+	channels int
+	pacer    *pacer
+}
+
+func (d *pipeDevice) Write(buffer interface{}) (int, error) {
+	samples, n := frames(buffer, d.channels)
+	if err := binary.Write(d.in, binary.LittleEndian, samples); err != nil {
+		return 0, err
+	}
+	d.pacer.wait(n)
+	return n, nil
+}
+
+//Drop cannot take back what the command has read; only the pacing starts over
+func (d *pipeDevice) Drop() error {
+	d.pacer.reset()
+	return nil
+}
+
+func (d *pipeDevice) Close() {
+	d.pacer.drain()
+	d.in.Close()
+	if err := d.cmd.Wait(); err != nil {
+		log.WithFields(log.Fields{"logger": "audio-endpoint.sink", "method": "Close", "command": d.cmd.Args[2]}).
+			WithError(err).Warn("Output command failed")
+	}
+}
@@ -0,0 +1,84 @@
+/*Package sink provides playback devices that do not need a sound card: a null sink discarding audio,
+a file sink writing WAV files and a pipe sink feeding an external command. All of them consume audio
+at the pace of a real device so that the playback service behaves as it does with ALSA.
+*/
+package sink
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mklimuk/test-alsa/audio"
+)
+
+//pacer holds writes back so that audio is consumed in real time; up to 'ahead' may be written in advance
+//as a device would buffer it
+type pacer struct {
+	mutex  sync.Mutex
+	rate   int
+	ahead  time.Duration
+	start  time.Time
+	frames int64
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+func newPacer(rate int, bp *audio.BufferParams) *pacer {
+	p := &pacer{rate: rate, now: time.Now, sleep: time.Sleep}
+	if bp != nil {
+		frames := bp.BufferFrames
+		if frames <= 0 {
+			frames = bp.PeriodFrames * bp.Periods
+		}
+		p.ahead = time.Duration(frames) * time.Second / time.Duration(rate)
+	}
+	return p
+}
+
+//due returns the time the audio written so far is played out
+func (p *pacer) due() time.Time {
+	return p.start.Add(time.Duration(p.frames) * time.Second / time.Duration(p.rate))
+}
+
+//wait accounts for 'frames' written and blocks until they fit in the time buffered
+func (p *pacer) wait(frames int) {
+	p.mutex.Lock()
+	now := p.now()
+	if p.start.IsZero() || p.due().Before(now) {
+		//the writer fell behind (an underrun on a real device): the clock starts over
+		p.start, p.frames = now, 0
+	}
+	p.frames += int64(frames)
+	d := p.due().Sub(now) - p.ahead
+	p.mutex.Unlock()
+	if d > 0 {
+		p.sleep(d)
+	}
+}
+
+//drain blocks until everything written has been played out
+func (p *pacer) drain() {
+	p.mutex.Lock()
+	var d time.Duration
+	if !p.start.IsZero() {
+		d = p.due().Sub(p.now())
+	}
+	p.mutex.Unlock()
+	if d > 0 {
+		p.sleep(d)
+	}
+}
+
+//reset forgets the audio written so far (it has been dropped)
+func (p *pacer) reset() {
+	p.mutex.Lock()
+	p.start = time.Time{}
+	p.frames = 0
+	p.mutex.Unlock()
+}
+
+//frames returns the number of frames in 'buffer' written by audio.PlaybackDevice
+func frames(buffer interface{}, channels int) ([]int16, int) {
+	samples, _ := buffer.([]int16)
+	return samples, len(samples) / channels
+}
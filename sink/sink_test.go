@@ -0,0 +1,128 @@
+package sink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mklimuk/test-alsa/audio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type SinkTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *SinkTestSuite) SetupSuite() {
+	log.SetLevel(log.ErrorLevel)
+}
+
+func (suite *SinkTestSuite) TearDownSuite() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func (suite *SinkTestSuite) SetupTest() {
+	suite.dir, _ = ioutil.TempDir("", "sink")
+}
+
+func (suite *SinkTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+//play writes 'periods' buffers of 80 frames of a rising ramp to 'd' and drains it
+func play(d audio.PlaybackDevice, channels int, periods int) {
+	buf := make(chan []int16)
+	d.WriteAsync(buf)
+	for i := 0; i < periods; i++ {
+		samples := make([]int16, 80*channels)
+		for j := range samples {
+			samples[j] = int16(i*len(samples) + j)
+		}
+		buf <- samples
+	}
+	close(buf)
+	d.Drain()
+}
+
+func (suite *SinkTestSuite) TestPacer() {
+	a := assert.New(suite.T())
+	var now time.Time
+	var slept time.Duration
+	p := newPacer(8000, &audio.BufferParams{PeriodFrames: 80, Periods: 2})
+	p.now = func() time.Time { return now }
+	p.sleep = func(d time.Duration) { slept += d; now = now.Add(d) }
+	now = time.Unix(1000, 0)
+	//two periods fit in the buffer
+	p.wait(80)
+	p.wait(80)
+	a.Equal(time.Duration(0), slept)
+	p.wait(80)
+	a.Equal(10*time.Millisecond, slept)
+	p.drain()
+	a.Equal(30*time.Millisecond, slept)
+	//a writer late after an underrun does not catch up in a burst
+	now = now.Add(time.Second)
+	p.wait(80)
+	p.wait(80)
+	a.Equal(30*time.Millisecond, slept)
+	p.reset()
+	p.drain()
+	a.Equal(30*time.Millisecond, slept)
+}
+
+func (suite *SinkTestSuite) TestNull() {
+	a := assert.New(suite.T())
+	d, err := (&NullFactory{}).New(8000, 2, &audio.BufferParams{BufferFrames: 160})
+	a.NoError(err)
+	start := time.Now()
+	play(d, 2, 10)
+	//800 frames at 8 kHz are played out in real time
+	a.True(time.Since(start) >= 100*time.Millisecond, "played in %s", time.Since(start))
+	a.Equal(800, d.FramesWrote())
+}
+
+func (suite *SinkTestSuite) TestFile() {
+	a := assert.New(suite.T())
+	d, err := (&FileFactory{Dir: suite.dir}).New(8000, 2, &audio.BufferParams{BufferFrames: 800})
+	a.NoError(err)
+	play(d, 2, 4)
+	files, _ := filepath.Glob(filepath.Join(suite.dir, "*.wav"))
+	if !a.Len(files, 1) {
+		return
+	}
+	f, _ := os.Open(files[0])
+	defer f.Close()
+	r, err := audio.NewWavReader(f)
+	if !a.NoError(err) {
+		return
+	}
+	a.Equal(8000, r.SampleRate())
+	a.Equal(2, r.Channels())
+	data, _ := ioutil.ReadAll(r)
+	a.Len(data, 4*80*2*2)
+	a.Equal([]byte{1, 0}, data[2:4])
+	_, err = (&FileFactory{Dir: filepath.Join(suite.dir, "missing")}).New(8000, 2, &audio.BufferParams{})
+	a.Error(err)
+}
+
+func (suite *SinkTestSuite) TestPipe() {
+	a := assert.New(suite.T())
+	out := filepath.Join(suite.dir, "out.raw")
+	d, err := (&PipeFactory{Command: "echo $RATE $CHANNELS > " + out + ".env; cat > " + out}).New(8000, 1, &audio.BufferParams{BufferFrames: 800})
+	a.NoError(err)
+	play(d, 1, 3)
+	data, _ := ioutil.ReadFile(out)
+	a.Len(data, 3*80*2)
+	a.Equal([]byte{81, 0}, data[162:164])
+	env, _ := ioutil.ReadFile(out + ".env")
+	a.Equal("8000 1\n", string(env))
+}
+
+func TestSinkTestSuite(t *testing.T) {
+	suite.Run(t, new(SinkTestSuite))
+}